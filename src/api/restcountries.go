@@ -5,16 +5,28 @@ import (
 )
 
 const (
-	API_BASE      = "http://129.241.150.113:8080/"
-	STUB_BASE     = "http://localhost:8081/"
-	API_VERSION   = "v3.1"
-	ENDPOINT_NAME = "name"
-	ENDPOINT_CCA  = "alpha"
+	API_BASE       = "http://129.241.150.113:8080/"
+	STUB_BASE      = "http://localhost:8081/"
+	API_VERSION    = "v3.1"
+	ENDPOINT_NAME  = "name"
+	ENDPOINT_CCA   = "alpha"
+	ENDPOINT_ALL   = "all"
+	COUNTRY_FIELDS = "cca3,name,region,subregion,borders"
 )
 
-type country struct {
-	Borders []string          `json:"borders"`
-	Name    map[string]string `json:"name"`
+// Country holds the subset of REST Countries fields used by the service
+type Country struct {
+	CCA3      string      `json:"cca3"`
+	Name      CountryName `json:"name"`
+	Region    string      `json:"region"`
+	Subregion string      `json:"subregion"`
+	Borders   []string    `json:"borders"`
+}
+
+// CountryName holds the common and official name of a country
+type CountryName struct {
+	Common   string `json:"common"`
+	Official string `json:"official"`
 }
 
 // GetNeighbours takes a name string
@@ -32,7 +44,7 @@ func GetNeighbours(name string) ([]string, error) {
 	cl.AddQuery("fields", "borders")
 
 	// Perform get request
-	resp := []country{}
+	resp := []Country{}
 	err = cl.GetAndDecode(&resp)
 	if err != nil {
 		return nil, err
@@ -55,7 +67,7 @@ func GetNeighboursCca(cca string, baseURL string) ([]string, error) {
 	cl.AddQuery("fields", "borders")
 
 	// Perform GET request
-	resp := country{}
+	resp := Country{}
 	err = cl.GetAndDecode(&resp)
 
 	if err != nil {
@@ -64,3 +76,48 @@ func GetNeighboursCca(cca string, baseURL string) ([]string, error) {
 
 	return resp.Borders, nil
 }
+
+// GetCountryCca takes a cca3 code and returns the metadata
+// for that country, including its bordering countries.
+func GetCountryCca(cca string, baseURL string) (Country, error) {
+	// Instantiate client
+	cl := web_client.NewClient()
+	err := cl.SetURL(baseURL, API_VERSION, ENDPOINT_CCA, cca)
+	if err != nil {
+		return Country{}, err
+	}
+
+	// Add query
+	cl.AddQuery("fields", COUNTRY_FIELDS)
+
+	// Perform GET request
+	resp := Country{}
+	err = cl.GetAndDecode(&resp)
+	if err != nil {
+		return Country{}, err
+	}
+
+	return resp, nil
+}
+
+// GetAllCountries returns the metadata for every country known by the API
+func GetAllCountries(baseURL string) ([]Country, error) {
+	// Instantiate client
+	cl := web_client.NewClient()
+	err := cl.SetURL(baseURL, API_VERSION, ENDPOINT_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add query
+	cl.AddQuery("fields", COUNTRY_FIELDS)
+
+	// Perform GET request
+	resp := []Country{}
+	err = cl.GetAndDecode(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"assignment2/internal/backup"
+	"assignment2/internal/store"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	exportCommand = "export"
+	importCommand = "import"
+)
+
+// runBackupCommand runs the export or import subcommand against the store configured in the environment,
+// and returns the exit code. The service should be stopped while importing, as running instances keep
+// their registrations and counts in memory.
+func runBackupCommand(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the archive to (default: standard output)")
+	mode := flags.String("mode", backup.Merge, "import mode, merge or replace")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app export [-o file] | app import [-mode merge|replace] file")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if command == importCommand && flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	st, err := store.Open(storeConfig())
+	if err != nil {
+		log.Println("Could not open store: " + err.Error())
+		return 1
+	}
+	defer st.Close()
+
+	if command == exportCommand {
+		err = exportStore(st, *output)
+	} else {
+		err = importStore(st, flags.Arg(0), *mode)
+	}
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	return 0
+}
+
+// exportStore writes an archive of the store to the file, or to standard output if `path` is empty
+func exportStore(st store.Store, path string) error {
+	archive, err := backup.Export(st)
+	if err != nil {
+		return fmt.Errorf("could not export the store: %w", err)
+	}
+	var w io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+	log.Printf("Exported %d registrations and %d invocation counts from %s", len(archive.Registrations), len(archive.InvocationCounts), st.Name())
+	return nil
+}
+
+// importStore restores the archive in the file into the store with the merge or replace mode
+func importStore(st store.Store, path string, mode string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive, err := backup.Read(file)
+	if err != nil {
+		return err
+	}
+	if err := backup.Import(st, archive, mode); err != nil {
+		return fmt.Errorf("could not import the archive: %w", err)
+	}
+	log.Printf("Imported %d registrations and %d invocation counts into %s in %s mode", len(archive.Registrations), len(archive.InvocationCounts), st.Name(), mode)
+	return nil
+}
@@ -1,22 +1,126 @@
 package main
 
 import (
-	"assignment2/internal/types"
 	"assignment2/internal/utils"
 	"assignment2/internal/web"
+	"assignment2/res"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
-	"path"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	// The export, import and migrate subcommands maintain the store instead of running the service
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case exportCommand, importCommand:
+			os.Exit(runBackupCommand(os.Args[1], os.Args[2:]))
+		case migrateCommand:
+			os.Exit(runMigrateCommand(os.Args[2:]))
+		}
+	}
+
+	// Datasets are compiled into the binary, but can be overridden by external files
+	csvPath := flag.String("csv", utils.GetEnvStr("RENEWABLES_CSV", res.Embedded),
+		"path to renewable share energy CSV file (default: embedded dataset, env: RENEWABLES_CSV)")
+	countriesJSON := flag.String("countries", utils.GetEnvStr("COUNTRIES_JSON", res.Embedded),
+		"path to REST Countries JSON file used in embedded mode (default: embedded dataset, env: COUNTRIES_JSON)")
+	flag.Parse()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Println("$PORT has not been set. Default: 8080")
 		port = "8080"
 	}
 	utils.ResetUptime()
-	s := web.NewService(path.Join("res", types.CSVFilePath), web.UseRestCountries{}, web.WithFirestore{})
-	log.Fatal(http.ListenAndServe(":"+port, web.SetupRoutes(port, s)))
+
+	// Country metadata is served by a chain of providers, which fails over to the next provider
+	// when one is down, and can be answered offline in "embedded" mode
+	mode, err := web.ParseCountriesProviders(utils.GetEnvStr("COUNTRIES_PROVIDERS", "remote,stub,embedded"),
+		*countriesJSON, utils.GetEnvDuration("COUNTRIES_FAILOVER_COOLDOWN", web.CountriesCooldown))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Country metadata is cached in memory, and can optionally be warmed up from /all at startup
+	countries := web.NewCachedRestCountries(mode, utils.GetEnvDuration("COUNTRIES_CACHE_TTL", web.CountriesCacheTTL))
+	if utils.GetEnvBool("COUNTRIES_CACHE_PREFETCH", false) {
+		if err := countries.Prefetch(); err != nil {
+			log.Println("Could not prefetch countries cache: " + err.Error())
+		}
+	}
+
+	cachePolicy, err := web.ParseCacheTTLPolicy(utils.GetEnvStr("CACHE_TTL_POLICY", ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Registrations, invocation counts and cached responses are persisted in Firestore by default,
+	// or in a local SQLite database or JSON file for deployments without Firebase credentials
+	storage, err := web.ParseStorageMode(storeConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := web.NewService(*csvPath, countries, storage,
+		web.CacheConfig(utils.GetEnvInt("CACHE_SIZE", web.CacheSize),
+			utils.GetEnvDuration("CACHE_TTL", web.CacheTTL),
+			utils.GetEnvDuration("FIRESTORE_CACHE_TTL", web.FirestoreCacheTTL)),
+		web.HTTPCacheConfig(utils.GetEnvDuration("HTTP_CACHE_MAX_AGE", web.HTTPCacheMaxAge)),
+		web.CacheTTLPolicy(cachePolicy),
+		web.CacheSweepConfig(utils.GetEnvDuration("CACHE_SWEEP_INTERVAL", web.CacheSweepInterval)),
+		web.WarmupConfig(utils.GetEnvInt("CACHE_WARMUP_SIZE", web.WarmupSize),
+			utils.GetEnvDuration("CACHE_WARMUP_TIMEOUT", web.WarmupTimeout)),
+		web.JournalConfig(utils.GetEnvStr("JOURNAL_DIR", "journal")),
+		web.InstanceID(utils.GetEnvStr("INSTANCE_ID", "")),
+		web.MigrateConfig(utils.GetEnvBool("MIGRATE_ON_STARTUP", false)),
+		web.SnapshotConfig(utils.GetEnvStr("SNAPSHOT_DIR", ""),
+			utils.GetEnvDuration("SNAPSHOT_INTERVAL", web.SnapshotInterval),
+			utils.GetEnvInt("SNAPSHOT_KEEP", web.SnapshotKeep)),
+		web.WebhookConfig(utils.GetEnvInt("WEBHOOK_WORKERS", web.WebhookWorkers),
+			utils.GetEnvInt("WEBHOOK_QUEUE_SIZE", web.WebhookQueueSize),
+			utils.GetEnvInt("WEBHOOK_HOST_LIMIT", web.WebhookHostLimit)),
+		web.WebhookRetryConfig(utils.GetEnvInt("WEBHOOK_MAX_ATTEMPTS", web.WebhookMaxAttempts),
+			utils.GetEnvDuration("WEBHOOK_RETRY_BACKOFF", web.WebhookRetryBackoff)),
+		web.DeliveryLogConfig(utils.GetEnvInt("DELIVERY_LOG_SIZE", web.DeliveryLogSize)),
+		web.AdminToken(utils.GetEnvStr("ADMIN_TOKEN", "")))
+	server := &http.Server{Addr: ":" + port, Handler: web.SetupRoutes(port, s)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM, stop accepting requests, drain in-flight requests and webhook deliveries,
+	// and write the pending updates to the store before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		utils.GetEnvDuration("SHUTDOWN_TIMEOUT", web.ShutdownTimeout))
+	exitCode := 0
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Could not drain HTTP requests: " + err.Error())
+		exitCode = 1
+	}
+	if err := s.Close(shutdownCtx); err != nil {
+		log.Println(err.Error())
+		exitCode = 1
+	}
+	cancel()
+	log.Println("Shut down")
+	os.Exit(exitCode)
+}
+
+// storeConfig returns the store backend and the path of its database or JSON file from the environment
+func storeConfig() (string, string) {
+	backend := utils.GetEnvStr("STORE_BACKEND", "firestore")
+	return backend, utils.GetEnvStr("STORE_PATH", "renewables."+backend)
 }
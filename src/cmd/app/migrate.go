@@ -0,0 +1,51 @@
+package main
+
+import (
+	"assignment2/internal/store"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+const migrateCommand = "migrate"
+
+// runMigrateCommand upgrades the documents of earlier schema versions in the store configured in the
+// environment, prints the report, and returns the exit code. With -dry-run, nothing is written.
+func runMigrateCommand(args []string) int {
+	flags := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report the documents that would be upgraded without writing them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app migrate [-dry-run]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	st, err := store.Open(storeConfig())
+	if err != nil {
+		log.Println("Could not open store: " + err.Error())
+		return 1
+	}
+	defer st.Close()
+
+	report, err := st.Migrate(*dryRun)
+	if err != nil {
+		log.Println("Could not migrate store: " + err.Error())
+		return 1
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	log.Println("Store schema: " + report.String())
+	return 0
+}
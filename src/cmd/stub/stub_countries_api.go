@@ -2,17 +2,24 @@ package main
 
 import (
 	"assignment2/internal/stub/stub_countries_api"
+	"assignment2/internal/utils"
+	"assignment2/res"
+	"flag"
 	"log"
 	"net/http"
-	"path"
 )
 
 func main() {
+	// The dataset is compiled into the binary, but can be overridden by an external file
+	countriesJSON := flag.String("countries", utils.GetEnvStr("COUNTRIES_JSON", res.Embedded),
+		"path to REST Countries JSON file (default: embedded dataset, env: COUNTRIES_JSON)")
+	flag.Parse()
+
 	port := stub_countries_api.StubServicePort
 
 	domainNamePort := "http://localhost:" + port
 
-	CountriesData := stub_countries_api.ParseJSON(path.Join("res", stub_countries_api.JSONFileName))
+	CountriesData := stub_countries_api.ParseJSON(*countriesJSON)
 	handler := http.HandlerFunc(stub_countries_api.StubHandler(&CountriesData))
 
 	log.Println("Started services on:")
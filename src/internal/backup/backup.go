@@ -0,0 +1,225 @@
+// Package backup exports the persisted state of the service to versioned JSON archives, imports them
+// back into a store, and keeps a rotating set of snapshots in a local directory.
+package backup
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	Merge   = "merge"   // import mode keeping the state that is not in the archive
+	Replace = "replace" // import mode deleting the state that is not in the archive
+
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".json"
+	snapshotLayout = "20060102T150405Z" // sorts in chronological order
+)
+
+// Export reads the registrations, invocation counts, buckets, dead letters and delivery logs from the store
+// into an archive. Everything is sorted by its ID, so that archives of the same state are identical.
+func Export(st store.Store) (*types.Archive, error) {
+	registrations, err := st.GetAllRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := st.GetAllInvocationCounts()
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := st.GetInvocationBuckets(time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return nil, err
+	}
+	deadLetters, err := st.GetAllDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+	logs, err := st.GetAllDeliveries()
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &types.Archive{
+		Version:           types.ArchiveVersion,
+		CreatedAt:         time.Now().UTC(),
+		Backend:           st.Name(),
+		Registrations:     make([]types.InvocationRegistration, 0, len(registrations)),
+		InvocationCounts:  counts,
+		InvocationBuckets: buckets,
+		DeadLetters:       make([]types.DeadLetter, 0, len(deadLetters)),
+		Deliveries:        make([]types.DeliveryLog, 0, len(logs)),
+	}
+	for _, registration := range registrations {
+		archive.Registrations = append(archive.Registrations, registration)
+	}
+	for _, deadLetter := range deadLetters {
+		archive.DeadLetters = append(archive.DeadLetters, deadLetter)
+	}
+	for _, deliveries := range logs {
+		archive.Deliveries = append(archive.Deliveries, deliveries)
+	}
+	sort.Slice(archive.Registrations, func(i, j int) bool {
+		return archive.Registrations[i].WebhookID < archive.Registrations[j].WebhookID
+	})
+	sort.Slice(archive.InvocationBuckets, func(i, j int) bool {
+		return archive.InvocationBuckets[i].ID() < archive.InvocationBuckets[j].ID()
+	})
+	sort.Slice(archive.DeadLetters, func(i, j int) bool {
+		return archive.DeadLetters[i].ID < archive.DeadLetters[j].ID
+	})
+	sort.Slice(archive.Deliveries, func(i, j int) bool {
+		return archive.Deliveries[i].WebhookID < archive.Deliveries[j].WebhookID
+	})
+	if archive.InvocationBuckets == nil {
+		archive.InvocationBuckets = []types.InvocationBucket{}
+	}
+	return archive, nil
+}
+
+// Read decodes and validates an archive. Archives written by a later version of the service are rejected.
+func Read(r io.Reader) (*types.Archive, error) {
+	var archive types.Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("could not decode archive: %w", err)
+	}
+	if archive.Version < 1 || archive.Version > types.ArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d, expected 1 to %d", archive.Version, types.ArchiveVersion)
+	}
+
+	seen := make(map[string]bool, len(archive.Registrations))
+	for _, registration := range archive.Registrations {
+		if registration.WebhookID == "" {
+			return nil, errors.New("registration without webhook_id in archive")
+		}
+		if seen[registration.WebhookID] {
+			return nil, errors.New("duplicate registration " + registration.WebhookID + " in archive")
+		}
+		seen[registration.WebhookID] = true
+		switch {
+		case registration.IsThreshold():
+			if registration.Threshold <= 0 || registration.Threshold >= 100 {
+				return nil, errors.New("registration " + registration.WebhookID + " must have a threshold between 0 and 100")
+			}
+		case registration.Type != "" && registration.Type != types.RegistrationCalls:
+			return nil, errors.New("registration " + registration.WebhookID + " has unknown type \"" + registration.Type + "\"")
+		case registration.Calls < 1:
+			return nil, errors.New("registration " + registration.WebhookID + " must have at least 1 call")
+		}
+		if !strings.HasPrefix(registration.URL, "http://") && !strings.HasPrefix(registration.URL, "https://") {
+			return nil, errors.New("registration " + registration.WebhookID + " must have an http:// or https:// URL")
+		}
+	}
+	for country, count := range archive.InvocationCounts {
+		if count < 0 {
+			return nil, errors.New("negative invocation count for " + country + " in archive")
+		}
+	}
+	for _, bucket := range archive.InvocationBuckets {
+		if bucket.Granularity != types.Hourly && bucket.Granularity != types.Daily {
+			return nil, errors.New("unknown bucket granularity \"" + bucket.Granularity + "\" in archive")
+		}
+		if bucket.Count < 0 {
+			return nil, errors.New("negative count in bucket " + bucket.ID() + " in archive")
+		}
+	}
+	deadLetters := make(map[string]bool, len(archive.DeadLetters))
+	for _, deadLetter := range archive.DeadLetters {
+		if deadLetter.ID == "" {
+			return nil, errors.New("dead letter without id in archive")
+		}
+		if deadLetters[deadLetter.ID] {
+			return nil, errors.New("duplicate dead letter " + deadLetter.ID + " in archive")
+		}
+		deadLetters[deadLetter.ID] = true
+	}
+	// delivery logs are deleted together with their registration, so that they cannot be imported without it
+	logs := make(map[string]bool, len(archive.Deliveries))
+	for _, deliveries := range archive.Deliveries {
+		if !seen[deliveries.WebhookID] {
+			return nil, errors.New("delivery log of unknown registration \"" + deliveries.WebhookID + "\" in archive")
+		}
+		if logs[deliveries.WebhookID] {
+			return nil, errors.New("duplicate delivery log of " + deliveries.WebhookID + " in archive")
+		}
+		logs[deliveries.WebhookID] = true
+	}
+	return &archive, nil
+}
+
+// Import restores the archive into the store with the merge or replace mode
+func Import(st store.Store, archive *types.Archive, mode string) error {
+	switch mode {
+	case Merge, Replace:
+		return st.Restore(archive, mode == Replace)
+	default:
+		return errors.New("unknown import mode \"" + mode + "\", expected merge or replace")
+	}
+}
+
+// WriteFile writes the archive to a new snapshot file in `dir`, named after its creation time, and
+// returns its path. The file is written to a temporary file first, so that a snapshot is never partial.
+func WriteFile(dir string, archive *types.Archive) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, snapshotPrefix+archive.CreatedAt.UTC().Format(snapshotLayout)+snapshotSuffix)
+	tmp, err := os.CreateTemp(dir, snapshotPrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// Rotate deletes the oldest snapshots in `dir`, keeping the `keep` latest ones. It returns the number deleted.
+func Rotate(dir string, keep int) (int, error) {
+	snapshots, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"+snapshotSuffix))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(snapshots)
+	deleted := 0
+	for len(snapshots)-deleted > keep {
+		if err := os.Remove(snapshots[deleted]); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Snapshot exports the store to a new snapshot file in `dir`, and rotates the snapshots in it
+func Snapshot(st store.Store, dir string, keep int) (string, error) {
+	archive, err := Export(st)
+	if err != nil {
+		return "", err
+	}
+	path, err := WriteFile(dir, archive)
+	if err != nil {
+		return "", err
+	}
+	_, err = Rotate(dir, keep)
+	return path, err
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackup(t *testing.T) {
+	day := time.Date(2023, 4, 20, 0, 0, 0, 0, time.UTC)
+	source := store.NewMemory()
+	updates := types.NewBundledUpdate()
+	updates.Registrations["b"] = types.RegistrationAction{Add: true, Registration: types.InvocationRegistration{WebhookID: "b", URL: "http://example.com", Country: "NOR", Calls: 2}}
+	updates.Registrations["a"] = types.RegistrationAction{Add: true, Registration: types.InvocationRegistration{WebhookID: "a", URL: "https://example.com", Country: "SWE", Calls: 1}}
+	updates.Invocations = []types.InvocationBatch{{Instance: "x", Seq: 1, Counts: map[string]int64{"NOR": 4, "SWE": 1},
+		Buckets: []types.InvocationBucket{{Country: "NOR", Granularity: types.Daily, Start: day, Count: 4}}}}
+	updates.DeadLetters["d1"] = types.DeadLetterAction{Add: true, DeadLetter: types.DeadLetter{ID: "d1", WebhookID: "b", URL: "http://example.com", Country: "Norway", Calls: 2, Attempts: 5}}
+	updates.Deliveries["b"] = types.DeliveryLog{WebhookID: "b", Deliveries: []types.Delivery{{Time: day, Status: 500, Attempt: 5}}}
+	if err := source.BulkWrite(updates); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	// Test 1: an exported archive is read back with the same state, with registrations sorted by ID
+	archive, err := Export(source)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	data, _ := json.Marshal(archive)
+	read, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if read.Version != types.ArchiveVersion || read.Backend != store.Memory || len(read.Registrations) != 2 ||
+		read.Registrations[0].WebhookID != "a" || read.InvocationCounts["NOR"] != 4 || len(read.InvocationBuckets) != 1 ||
+		len(read.DeadLetters) != 1 || read.DeadLetters[0].Attempts != 5 || len(read.Deliveries) != 1 || read.Deliveries[0].WebhookID != "b" {
+		t.Fatalf("unexpected archive: %+v", read)
+	}
+
+	// Test 2: merging keeps the state that is not in the archive, replacing deletes it
+	target := store.NewMemory()
+	updates = types.NewBundledUpdate()
+	updates.Registrations["c"] = types.RegistrationAction{Add: true, Registration: types.InvocationRegistration{WebhookID: "c", URL: "http://example.com", Country: "FIN", Calls: 1}}
+	updates.Invocations = []types.InvocationBatch{{Instance: "y", Seq: 1, Counts: map[string]int64{"FIN": 2, "NOR": 9}}}
+	updates.DeadLetters["d2"] = types.DeadLetterAction{Add: true, DeadLetter: types.DeadLetter{ID: "d2", WebhookID: "c", URL: "http://example.com", Country: "Finland", Calls: 1, Attempts: 5}}
+	updates.Deliveries["c"] = types.DeliveryLog{WebhookID: "c", Deliveries: []types.Delivery{{Time: day, Status: 500, Attempt: 5}}}
+	if err := target.BulkWrite(updates); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if err := Import(target, read, Merge); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	registrations, _ := target.GetAllRegistrations()
+	counts, _ := target.GetAllInvocationCounts()
+	deadLetters, _ := target.GetAllDeadLetters()
+	logs, _ := target.GetAllDeliveries()
+	if len(registrations) != 3 || counts["NOR"] != 9 || counts["FIN"] != 2 || counts["SWE"] != 1 || len(deadLetters) != 2 || len(logs) != 2 {
+		t.Fatalf("unexpected state after merging: %+v %+v %+v %+v", registrations, counts, deadLetters, logs)
+	}
+	if err := Import(target, read, Replace); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	registrations, _ = target.GetAllRegistrations()
+	counts, _ = target.GetAllInvocationCounts()
+	deadLetters, _ = target.GetAllDeadLetters()
+	logs, _ = target.GetAllDeliveries()
+	if len(registrations) != 2 || len(counts) != 2 || counts["NOR"] != 4 || len(deadLetters) != 1 || deadLetters["d1"].WebhookID != "b" ||
+		len(logs) != 1 || logs["b"].WebhookID != "b" {
+		t.Fatalf("unexpected state after replacing: %+v %+v %+v %+v", registrations, counts, deadLetters, logs)
+	}
+	if err := Import(target, read, "overwrite"); err == nil {
+		t.Fatal("expected an unknown import mode to be rejected")
+	}
+
+	// Test 3: archives of an unknown version, with invalid registrations or with invalid dead letters or
+	// delivery logs are rejected
+	for _, invalid := range []string{
+		`{"version": 0}`,
+		`{"version": 3}`,
+		`{"version": 1, "registrations": [{"webhook_id": "a", "url": "ftp://example.com", "calls": 1}]}`,
+		`{"version": 1, "registrations": [{"webhook_id": "a", "url": "http://example.com", "calls": 0}]}`,
+		`{"version": 1, "invocation_counts": {"NOR": -1}}`,
+		`{"version": 1, "invocation_buckets": [{"country": "NOR", "granularity": "week"}]}`,
+		`{"version": 2, "dead_letters": [{"webhook_id": "a"}]}`,
+		`{"version": 2, "dead_letters": [{"id": "d1"}, {"id": "d1"}]}`,
+		`{"version": 2, "deliveries": [{"webhook_id": "a", "deliveries": []}]}`,
+		`not json`,
+	} {
+		if _, err := Read(strings.NewReader(invalid)); err == nil {
+			t.Fatal("expected archive to be rejected: ", invalid)
+		}
+	}
+	// archives written before secrets and threshold registrations were added are still imported
+	if _, err := Read(strings.NewReader(`{"version": 1, "registrations": [{"webhook_id": "a", "url": "http://example.com", "calls": 1}]}`)); err != nil {
+		t.Fatal("expected an archive of version 1 to be read, got: ", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	// Test 1: snapshots are named after their creation time, and only the latest ones are kept
+	for i := 0; i < 4; i++ {
+		archive := &types.Archive{Version: types.ArchiveVersion, CreatedAt: time.Date(2023, 4, 20+i, 0, 0, 0, 0, time.UTC)}
+		if _, err := WriteFile(dir, archive); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+	}
+	deleted, err := Rotate(dir, 2)
+	if err != nil || deleted != 2 {
+		t.Fatal("expected 2 snapshots to be deleted, got: ", deleted, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 || entries[0].Name() != "snapshot-20230422T000000Z.json" {
+		t.Fatalf("expected the 2 latest snapshots to be kept, got: %v", entries)
+	}
+
+	// Test 2: a snapshot of the store can be read back
+	path, err := Snapshot(store.NewMemory(), dir, 2)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer file.Close()
+	if _, err := Read(file); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*")); len(matches) != 2 {
+		t.Fatal("expected 2 snapshots after rotating, got: ", matches)
+	}
+}
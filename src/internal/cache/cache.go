@@ -0,0 +1,23 @@
+package cache
+
+import (
+	"assignment2/internal/types"
+	"time"
+)
+
+// Cache stores the responses of the renewables endpoints by key, so that repeated
+// requests do not have to be computed again
+type Cache interface {
+	// Get returns the cached entry for the key, and whether it was found and still valid
+	Get(key string) (types.CacheEntry, bool)
+	// Set stores the data for the key, valid for `ttl`. A ttl of zero uses the default of the cache.
+	Set(key string, data types.YearRecordList, ttl time.Duration)
+	// Delete removes the key from the cache
+	Delete(key string)
+	// Entries returns all entries that are still valid
+	Entries() []types.CacheEntry
+	// DeleteFunc removes all entries for which `match` returns true, and returns the number removed
+	DeleteFunc(match func(entry types.CacheEntry) bool) int
+	// Purge removes all expired entries, and returns the number removed
+	Purge() (int, error)
+}
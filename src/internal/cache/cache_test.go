@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(2, time.Hour)
+	nor := types.YearRecordList{{ISO: "NOR"}}
+	swe := types.YearRecordList{{ISO: "SWE"}}
+	fin := types.YearRecordList{{ISO: "FIN"}}
+
+	// Test 1: stored entries are returned
+	c.Set("nor", nor, 0)
+	if entry, ok := c.Get("nor"); !ok || entry.Records[0].ISO != "NOR" {
+		t.Fatal("expected entry to be returned")
+	}
+
+	// Test 2: the least recently used entry is evicted when the cache is full
+	c.Set("swe", swe, 0)
+	c.Get("nor")
+	c.Set("fin", fin, 0)
+	if _, ok := c.Get("swe"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	if _, ok := c.Get("nor"); !ok {
+		t.Fatal("expected recently used entry to be kept")
+	}
+	if c.Len() != 2 {
+		t.Fatal("expected 2 entries, got: ", c.Len())
+	}
+
+	// Test 3: deleted entries are gone
+	c.Delete("nor")
+	if _, ok := c.Get("nor"); ok {
+		t.Fatal("expected entry to be deleted")
+	}
+
+	// Test 4: expired entries are treated as missing
+	expiring := NewLRU(2, -time.Second)
+	expiring.Set("nor", nor, 0)
+	if _, ok := expiring.Get("nor"); ok {
+		t.Fatal("expected expired entry to be missing")
+	}
+
+	// Test 5: entries expire after their own TTL, but never later than the TTL of the cache
+	c.Set("short", nor, time.Millisecond)
+	c.Set("long", swe, 24*time.Hour)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("short"); ok {
+		t.Fatal("expected entry to expire after its own TTL")
+	}
+	if entry, ok := c.Get("long"); !ok || entry.ExpiresAt.After(time.Now().Add(time.Hour)) {
+		t.Fatal("expected entry TTL to be capped by the cache TTL")
+	}
+}
+
+func TestLRUAdministration(t *testing.T) {
+	c := NewLRU(10, time.Hour)
+	c.Set("/current/nor", types.YearRecordList{{ISO: "NOR"}, {ISO: "SWE"}}, 0)
+	c.Set("/current/fin", types.YearRecordList{{ISO: "FIN"}}, 0)
+	c.Set("/history/nor", types.YearRecordList{{ISO: "NOR"}}, 0)
+
+	// Test 1: entries are listed with their creation and expiry time
+	entries := c.Entries()
+	if len(entries) != 3 || entries[0].Source != "memory" || !entries[0].ExpiresAt.After(entries[0].CreatedAt) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	// Test 2: entries can be removed by country, including neighbours in the records
+	if deleted := c.DeleteFunc(func(entry types.CacheEntry) bool { return entry.HasCountry("swe") }); deleted != 1 {
+		t.Fatal("expected 1 entry to be deleted by country, got: ", deleted)
+	}
+
+	// Test 3: entries can be removed by prefix
+	deleted := c.DeleteFunc(func(entry types.CacheEntry) bool { return strings.HasPrefix(entry.Key, "/current/") })
+	if deleted != 1 || c.Len() != 1 {
+		t.Fatal("expected 1 entry to be deleted by prefix, got: ", deleted)
+	}
+}
+
+func TestLayered(t *testing.T) {
+	l1 := NewLRU(10, time.Hour)
+	l2 := NewLRU(10, time.Hour)
+	c := NewLayered(l1, l2)
+	nor := types.YearRecordList{{ISO: "NOR"}}
+
+	// Test 1: writes go to both layers
+	c.Set("nor", nor, 0)
+	if _, ok := l2.Get("nor"); !ok {
+		t.Fatal("expected entry to be written to L2")
+	}
+
+	// Test 2: hits in L2 are copied into L1
+	l1.Delete("nor")
+	if _, ok := c.Get("nor"); !ok {
+		t.Fatal("expected entry to be found in L2")
+	}
+	if _, ok := l1.Get("nor"); !ok {
+		t.Fatal("expected L2 hit to be copied into L1")
+	}
+
+	// Test 3: deletes remove the entry from both layers
+	c.Delete("nor")
+	if _, ok := c.Get("nor"); ok {
+		t.Fatal("expected entry to be deleted from both layers")
+	}
+}
+
+func TestPersistent(t *testing.T) {
+	s := store.NewMemory()
+	queue := testQueue{}
+	c := NewPersistent(time.Hour, s, queue)
+
+	// flush writes the queued entries to the store, as the update worker does
+	flush := func() {
+		bundle := types.NewBundledUpdate()
+		for key, entry := range queue {
+			bundle.Cache[key] = entry
+			delete(queue, key)
+		}
+		if err := s.BulkWrite(bundle); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+	}
+
+	// Test 1: entries are queued, and returned once written to the store
+	c.Set("nor", types.YearRecordList{{ISO: "NOR"}}, 0)
+	if _, ok := c.Get("nor"); ok {
+		t.Fatal("expected entry not to be returned before it is written")
+	}
+	flush()
+	if entry, ok := c.Get("nor"); !ok || entry.Source != store.Memory || entry.Records[0].ISO != "NOR" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	// Test 2: expired entries are treated as missing, and purged from the store
+	c.Set("swe", types.YearRecordList{{ISO: "SWE"}}, time.Millisecond)
+	flush()
+	time.Sleep(5 * time.Millisecond)
+	if len(c.Entries()) != 1 {
+		t.Fatal("expected only unexpired entries to be listed")
+	}
+	if purged, err := c.Purge(); err != nil || purged != 1 {
+		t.Fatal("expected 1 entry to be purged, got: ", purged, err)
+	}
+
+	// Test 3: entries can be removed by country
+	if deleted := c.DeleteFunc(func(entry types.CacheEntry) bool { return entry.HasCountry("NOR") }); deleted != 1 {
+		t.Fatal("expected 1 entry to be deleted, got: ", deleted)
+	}
+	if _, ok := c.Get("nor"); ok {
+		t.Fatal("expected entry to be deleted")
+	}
+
+	// Test 4: deleted entries that are still queued are not written to the store afterwards
+	c.Set("fin", types.YearRecordList{{ISO: "FIN"}}, 0)
+	c.Delete("fin")
+	c.Set("nor", types.YearRecordList{{ISO: "NOR"}}, 0)
+	if deleted := c.DeleteFunc(func(entry types.CacheEntry) bool { return entry.HasCountry("NOR") }); deleted != 1 {
+		t.Fatal("expected the queued entry to be deleted, got: ", deleted)
+	}
+	flush()
+	if len(c.Entries()) != 0 {
+		t.Fatal("expected no entries to be written after they were deleted, got: ", c.Entries())
+	}
+}
+
+// testQueue keeps the entries queued by a Persistent cache until the test writes them to the store
+type testQueue map[string]types.CacheEntry
+
+func (q testQueue) AddCache(key string, entry types.CacheEntry) {
+	q[key] = entry
+}
+
+func (q testQueue) DropCache(match func(entry types.CacheEntry) bool) int {
+	dropped := 0
+	for key, entry := range q {
+		if match(entry) {
+			delete(q, key)
+			dropped++
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"assignment2/internal/types"
+	"time"
+)
+
+// Layered combines a fast L1 cache (e.g. LRU) in front of a slower, shared L2 cache (e.g. Firestore).
+// Hits in L2 are copied into L1 until they expire in L2, and writes go to both layers.
+type Layered struct {
+	L1 Cache
+	L2 Cache
+}
+
+// NewLayered returns a cache that checks `l1` before `l2`
+func NewLayered(l1 Cache, l2 Cache) *Layered {
+	return &Layered{L1: l1, L2: l2}
+}
+
+// Get returns the entry from L1 if present, otherwise from L2
+func (c *Layered) Get(key string) (types.CacheEntry, bool) {
+	if entry, ok := c.L1.Get(key); ok {
+		return entry, true
+	}
+	entry, ok := c.L2.Get(key)
+	if ok {
+		c.L1.Set(key, entry.Records, time.Until(entry.ExpiresAt))
+	}
+	return entry, ok
+}
+
+// Set stores the data in both layers
+func (c *Layered) Set(key string, data types.YearRecordList, ttl time.Duration) {
+	c.L1.Set(key, data, ttl)
+	c.L2.Set(key, data, ttl)
+}
+
+// Delete removes the key from both layers
+func (c *Layered) Delete(key string) {
+	c.L1.Delete(key)
+	c.L2.Delete(key)
+}
+
+// Entries returns the entries of both layers, so a key cached in both is listed twice
+func (c *Layered) Entries() []types.CacheEntry {
+	return append(c.L1.Entries(), c.L2.Entries()...)
+}
+
+// DeleteFunc removes matching entries from both layers, and returns the total number removed
+func (c *Layered) DeleteFunc(match func(entry types.CacheEntry) bool) int {
+	return c.L1.DeleteFunc(match) + c.L2.DeleteFunc(match)
+}
+
+// Purge removes expired entries from both layers, and returns the total number removed. Both layers
+// are purged even if the first one fails.
+func (c *Layered) Purge() (int, error) {
+	purged1, err1 := c.L1.Purge()
+	purged2, err2 := c.L2.Purge()
+	if err1 != nil {
+		return purged1 + purged2, err1
+	}
+	return purged1 + purged2, err2
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"assignment2/internal/types"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is an in-memory Cache holding at most `size` entries. When full, the least recently
+// used entry is evicted. Entries are kept for at most the TTL of the cache, and are treated
+// as missing once expired.
+type LRU struct {
+	size  int
+	ttl   time.Duration
+	lock  sync.Mutex
+	items map[string]*list.Element
+	order *list.List // most recently used entry at the front
+}
+
+// NewLRU returns an empty LRU cache with room for `size` entries, each valid for at most `ttl`
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Get returns the entry for the key, and marks it as recently used
+func (c *LRU) Get(key string) (types.CacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return types.CacheEntry{}, false
+	}
+	entry := element.Value.(*types.CacheEntry)
+	if entry.Expired() {
+		c.remove(element)
+		return types.CacheEntry{}, false
+	}
+	c.order.MoveToFront(element)
+	return *entry, true
+}
+
+// Set stores the data for the key, evicting the least recently used entry if the cache is full.
+// The entry expires after `ttl`, but never later than the TTL of the cache.
+func (c *LRU) Set(key string, data types.YearRecordList, ttl time.Duration) {
+	if c.size <= 0 {
+		return
+	}
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+	entry := types.NewCacheEntry(key, data, ttl)
+	entry.Source = "memory"
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.items[key]; ok {
+		element.Value = &entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(&entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes the key from the cache
+func (c *LRU) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Entries returns the valid entries, most recently used first
+func (c *LRU) Entries() []types.CacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries := make([]types.CacheEntry, 0, c.order.Len())
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		if entry := element.Value.(*types.CacheEntry); !entry.Expired() {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// DeleteFunc removes all entries for which `match` returns true, and returns the number removed
+func (c *LRU) DeleteFunc(match func(entry types.CacheEntry) bool) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	deleted := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		if match(*element.Value.(*types.CacheEntry)) {
+			c.remove(element)
+			deleted++
+		}
+		element = next
+	}
+	return deleted
+}
+
+// Purge removes all expired entries, and returns the number removed
+func (c *LRU) Purge() (int, error) {
+	return c.DeleteFunc(types.CacheEntry.Expired), nil
+}
+
+// Len returns the number of entries in the cache, including expired entries not yet removed
+func (c *LRU) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// remove deletes an element from both the list and the map. The lock must be held.
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*types.CacheEntry).Key)
+}
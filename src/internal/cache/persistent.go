@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"errors"
+	"log"
+	"time"
+)
+
+// Queue receives the entries written to a Persistent cache, so that they can be stored in bulk
+type Queue interface {
+	AddCache(key string, entry types.CacheEntry)
+	// DropCache removes the queued entries for which `match` returns true, and returns the number removed
+	DropCache(match func(entry types.CacheEntry) bool) int
+}
+
+// Persistent is a Cache backed by the cached responses in a store, such as Firestore or SQLite. Reads go
+// directly to the store, while writes are added to `queue` and stored in bulk by the update worker.
+// Each entry stores an explicit expiry time, so overwriting an entry renews it.
+type Persistent struct {
+	ttl   time.Duration
+	store store.Store
+	queue Queue
+}
+
+// NewPersistent returns a cache in `s` where entries are valid for `ttl` unless another TTL is given
+func NewPersistent(ttl time.Duration, s store.Store, queue Queue) *Persistent {
+	return &Persistent{ttl: ttl, store: s, queue: queue}
+}
+
+// Get returns the cached entry for the key. Expired entries are deleted.
+func (c *Persistent) Get(key string) (types.CacheEntry, bool) {
+	entry, err := c.store.GetCacheEntry(key)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Println("Could not read cache entry: " + err.Error())
+		}
+		return types.CacheEntry{}, false
+	}
+	if entry.Expired() {
+		c.Delete(key)
+		return types.CacheEntry{}, false
+	}
+	entry.Source = c.store.Name()
+	return entry, true
+}
+
+// Set queues the data to be written to the store by the update worker
+func (c *Persistent) Set(key string, data types.YearRecordList, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	c.queue.AddCache(key, types.NewCacheEntry(key, data, ttl))
+}
+
+// Delete removes the cached entry for the key. An entry still queued is dropped first, so that it is not
+// written to the store after it has been deleted.
+func (c *Persistent) Delete(key string) {
+	c.queue.DropCache(func(entry types.CacheEntry) bool { return entry.Key == key })
+	c.deleteStored(key)
+}
+
+// deleteStored removes the entry for the key from the store
+func (c *Persistent) deleteStored(key string) {
+	if err := c.store.DeleteCacheEntry(key); err != nil {
+		log.Println("Could not delete cache entry: " + err.Error())
+	}
+}
+
+// Entries returns all entries in the store that have not expired
+func (c *Persistent) Entries() []types.CacheEntry {
+	all, err := c.store.GetAllCacheEntries()
+	if err != nil {
+		log.Println("Could not read cache entries: " + err.Error())
+		return nil
+	}
+	entries := make([]types.CacheEntry, 0, len(all))
+	for _, entry := range all {
+		if !entry.Expired() {
+			entry.Source = c.store.Name()
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// DeleteFunc removes all entries for which `match` returns true, including those still queued, and returns
+// the number removed
+func (c *Persistent) DeleteFunc(match func(entry types.CacheEntry) bool) int {
+	deleted := map[string]bool{}
+	c.queue.DropCache(func(entry types.CacheEntry) bool {
+		entry.Source = c.store.Name()
+		if match(entry) {
+			deleted[entry.Key] = true
+			return true
+		}
+		return false
+	})
+	all, err := c.store.GetAllCacheEntries()
+	if err != nil {
+		log.Println("Could not read cache entries: " + err.Error())
+		return len(deleted)
+	}
+	for _, entry := range all {
+		entry.Source = c.store.Name()
+		if match(entry) {
+			c.deleteStored(entry.Key)
+			deleted[entry.Key] = true
+		}
+	}
+	return len(deleted)
+}
+
+// Purge deletes all expired entries in the store, and returns the number removed
+func (c *Persistent) Purge() (int, error) {
+	return c.store.PurgeExpiredCache()
+}
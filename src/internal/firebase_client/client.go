@@ -6,6 +6,8 @@ import (
 	"context"                       // State handling across API boundaries; part of native GoLang API
 	firebase "firebase.google.com/go"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"strings"
 	"time"
@@ -22,21 +24,22 @@ type FirebaseClient struct {
 }
 
 // NewFirebaseClient initializes and returns a new FirebaseClient by connecting to Firebase using the secret key.
+// The client is meant to be long-lived, and is safe for concurrent use.
 func NewFirebaseClient() (*FirebaseClient, error) {
 	/* Firebase initialisation  -> means setting up the connection to Firebase */
 	ctx := context.Background()                                  // Create a basic empty box for tasks
 	secretKeyPath := option.WithCredentialsFile(PathToSecretKey) // Tell the program where to find the secret key for Firebase
 	app, err := firebase.NewApp(ctx, nil, secretKeyPath)         // Connect to Firebase using the secret key
 
-	if err != nil { // If there's an error, stop the program and show the error
-		log.Fatalf("Error initializing Firebase app: %v", err)
+	if err != nil { // If there's an error, let the caller decide whether to stop the program
+		log.Printf("Error initializing Firebase app: %v", err)
 		return nil, err
 	}
 
 	/* Instantiate client */
 	client, err := app.Firestore(ctx) // Get the helper for talking to Firestore
 	if err != nil {
-		log.Fatalf("Failed to create Firestore client: %v", err)
+		log.Printf("Failed to create Firestore client: %v", err)
 		return nil, err
 	}
 
@@ -46,15 +49,12 @@ func NewFirebaseClient() (*FirebaseClient, error) {
 	}, nil
 }
 
-// SetInvocationCount updates the invocation count and timestamp for a specific document identified by its ccna3 code.
+// SetInvocationCount updates the invocation count for a specific document identified by its ccna3 code.
 func (client *FirebaseClient) SetInvocationCount(ccna3 string, number int) {
 	// e.g. SetInvocationCount("NOR", 5)
 	// finds the collection(CollectionInvocationCounts) -> create a reference to a document with document ID(ccna3)
 	docRef := client.client.Collection(CollectionInvocationCounts).Doc(ccna3)
-	_, err := docRef.Set(client.ctx, map[string]interface{}{
-		"count": number,
-		"time":  firestore.ServerTimestamp,
-	})
+	_, err := docRef.Set(client.ctx, countData(number))
 	if err != nil {
 		log.Printf("Failed to set invocation count: %v", err)
 	}
@@ -84,155 +84,563 @@ func (client *FirebaseClient) GetInvocationCount(ccna3 string) (int64, error) {
 	return count.(int64), nil
 }
 
-func (client *FirebaseClient) GetAllInvocationCounts() map[string]int64 {
+// GetAllInvocationCounts retrieves the invocation count of every country
+func (client *FirebaseClient) GetAllInvocationCounts() (map[string]int64, error) {
 	data := map[string]int64{}
 	docs, err := client.GetAllDocuments(CollectionInvocationCounts)
 	if err != nil {
 		log.Printf("Could not fetch invocation counts from firestore")
-		return data
+		return data, err
 	}
 	for _, docField := range docs {
 		if count, err := docField.DataAt("count"); err == nil {
 			data[docField.Ref.ID] = count.(int64)
 		}
 	}
-	return data
+	return data, nil
 }
 
 func (client *FirebaseClient) GetAllDocuments(collection string) ([]*firestore.DocumentSnapshot, error) {
 	docRef := client.client.Collection(collection).Documents(client.ctx)
 	docs, err := docRef.GetAll()
 	if err != nil {
-		log.Printf("Failed to get documents: #{err}")
+		log.Printf("Failed to get documents: %v", err)
 		return nil, err
 	}
 	return docs, nil
 }
 
-// SetRenewablesCache stores a YearRecordList in the renewables cache collection using the given URL as the document identifier.
-func (client *FirebaseClient) SetRenewablesCache(url string, list types.YearRecordList) {
-	url = strings.ReplaceAll(url, "/", "_")
-	// e.g. SetRenewablesCache("/current/nor?neighbours=true", *data)
-	// Access the renewables cache collection, with the specified url, if not exist, it will be created
-	docRef := client.client.Collection(CollectionRenewablesCache).Doc(url)
+// GetInvocationCounts retrieves the invocation counts of the countries. Countries without a count are left out.
+func (client *FirebaseClient) GetInvocationCounts(countries []string) (map[string]int64, error) {
+	refs := make([]*firestore.DocumentRef, len(countries))
+	for i, country := range countries {
+		refs[i] = client.client.Collection(CollectionInvocationCounts).Doc(country)
+	}
+	docs, err := client.client.GetAll(client.ctx, refs)
+	if err != nil {
+		log.Printf("Failed to get invocation counts: %v", err)
+		return nil, err
+	}
+	data := map[string]int64{}
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		if count, err := doc.DataAt("count"); err == nil {
+			data[doc.Ref.ID] = count.(int64)
+		}
+	}
+	return data, nil
+}
+
+// AddInvocations increments the invocation counts and buckets by the counts of the batch in a transaction,
+// unless the instance has already added a batch with the same or a later sequence number. The sequence
+// number of the last batch of every instance is kept in the invocation batches collection.
+func (client *FirebaseClient) AddInvocations(batch types.InvocationBatch) error {
+	batchRef := client.client.Collection(CollectionInvocationBatches).Doc(batch.Instance)
+	return client.client.RunTransaction(client.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(batchRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if seq, err := doc.DataAt("seq"); err == nil && seq.(int64) >= batch.Seq {
+				return nil
+			}
+		}
+
+		for countryCode, count := range batch.Counts {
+			docRef := client.client.Collection(CollectionInvocationCounts).Doc(countryCode)
+			if err := tx.Set(docRef, countData(firestore.Increment(count)), firestore.MergeAll); err != nil {
+				return err
+			}
+		}
+		for _, bucket := range batch.Buckets {
+			docRef := client.client.Collection(CollectionInvocationBuckets).Doc(bucket.ID())
+			if err := tx.Set(docRef, bucketData(bucket, firestore.Increment(bucket.Count)), firestore.MergeAll); err != nil {
+				return err
+			}
+		}
+		return tx.Set(batchRef, map[string]interface{}{"seq": batch.Seq, "time": firestore.ServerTimestamp, types.SchemaField: types.SchemaVersion})
+	})
+}
 
-	// Set or update the document with the provided data as a RenewableDB containing the YearRecordList
-	_, err := docRef.Set(client.ctx, types.RenewableDB{
-		"yearRecords": list,
+// AcquireLease acquires or renews a lease for `owner` in a transaction, unless another owner holds it. The lease
+// is created with `checkpoint` if it does not exist. The lease is returned, and whether it was acquired.
+func (client *FirebaseClient) AcquireLease(name string, owner string, ttl time.Duration, checkpoint int64) (types.Lease, bool, error) {
+	docRef := client.client.Collection(CollectionLeases).Doc(name)
+	var lease types.Lease
+	acquired := false
+	err := client.client.RunTransaction(client.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		lease, acquired = types.Lease{Name: name, Checkpoint: checkpoint}, false
+		doc, err := tx.Get(docRef)
+		if err == nil {
+			if err := doc.DataTo(&lease); err != nil {
+				return err
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+		now := time.Now()
+		if lease.Held(owner, now) {
+			return nil
+		}
+		lease.Owner, lease.Expires = owner, now.Add(ttl)
+		acquired = true
+		return tx.Set(docRef, leaseData(lease))
 	})
+	if err != nil {
+		log.Printf("Failed to acquire lease: %v", err)
+	}
+	return lease, acquired, err
+}
+
+// UpdateLease stores the lease in a transaction if it is still owned by its owner, and returns whether it was
+func (client *FirebaseClient) UpdateLease(lease types.Lease) (bool, error) {
+	docRef := client.client.Collection(CollectionLeases).Doc(lease.Name)
+	owned := false
+	err := client.client.RunTransaction(client.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			owned = false
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var stored types.Lease
+		if err := doc.DataTo(&stored); err != nil {
+			return err
+		}
+		owned = stored.Owner == lease.Owner
+		if !owned {
+			return nil
+		}
+		return tx.Set(docRef, leaseData(lease))
+	})
+	return owned, err
+}
+
+// GetInvocationBuckets retrieves the hourly and daily invocation buckets starting from `from` until `to`
+func (client *FirebaseClient) GetInvocationBuckets(from time.Time, to time.Time) ([]types.InvocationBucket, error) {
+	docs, err := client.client.Collection(CollectionInvocationBuckets).
+		Where("start", ">=", from).Where("start", "<", to).Documents(client.ctx).GetAll()
+	if err != nil {
+		log.Printf("Failed to get invocation buckets: %v", err)
+		return nil, err
+	}
+	buckets := make([]types.InvocationBucket, 0, len(docs))
+	for _, doc := range docs {
+		var bucket types.InvocationBucket
+		if err := doc.DataTo(&bucket); err != nil {
+			log.Printf("Failed to convert document data to invocation bucket: %v", err)
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// SetRenewablesCache stores a cache entry in the renewables cache collection using its key (URL) as the document identifier.
+func (client *FirebaseClient) SetRenewablesCache(entry types.CacheEntry) {
+	// e.g. SetRenewablesCache(types.NewCacheEntry("/current/nor?neighbours=true", *data, ttl))
+	// Access the renewables cache collection, with the specified url, if not exist, it will be created
+	docRef := client.client.Collection(CollectionRenewablesCache).Doc(cacheDocID(entry.Key))
+
+	// Set or update the document with the records, the original key and the expiry time
+	_, err := docRef.Set(client.ctx, cacheData(entry))
 	// Log errors if they occur during the Set operation
 	if err != nil {
-		log.Printf("Failed to set invocation registration: %v", err)
+		log.Printf("Failed to set renewables cache entry: %v", err)
 	}
 }
 
-// GetRenewablesCache retrieves a cached YearRecordList and its creation time by URL.
-func (client *FirebaseClient) GetRenewablesCache(url string) (types.YearRecordList, time.Time, error) {
-	url = strings.ReplaceAll(url, "/", "_")
+// GetRenewablesCache retrieves a cache entry by URL.
+func (client *FirebaseClient) GetRenewablesCache(url string) (types.CacheEntry, error) {
 	// Access the renewables cache collection and get a reference to the document with the specified URL
-	docRef := client.client.Collection(CollectionRenewablesCache).Doc(url)
+	docRef := client.client.Collection(CollectionRenewablesCache).Doc(cacheDocID(url))
 	// Retrieve the document
 	doc, err := docRef.Get(client.ctx)
 	if err != nil {
 		log.Printf("Failed to get renewables cache entry: %v", err)
-		return types.YearRecordList{}, time.Time{}, err
+		return types.CacheEntry{}, err
 	}
-	// Extract the YearRecordList from the document
-	var db types.RenewableDB
-	err = doc.DataTo(&db)
+	return toCacheEntry(doc)
+}
+
+// GetAllRenewablesCache retrieves all entries in the renewables cache collection, including expired entries.
+func (client *FirebaseClient) GetAllRenewablesCache() ([]types.CacheEntry, error) {
+	docs, err := client.GetAllDocuments(CollectionRenewablesCache)
 	if err != nil {
-		log.Printf("Failed to convert document data to RenewableDB: %v", err)
-		return types.YearRecordList{}, time.Time{}, err
+		return nil, err
+	}
+	entries := make([]types.CacheEntry, 0, len(docs))
+	for _, doc := range docs {
+		if entry, err := toCacheEntry(doc); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// PurgeExpiredRenewablesCache pages through the renewables cache collection and deletes expired entries
+// with the bulk writer, including entries that cannot be read. It returns the number of entries deleted
+// once every delete has completed, together with the first error of a read or a delete.
+func (client *FirebaseClient) PurgeExpiredRenewablesCache(pageSize int) (int, error) {
+	collection := client.client.Collection(CollectionRenewablesCache)
+	bulkWriter := client.client.BulkWriter(client.ctx)
+	var jobs []*firestore.BulkWriterJob
+	var firstErr error
+
+	var last *firestore.DocumentSnapshot
+	for {
+		query := collection.OrderBy(firestore.DocumentID, firestore.Asc).Limit(pageSize)
+		if last != nil {
+			query = query.StartAfter(last)
+		}
+		docs, err := query.Documents(client.ctx).GetAll()
+		if err != nil {
+			log.Printf("Failed to get renewables cache page: %v", err)
+			firstErr = err
+			break
+		}
+		for _, doc := range docs {
+			if entry, err := toCacheEntry(doc); err == nil && !entry.Expired() {
+				continue
+			}
+			job, err := bulkWriter.Delete(doc.Ref)
+			if err != nil {
+				log.Println("could not add job to bulk-writer ", err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+		// the last page is shorter than the page size
+		if len(docs) < pageSize {
+			break
+		}
+		last = docs[len(docs)-1]
+	}
+
+	bulkWriter.End()
+	purged := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			log.Printf("Failed to delete cache entry: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		purged++
+	}
+	return purged, firstErr
+}
+
+// toCacheEntry converts a document in the renewables cache collection to a cache entry. Documents
+// written before the key was stored are identified by their document ID instead.
+func toCacheEntry(doc *firestore.DocumentSnapshot) (types.CacheEntry, error) {
+	var entry types.CacheEntry
+	if err := doc.DataTo(&entry); err != nil {
+		log.Printf("Failed to convert document data to cache entry: %v", err)
+		return types.CacheEntry{}, err
+	}
+	if len(entry.Key) == 0 {
+		entry.Key = doc.Ref.ID
 	}
-	// Get the YearRecordList from the RenewableDB and creation time
-	yearRecords := db["yearRecords"]
-	creationTime := doc.CreateTime
+	return entry, nil
+}
 
-	return yearRecords, creationTime, nil
+// cacheDocID returns the document identifier for a URL, as document identifiers cannot contain slashes
+func cacheDocID(url string) string {
+	return strings.ReplaceAll(url, "/", "_")
 }
 
 // DeleteRenewablesCache removes the specified document from the renewables cache collection using the provided URL.
-func (client *FirebaseClient) DeleteRenewablesCache(url string) {
-	url = strings.ReplaceAll(url, "/", "_")
+func (client *FirebaseClient) DeleteRenewablesCache(url string) error {
 	// Access the renewables cache collection and get a reference to the document with the specified URL
-	docRef := client.client.Collection(CollectionRenewablesCache).Doc(url)
+	docRef := client.client.Collection(CollectionRenewablesCache).Doc(cacheDocID(url))
 	// Delete the document
 	_, err := docRef.Delete(client.ctx)
 	// Log errors if they occur during the Delete operation
 	if err != nil {
 		log.Printf("Failed to delete renewables cache entry: %v", err)
 	}
+	return err
 }
 
 // SetInvocationRegistration stores an InvocationRegistration in Firestore
 func (client *FirebaseClient) SetInvocationRegistration(registration types.InvocationRegistration) {
 	// Access the invocation_registrations collection, with the specified WebhookID, if not exist, it will be created
 	docRef := client.client.Collection(CollectionInvocationRegistrations).Doc(registration.WebhookID)
-	_, err := docRef.Set(client.ctx, registration) // Set or update the document with the provided data
-	if err != nil {                                // Log errors if they occur during the Set operation
+	_, err := docRef.Set(client.ctx, registrationData(registration)) // Set or update the document with the provided data
+	if err != nil {                                                  // Log errors if they occur during the Set operation
 		log.Printf("Failed to set invocation registration: %v", err)
 	}
 }
 
 // GetAllInvocationRegistrations retrieves all InvocationRegistration documents from Firestore
-func (client *FirebaseClient) GetAllInvocationRegistrations() map[string]types.InvocationRegistration {
+func (client *FirebaseClient) GetAllInvocationRegistrations() (map[string]types.InvocationRegistration, error) {
 	result := map[string]types.InvocationRegistration{}
 	docs, err := client.GetAllDocuments(CollectionInvocationRegistrations)
 	if err != nil {
 		log.Printf("Could not fetch data from firestore")
-		return result
+		return result, err
 	}
 	for _, doc := range docs {
 		var registration types.InvocationRegistration
 		err = doc.DataTo(&registration)
 		if err != nil {
-			return result
+			return result, err
 		}
+		upgradeLegacyRegistration(&registration, doc.Ref.ID, doc.Data())
 		result[doc.Ref.ID] = registration
 
 	}
-	return result
+	return result, nil
 }
 
-func (client *FirebaseClient) BulkWrite(updates *types.BundledUpdate) {
-	bulkWriter := client.client.BulkWriter(client.ctx)
+// GetAllDeadLetters retrieves all DeadLetter documents from Firestore
+func (client *FirebaseClient) GetAllDeadLetters() (map[string]types.DeadLetter, error) {
+	result := map[string]types.DeadLetter{}
+	docs, err := client.GetAllDocuments(CollectionDeadLetters)
+	if err != nil {
+		return result, err
+	}
+	for _, doc := range docs {
+		var deadLetter types.DeadLetter
+		if err := doc.DataTo(&deadLetter); err != nil {
+			return result, err
+		}
+		result[doc.Ref.ID] = deadLetter
+	}
+	return result, nil
+}
 
-	// updating invocation counts
-	for countryCode, count := range updates.InvocationCount {
-		docRef := client.client.Collection(CollectionInvocationCounts).Doc(countryCode)
-		_, err := bulkWriter.Set(docRef, map[string]interface{}{"count": count})
+// GetAllDeliveries retrieves all DeliveryLog documents from Firestore
+func (client *FirebaseClient) GetAllDeliveries() (map[string]types.DeliveryLog, error) {
+	result := map[string]types.DeliveryLog{}
+	docs, err := client.GetAllDocuments(CollectionDeliveries)
+	if err != nil {
+		return result, err
+	}
+	for _, doc := range docs {
+		var deliveries types.DeliveryLog
+		if err := doc.DataTo(&deliveries); err != nil {
+			return result, err
+		}
+		result[doc.Ref.ID] = deliveries
+	}
+	return result, nil
+}
+
+// BulkWrite applies the registrations, together with their webhook leases and delivery logs, the dead
+// letters, the delivery logs and the cached responses with the bulk writer. The first error of a write
+// is returned once every write has completed, so that the updates are kept and written again. Creating
+// a webhook lease that exists already is not an error, as it has been acquired since.
+func (client *FirebaseClient) BulkWrite(updates *types.BundledUpdate) error {
+	bulkWriter := client.client.BulkWriter(client.ctx)
+	var jobs, leaseJobs []*firestore.BulkWriterJob
+	var enqueueErr error
+	add := func(jobs *[]*firestore.BulkWriterJob, job *firestore.BulkWriterJob, err error) {
 		if err != nil {
 			log.Println("could not add job to bulk-writer ", err.Error())
+			if enqueueErr == nil {
+				enqueueErr = err
+			}
+			return
 		}
+		*jobs = append(*jobs, job)
 	}
+	enqueue := func(job *firestore.BulkWriterJob, err error) { add(&jobs, job, err) }
+	enqueueLease := func(job *firestore.BulkWriterJob, err error) { add(&leaseJobs, job, err) }
 
-	// updating registrations
+	// updating registrations, together with their webhook leases and delivery logs
 	for _, reg := range updates.Registrations {
 		docRef := client.client.Collection(CollectionInvocationRegistrations).Doc(reg.Registration.WebhookID)
+		leaseName := types.WebhookLease(reg.Registration.WebhookID)
+		leaseRef := client.client.Collection(CollectionLeases).Doc(leaseName)
 		if reg.Add {
-			_, err := bulkWriter.Set(docRef, reg.Registration)
-			if err != nil {
-				log.Println("could not add job to bulk-writer ", err.Error())
-			}
+			enqueue(bulkWriter.Set(docRef, registrationData(reg.Registration)))
+			// fails if the lease exists, as it has been acquired since and has the latest checkpoint
+			enqueueLease(bulkWriter.Create(leaseRef, leaseData(types.Lease{Name: leaseName, Checkpoint: reg.Checkpoint})))
 		} else {
-			_, err := bulkWriter.Delete(docRef)
-			if err != nil {
-				log.Println("could not add job to bulk-writer ", err.Error())
-			}
+			enqueue(bulkWriter.Delete(docRef))
+			enqueue(bulkWriter.Delete(leaseRef))
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionDeliveries).Doc(reg.Registration.WebhookID)))
+		}
+	}
+
+	// updating dead letters
+	for id, action := range updates.DeadLetters {
+		docRef := client.client.Collection(CollectionDeadLetters).Doc(id)
+		if action.Add {
+			enqueue(bulkWriter.Set(docRef, deadLetterData(action.DeadLetter)))
+		} else {
+			enqueue(bulkWriter.Delete(docRef))
 		}
 	}
 
+	// updating delivery logs, unless their registration is deleted
+	for id, deliveries := range updates.Deliveries {
+		if reg, ok := updates.Registrations[id]; ok && !reg.Add {
+			continue
+		}
+		docRef := client.client.Collection(CollectionDeliveries).Doc(id)
+		enqueue(bulkWriter.Set(docRef, deliveriesData(deliveries)))
+	}
+
 	// updating cache
-	for url, cache := range updates.Cache {
-		url = strings.ReplaceAll(url, "/", "_")
-		docRef := client.client.Collection(CollectionRenewablesCache).Doc(url)
-		_, err := bulkWriter.Set(docRef, map[string]interface{}{"yearRecords": cache})
+	for url, entry := range updates.Cache {
+		docRef := client.client.Collection(CollectionRenewablesCache).Doc(cacheDocID(url))
+		enqueue(bulkWriter.Set(docRef, cacheData(entry)))
+	}
+
+	bulkWriter.End()
+	if enqueueErr != nil {
+		return enqueueErr
+	}
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			log.Printf("Failed to write document: %v", err)
+			return err
+		}
+	}
+	for _, job := range leaseJobs {
+		if _, err := job.Results(); err != nil && status.Code(err) != codes.AlreadyExists {
+			log.Printf("Failed to create webhook lease: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore writes the registrations, invocation counts and buckets of the archive with the bulk writer. With
+// `replace`, the documents that are not in the archive are deleted and the webhook leases are reset to the
+// archived count of their country. Otherwise, the higher of the stored and archived count is kept and only
+// missing webhook leases are created. The writes are not atomic, so invocations should not be counted meanwhile.
+func (client *FirebaseClient) Restore(archive *types.Archive, replace bool) error {
+	counts, err := client.GetAllInvocationCounts()
+	if err != nil {
+		return err
+	}
+	registrations, err := client.GetAllInvocationRegistrations()
+	if err != nil {
+		return err
+	}
+	bucketDocs, err := client.GetAllDocuments(CollectionInvocationBuckets)
+	if err != nil {
+		return err
+	}
+	buckets := make(map[string]int64, len(bucketDocs))
+	for _, doc := range bucketDocs {
+		count, _ := doc.DataAt("count")
+		n, _ := count.(int64)
+		buckets[doc.Ref.ID] = n
+	}
+	leaseDocs, err := client.GetAllDocuments(CollectionLeases)
+	if err != nil {
+		return err
+	}
+	leases := make(map[string]bool, len(leaseDocs))
+	for _, doc := range leaseDocs {
+		leases[doc.Ref.ID] = true
+	}
+	deadLetters, err := client.GetAllDeadLetters()
+	if err != nil {
+		return err
+	}
+	logs, err := client.GetAllDeliveries()
+	if err != nil {
+		return err
+	}
+
+	bulkWriter := client.client.BulkWriter(client.ctx)
+	var jobs []*firestore.BulkWriterJob
+	enqueue := func(job *firestore.BulkWriterJob, err error) {
 		if err != nil {
 			log.Println("could not add job to bulk-writer ", err.Error())
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	final := make(map[string]int64, len(archive.InvocationCounts))
+	for country, n := range archive.InvocationCounts {
+		stored, found := counts[country]
+		delete(counts, country)
+		if !replace && found && stored > n {
+			final[country] = stored
+			continue
+		}
+		final[country] = n
+		enqueue(bulkWriter.Set(client.client.Collection(CollectionInvocationCounts).Doc(country), countData(n)))
+	}
+	for _, bucket := range archive.InvocationBuckets {
+		stored, found := buckets[bucket.ID()]
+		delete(buckets, bucket.ID())
+		if !replace && found && stored > bucket.Count {
+			continue
+		}
+		enqueue(bulkWriter.Set(client.client.Collection(CollectionInvocationBuckets).Doc(bucket.ID()), bucketData(bucket, bucket.Count)))
+	}
+	for _, registration := range archive.Registrations {
+		delete(registrations, registration.WebhookID)
+		enqueue(bulkWriter.Set(client.client.Collection(CollectionInvocationRegistrations).Doc(registration.WebhookID), registrationData(registration)))
+		leaseName := types.WebhookLease(registration.WebhookID)
+		if !replace && leases[leaseName] {
+			continue
+		}
+		lease := types.Lease{Name: leaseName, Checkpoint: final[registration.Country]}
+		enqueue(bulkWriter.Set(client.client.Collection(CollectionLeases).Doc(leaseName), leaseData(lease)))
+	}
+	for _, deadLetter := range archive.DeadLetters {
+		delete(deadLetters, deadLetter.ID)
+		enqueue(bulkWriter.Set(client.client.Collection(CollectionDeadLetters).Doc(deadLetter.ID), deadLetterData(deadLetter)))
+	}
+	for _, deliveries := range archive.Deliveries {
+		delete(logs, deliveries.WebhookID)
+		enqueue(bulkWriter.Set(client.client.Collection(CollectionDeliveries).Doc(deliveries.WebhookID), deliveriesData(deliveries)))
+	}
+
+	// only left with the documents that are not in the archive when replacing
+	if replace {
+		for id := range registrations {
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionInvocationRegistrations).Doc(id)))
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionLeases).Doc(types.WebhookLease(id))))
+		}
+		for country := range counts {
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionInvocationCounts).Doc(country)))
+		}
+		for id := range buckets {
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionInvocationBuckets).Doc(id)))
+		}
+		for id := range deadLetters {
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionDeadLetters).Doc(id)))
+		}
+		for id := range logs {
+			enqueue(bulkWriter.Delete(client.client.Collection(CollectionDeliveries).Doc(id)))
 		}
 	}
 
 	bulkWriter.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			log.Printf("Failed to restore document: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping reads at most one document, and returns an error if Firestore cannot be reached within `timeout`
+func (client *FirebaseClient) Ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(client.ctx, timeout)
+	defer cancel()
+	_, err := client.client.Collection(CollectionInvocationCounts).Limit(1).Documents(ctx).GetAll()
+	return err
 }
 
 func (client *FirebaseClient) Close() {
@@ -1,8 +1,18 @@
 package firebase_client
 
+import "time"
+
 const (
 	PathToSecretKey                   = "./secret_key.json"        // path to secret key
 	CollectionInvocationCounts        = "Invocation counts"        // Invocation counts collection
 	CollectionInvocationRegistrations = "Invocation registrations" // Invocation_registrations collection
 	CollectionRenewablesCache         = "Renewables cache"         // Renewables Cache collection
+	CollectionInvocationBuckets       = "Invocation buckets"       // Hourly and daily invocation counts collection
+	CollectionInvocationBatches       = "Invocation batches"       // Last invocation batch added by every instance
+	CollectionLeases                  = "Leases"                   // Leases of tasks that only one instance performs
+	CollectionDeadLetters             = "Dead letters"             // Webhooks that could not be delivered
+	CollectionDeliveries              = "Deliveries"               // Most recent delivery attempts of every registration
+	PurgePageSize                     = 500                        // documents read per page when purging expired cache entries
+	PingTimeout                       = 5 * time.Second            // deadline for the health check read
+	ReconnectBackoff                  = 10 * time.Second           // minimum time between attempts to reconnect the client
 )
@@ -0,0 +1,151 @@
+package firebase_client
+
+import (
+	"assignment2/internal/types"
+	"cloud.google.com/go/firestore"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The documents are written from maps rather than from the structs, so that every document carries the
+// schema version next to the fields of the struct. The field names match the firestore tags of the structs.
+
+// LegacyRegistrationFields are the fields of the registrations written before documents carried a schema
+// version, which were named after the Go struct, by their current names
+var LegacyRegistrationFields = map[string]string{"WebhookID": "webhook_id", "URL": "url", "Country": "country", "Calls": "calls"}
+
+// upgradeLegacyRegistration fills in the fields of a registration decoded from a document without a schema
+// version from their legacy names, and the webhook ID from the document ID, so that the registrations
+// keep working until the store is migrated
+func upgradeLegacyRegistration(registration *types.InvocationRegistration, id string, data map[string]interface{}) {
+	if _, versioned := data[types.SchemaField]; versioned {
+		return
+	}
+	if registration.WebhookID == "" {
+		registration.WebhookID, _ = data["WebhookID"].(string)
+	}
+	if registration.WebhookID == "" {
+		registration.WebhookID = id
+	}
+	if registration.URL == "" {
+		registration.URL, _ = data["URL"].(string)
+	}
+	if registration.Country == "" {
+		registration.Country, _ = data["Country"].(string)
+	}
+	if registration.Calls == 0 {
+		registration.Calls, _ = data["Calls"].(int64)
+	}
+}
+
+// registrationData returns the document of a webhook registration
+func registrationData(registration types.InvocationRegistration) map[string]interface{} {
+	return map[string]interface{}{
+		"webhook_id":              registration.WebhookID,
+		"type":                    registration.Type,
+		"url":                     registration.URL,
+		"country":                 registration.Country,
+		"calls":                   registration.Calls,
+		"threshold":               registration.Threshold,
+		"direction":               registration.Direction,
+		"last_value":              registration.LastValue,
+		"last_year":               registration.LastYear,
+		"secret":                  registration.Secret,
+		"previous_secret":         registration.PreviousSecret,
+		"previous_secret_expires": registration.PreviousSecretExpires,
+		types.SchemaField:         types.SchemaVersion,
+	}
+}
+
+// deadLetterData returns the document of a dead letter. The threshold crossing is only written for the
+// dead letters of threshold registrations.
+func deadLetterData(deadLetter types.DeadLetter) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":              deadLetter.ID,
+		"webhook_id":      deadLetter.WebhookID,
+		"url":             deadLetter.URL,
+		"country":         deadLetter.Country,
+		"calls":           deadLetter.Calls,
+		"type":            deadLetter.Type,
+		"attempts":        deadLetter.Attempts,
+		"error":           deadLetter.Error,
+		"failed_at":       deadLetter.FailedAt,
+		types.SchemaField: types.SchemaVersion,
+	}
+	if deadLetter.Crossing != nil {
+		data["threshold"] = deadLetter.Crossing
+	}
+	return data
+}
+
+// deliveriesData returns the document of the delivery log of a registration
+func deliveriesData(deliveries types.DeliveryLog) map[string]interface{} {
+	return map[string]interface{}{
+		"webhook_id":      deliveries.WebhookID,
+		"deliveries":      deliveries.Deliveries,
+		types.SchemaField: types.SchemaVersion,
+	}
+}
+
+// leaseData returns the document of a lease
+func leaseData(lease types.Lease) map[string]interface{} {
+	return map[string]interface{}{
+		"name":            lease.Name,
+		"owner":           lease.Owner,
+		"expires":         lease.Expires,
+		"checkpoint":      lease.Checkpoint,
+		types.SchemaField: types.SchemaVersion,
+	}
+}
+
+// bucketData returns the document of an invocation bucket with `count`, which may be a firestore.Increment
+func bucketData(bucket types.InvocationBucket, count interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"country":         bucket.Country,
+		"granularity":     bucket.Granularity,
+		"start":           bucket.Start,
+		"count":           count,
+		types.SchemaField: types.SchemaVersion,
+	}
+}
+
+// countData returns the document of an invocation count with `count`, which may be a firestore.Increment
+func countData(count interface{}) map[string]interface{} {
+	return map[string]interface{}{"count": count, types.SchemaField: types.SchemaVersion}
+}
+
+// cacheData returns the document of a cached response
+func cacheData(entry types.CacheEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"key":             entry.Key,
+		"yearRecords":     entry.Records,
+		"createdAt":       entry.CreatedAt,
+		"expiresAt":       entry.ExpiresAt,
+		types.SchemaField: types.SchemaVersion,
+	}
+}
+
+// UpdateDocument reads a document and replaces it with the result of `update` in a transaction, so that
+// writes in between are not lost. `update` returns the new document, or nil to delete it, and whether
+// to write it at all. Missing documents are skipped. `update` is called again if the transaction is retried.
+func (client *FirebaseClient) UpdateDocument(collection string, id string,
+	update func(data map[string]interface{}) (map[string]interface{}, bool, error)) error {
+	docRef := client.client.Collection(collection).Doc(id)
+	return client.client.RunTransaction(client.ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		data, write, err := update(doc.Data())
+		if err != nil || !write {
+			return err
+		}
+		if data == nil {
+			return tx.Delete(docRef)
+		}
+		return tx.Set(docRef, data)
+	})
+}
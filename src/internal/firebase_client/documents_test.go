@@ -0,0 +1,61 @@
+package firebase_client
+
+import (
+	"assignment2/internal/types"
+	"cloud.google.com/go/firestore"
+	"context"
+	"testing"
+	"time"
+)
+
+// TestDocumentEncoding verifies that the documents of dead letters and delivery logs are encoded by
+// Firestore with and without a threshold crossing. The writes are encoded when they are added to a batch,
+// so that no connection to Firestore is needed.
+func TestDocumentEncoding(t *testing.T) {
+	// the client only connects to the emulator address when a request is sent
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "localhost:1")
+	client, err := firestore.NewClient(context.Background(), "test")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer client.Close()
+
+	crossing := &types.ThresholdCrossing{Threshold: 50, Direction: types.DirectionUp, OldValue: 45, NewValue: 55, Year: "2022"}
+	for _, c := range []struct {
+		name     string
+		crossing *types.ThresholdCrossing
+	}{{"calls", nil}, {"threshold", crossing}} {
+		deliveries := types.DeliveryLog{WebhookID: "abc", Deliveries: []types.Delivery{{
+			Time:    time.Now(),
+			Payload: types.DeliveryPayload{WebhookID: "abc", Country: "Norway", Calls: 5, Crossing: c.crossing},
+			Status:  200,
+			Attempt: 1,
+		}}}
+		deadLetter := types.DeadLetter{ID: "dl1", WebhookID: "abc", URL: "http://example.com", Country: "Norway", Crossing: c.crossing}
+
+		batch := client.Batch()
+		batch.Set(client.Collection(CollectionDeliveries).Doc("abc"), deliveriesData(deliveries))
+		batch.Set(client.Collection(CollectionDeadLetters).Doc("dl1"), deadLetterData(deadLetter))
+		batch.Set(client.Collection(CollectionDeliveries).Doc("abc"), deliveries)
+		batch.Set(client.Collection(CollectionDeadLetters).Doc("dl1"), deadLetter)
+	}
+}
+
+// TestLegacyRegistration verifies that registrations written before documents carried a schema version
+// are decoded from their legacy field names until the store is migrated
+func TestLegacyRegistration(t *testing.T) {
+	// Test 1: the fields are filled in from the legacy names, and the webhook ID from the document ID
+	var registration types.InvocationRegistration
+	upgradeLegacyRegistration(&registration, "abc", map[string]interface{}{"URL": "http://example.com", "Country": "NOR", "Calls": int64(5)})
+	expected := types.InvocationRegistration{WebhookID: "abc", URL: "http://example.com", Country: "NOR", Calls: 5}
+	if registration != expected {
+		t.Fatalf("expected the legacy fields to be decoded, got: %+v", registration)
+	}
+
+	// Test 2: documents with a schema version are left as they were decoded
+	registration = types.InvocationRegistration{WebhookID: "abc", URL: "http://example.com"}
+	upgradeLegacyRegistration(&registration, "abc", map[string]interface{}{types.SchemaField: types.SchemaVersion, "Country": "NOR"})
+	if registration.Country != "" {
+		t.Fatalf("expected a versioned document to be left as it is, got: %+v", registration)
+	}
+}
@@ -0,0 +1,352 @@
+package journal
+
+import (
+	"assignment2/internal/types"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MaxPendingCache = 100        // cached responses waiting to be written before new ones are dropped
+	segmentSuffix   = ".journal" // file name suffix of journal segments
+)
+
+// record is a line in a journal segment. Cached responses are not journaled, as they can be recomputed.
+type record struct {
+	Invocations  *types.InvocationBatch    `json:"invocations,omitempty"`
+	Registration *types.RegistrationAction `json:"registration,omitempty"`
+	DeadLetter   *types.DeadLetterAction   `json:"dead_letter,omitempty"`
+	Deliveries   *types.DeliveryLog        `json:"deliveries,omitempty"`
+}
+
+// Stats describes the updates waiting to be written to the store
+type Stats struct {
+	Pending   int       `json:"pending"`  // invocation batches, registrations, dead letters, delivery logs and cached responses waiting to be written
+	Dropped   int64     `json:"dropped"`  // updates lost since the service started, because the queue was full or the journal unreadable
+	Replayed  int       `json:"replayed"` // updates recovered from the journal at startup
+	Durable   bool      `json:"durable"`  // whether updates are journaled to disk
+	LastFlush time.Time `json:"last_flush,omitempty"`
+	LastError string    `json:"error,omitempty"`
+}
+
+// Journal is a write-behind queue of updates to the store. Invocation batches, registrations, dead
+// letters and delivery logs are appended to a local journal before they are queued, so that updates which have not been written to
+// the store when the process crashes are replayed when it starts again. Updates are delivered at least
+// once, which is safe because every write to the store is idempotent: a batch of invocations is only
+// added once, and every other document is set or deleted by its ID.
+//
+// The journal is split into segments. Flush starts a new segment, and deletes the old segments once
+// the updates in them have been written.
+type Journal struct {
+	dir       string // empty when updates are only kept in memory
+	lock      sync.Mutex
+	pending   *types.BundledUpdate
+	file      *os.File
+	segments  []int // numbers of the segments on disk, the last one being appended to
+	dropped   int64
+	replayed  int
+	lastFlush time.Time
+	lastError string
+}
+
+// Open opens the journal in `dir`, creating the directory if needed, and replays the updates in it.
+// If `dir` is empty, updates are only kept in memory and are lost on a crash.
+func Open(dir string) (*Journal, error) {
+	j := &Journal{dir: dir, pending: types.NewBundledUpdate()}
+	if dir == "" {
+		return j, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// replay the segments in order, so that later updates replace earlier ones
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range matches {
+		var number int
+		if _, err := fmt.Sscanf(filepath.Base(path), "%d"+segmentSuffix, &number); err == nil {
+			j.segments = append(j.segments, number)
+		}
+	}
+	sort.Ints(j.segments)
+	for _, number := range j.segments {
+		if err := j.replay(j.segmentPath(number)); err != nil {
+			return nil, err
+		}
+	}
+	j.replayed = j.pendingLocked()
+
+	// append to a new segment
+	next := 1
+	if len(j.segments) > 0 {
+		next = j.segments[len(j.segments)-1] + 1
+	}
+	if err := j.openSegment(next); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// replay adds the updates in a segment to the pending updates. A line that cannot be read, such as
+// one cut short by a crash, is counted as dropped.
+func (j *Journal) replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			log.Println("Skipping unreadable journal record in " + path + ": " + err.Error())
+			j.dropped++
+			continue
+		}
+		if r.Invocations == nil && r.Registration == nil && r.DeadLetter == nil && r.Deliveries == nil {
+			// absolute invocation counts journaled by earlier versions cannot be added to the shared counts
+			log.Println("Skipping journal record of an earlier version in " + path)
+			j.dropped++
+			continue
+		}
+		j.addLocked(r)
+	}
+	return scanner.Err()
+}
+
+// segmentPath returns the path of a segment by its number
+func (j *Journal) segmentPath(number int) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%06d%s", number, segmentSuffix))
+}
+
+// openSegment creates a new segment and appends to it from now on
+func (j *Journal) openSegment(number int) error {
+	file, err := os.OpenFile(j.segmentPath(number), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		_ = j.file.Close()
+	}
+	j.file = file
+	j.segments = append(j.segments, number)
+	return nil
+}
+
+// Pending returns a copy of the updates that have not been written to the store yet, including those
+// replayed from the journal, so that they can be applied to the state of the service at startup
+func (j *Journal) Pending() *types.BundledUpdate {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	updates := types.NewBundledUpdate()
+	merge(updates, j.pending)
+	return updates
+}
+
+// PendingRegistrations returns a copy of the registrations that have not been written to the store yet,
+// so that they can be applied over the registrations read from the store
+func (j *Journal) PendingRegistrations() map[string]types.RegistrationAction {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	registrations := make(map[string]types.RegistrationAction, len(j.pending.Registrations))
+	for webhookID, action := range j.pending.Registrations {
+		registrations[webhookID] = action
+	}
+	return registrations
+}
+
+// AddInvocations journals and queues a batch of invocations. Batches are written in the order they are added.
+func (j *Journal) AddInvocations(batch types.InvocationBatch) {
+	j.add(record{Invocations: &batch})
+}
+
+// AddRegistration journals and queues a new or deleted registration
+func (j *Journal) AddRegistration(action types.RegistrationAction) {
+	j.add(record{Registration: &action})
+}
+
+// AddDeadLetter journals and queues a new or removed dead letter
+func (j *Journal) AddDeadLetter(action types.DeadLetterAction) {
+	j.add(record{DeadLetter: &action})
+}
+
+// AddDeliveries journals and queues the delivery log of a registration, replacing the log queued before
+func (j *Journal) AddDeliveries(deliveries types.DeliveryLog) {
+	j.add(record{Deliveries: &deliveries})
+}
+
+// AddCache queues a cached response. Cached responses are not journaled, and are dropped when
+// MaxPendingCache responses are already waiting.
+func (j *Journal) AddCache(key string, entry types.CacheEntry) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.pending.Cache[key]; !ok && len(j.pending.Cache) >= MaxPendingCache {
+		j.dropped++
+		log.Println("cache queue is full! dropping data")
+		return
+	}
+	j.pending.Cache[key] = entry
+	j.pending.Ready = true
+}
+
+// DropCache removes the queued cached responses for which `match` returns true, so that a response that
+// has been invalidated is not written to the store afterwards, and returns the number removed
+func (j *Journal) DropCache(match func(entry types.CacheEntry) bool) int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	dropped := 0
+	for key, entry := range j.pending.Cache {
+		if match(entry) {
+			delete(j.pending.Cache, key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// add appends the record to the journal and queues it. If the record cannot be appended it is still
+// queued, but is lost if the process crashes before it has been written to the store.
+func (j *Journal) add(r record) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.file != nil {
+		line, err := json.Marshal(r)
+		if err == nil {
+			_, err = j.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Println("Could not append to journal: " + err.Error())
+			j.lastError = err.Error()
+		}
+	}
+	j.addLocked(r)
+}
+
+// addLocked queues the record
+func (j *Journal) addLocked(r record) {
+	if r.Registration != nil {
+		j.pending.Registrations[r.Registration.Registration.WebhookID] = *r.Registration
+	}
+	if r.DeadLetter != nil {
+		j.pending.DeadLetters[r.DeadLetter.DeadLetter.ID] = *r.DeadLetter
+	}
+	if r.Deliveries != nil {
+		j.pending.Deliveries[r.Deliveries.WebhookID] = *r.Deliveries
+	}
+	if r.Invocations != nil {
+		j.pending.Invocations = append(j.pending.Invocations, *r.Invocations)
+	}
+	j.pending.Ready = true
+}
+
+// Flush writes the pending updates with `write`, which should apply them to the store, and deletes the
+// journal segments they were recovered from. If `write` fails, the updates are queued again and their
+// segments are kept, so that they are retried on the next flush or replayed after a restart.
+func (j *Journal) Flush(write func(updates *types.BundledUpdate) error) error {
+	j.lock.Lock()
+	if !j.pending.Ready {
+		j.lock.Unlock()
+		return nil
+	}
+	updates := j.pending
+	j.pending = types.NewBundledUpdate()
+	flushed := j.segments
+	j.segments = nil
+	if j.file != nil {
+		if err := j.openSegment(flushed[len(flushed)-1] + 1); err != nil {
+			log.Println("Could not start new journal segment: " + err.Error())
+			j.segments = flushed
+			merge(updates, j.pending)
+			j.pending = updates
+			j.lastError = err.Error()
+			j.lock.Unlock()
+			return err
+		}
+	}
+	j.lock.Unlock()
+
+	err := write(updates)
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if err != nil {
+		// updates queued in the meantime are newer, and replace the failed ones or are added after them
+		merge(updates, j.pending)
+		j.pending = updates
+		j.segments = append(flushed, j.segments...)
+		j.lastError = err.Error()
+		return err
+	}
+	for _, number := range flushed {
+		if err := os.Remove(j.segmentPath(number)); err != nil && !os.IsNotExist(err) {
+			log.Println("Could not remove journal segment: " + err.Error())
+		}
+	}
+	j.lastFlush = time.Now()
+	j.lastError = ""
+	return nil
+}
+
+// Stats returns the number of pending and dropped updates
+func (j *Journal) Stats() Stats {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return Stats{
+		Pending:   j.pendingLocked(),
+		Dropped:   j.dropped,
+		Replayed:  j.replayed,
+		Durable:   j.dir != "",
+		LastFlush: j.lastFlush,
+		LastError: j.lastError,
+	}
+}
+
+// pendingLocked returns the number of updates waiting to be written
+func (j *Journal) pendingLocked() int {
+	return len(j.pending.Invocations) + len(j.pending.Registrations) + len(j.pending.DeadLetters) + len(j.pending.Deliveries) +
+		len(j.pending.Cache)
+}
+
+// Close closes the segment being appended to. Pending updates stay in the journal and are replayed
+// when it is opened again.
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// merge copies the updates in `from` into `into`, adding the invocation batches after those in `into` and
+// replacing other updates with the same key
+func merge(into *types.BundledUpdate, from *types.BundledUpdate) {
+	into.Invocations = append(into.Invocations, from.Invocations...)
+	for id, action := range from.Registrations {
+		into.Registrations[id] = action
+	}
+	for id, action := range from.DeadLetters {
+		into.DeadLetters[id] = action
+	}
+	for id, deliveries := range from.Deliveries {
+		into.Deliveries[id] = deliveries
+	}
+	for key, entry := range from.Cache {
+		into.Cache[key] = entry
+	}
+	into.Ready = into.Ready || from.Ready
+}
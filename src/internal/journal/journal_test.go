@@ -0,0 +1,109 @@
+package journal
+
+import (
+	"assignment2/internal/types"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	registration := types.RegistrationAction{Add: true, Registration: types.InvocationRegistration{WebhookID: "abc", Country: "NOR", Calls: 2}}
+
+	batch := func(seq int64, count int64) types.InvocationBatch {
+		return types.InvocationBatch{Instance: "a", Seq: seq, Counts: map[string]int64{"NOR": count}}
+	}
+
+	// Test 1: journaled updates are replayed when the journal is opened again, with the invocation batches in order
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	j.AddInvocations(batch(1, 5))
+	j.AddInvocations(batch(2, 4))
+	j.AddRegistration(registration)
+	j.AddDeadLetter(types.DeadLetterAction{Add: true, DeadLetter: types.DeadLetter{ID: "dl1", WebhookID: "abc", Calls: 2}})
+	j.AddDeliveries(types.DeliveryLog{WebhookID: "abc", Deliveries: []types.Delivery{{Status: 503, Attempt: 1}}})
+	j.AddDeliveries(types.DeliveryLog{WebhookID: "abc", Deliveries: []types.Delivery{{Status: 503, Attempt: 1}, {Status: 200, Attempt: 2}}})
+	j.AddCache("/current/nor", types.CacheEntry{Key: "/current/nor"})
+	if stats := j.Stats(); stats.Pending != 6 || !stats.Durable {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	_ = j.Close()
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	pending := j.Pending()
+	if len(pending.Invocations) != 2 || pending.Invocations[0].Counts["NOR"] != 5 || !pending.Registrations["abc"].Add ||
+		!pending.DeadLetters["dl1"].Add || len(pending.Deliveries["abc"].Deliveries) != 2 || len(pending.Cache) != 0 {
+		t.Fatalf("unexpected replayed updates: %+v", pending)
+	}
+	if stats := j.Stats(); stats.Replayed != 5 {
+		t.Fatal("expected 5 replayed updates, got: ", stats.Replayed)
+	}
+
+	// Test 2: updates are kept when they cannot be written, and newer batches are queued after them
+	err = j.Flush(func(updates *types.BundledUpdate) error {
+		j.AddInvocations(batch(3, 6))
+		return errors.New("store is down")
+	})
+	if pending := j.Pending(); err == nil || len(pending.Invocations) != 3 || pending.Invocations[2].Seq != 3 || j.Stats().LastError != "store is down" {
+		t.Fatalf("expected failed updates to be queued again, got: %+v", j.Pending())
+	}
+
+	// Test 3: written updates are removed from the journal
+	var written *types.BundledUpdate
+	if err := j.Flush(func(updates *types.BundledUpdate) error { written = updates; return nil }); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if len(written.Invocations) != 3 || len(written.Registrations) != 1 || j.Stats().Pending != 0 {
+		t.Fatalf("unexpected written updates: %+v", written)
+	}
+	_ = j.Close()
+	j, _ = Open(dir)
+	if j.Stats().Replayed != 0 {
+		t.Fatal("expected written updates not to be replayed")
+	}
+	_ = j.Close()
+
+	// Test 4: a record cut short by a crash, and an absolute count journaled by an earlier version, are counted as dropped
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	_ = os.WriteFile(segments[0], []byte("{\"invocations\":{\"instance\":\"a\",\"seq\":4,\"counts\":{\"SWE\":3}}}\n"+
+		"{\"country\":\"SWE\",\"count\":3}\n{\"inv"), 0o644)
+	j, _ = Open(dir)
+	if stats := j.Stats(); stats.Replayed != 1 || stats.Dropped != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	_ = j.Close()
+}
+
+func TestJournalInMemory(t *testing.T) {
+	j, err := Open("")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	// Test 1: cached responses are dropped when the queue is full
+	for i := 0; i <= MaxPendingCache; i++ {
+		j.AddCache(strconv.Itoa(i), types.CacheEntry{})
+	}
+	if stats := j.Stats(); stats.Pending != MaxPendingCache || stats.Dropped != 1 || stats.Durable {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if dropped := j.DropCache(func(entry types.CacheEntry) bool { return true }); dropped != MaxPendingCache || len(j.Pending().Cache) != 0 {
+		t.Fatal("expected the queued cached responses to be dropped, got: ", dropped)
+	}
+
+	// Test 2: nothing is written when nothing is pending
+	_ = j.Flush(func(updates *types.BundledUpdate) error { return nil })
+	calls := 0
+	_ = j.Flush(func(updates *types.BundledUpdate) error { calls++; return nil })
+	if calls != 0 {
+		t.Fatal("expected empty queue not to be written")
+	}
+}
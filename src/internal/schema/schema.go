@@ -0,0 +1,215 @@
+// Package schema upgrades the documents persisted by earlier versions of the service to the current
+// schema version, with a migration for every collection and version that changed.
+package schema
+
+import (
+	"assignment2/internal/firebase_client"
+	"assignment2/internal/types"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Collections are the collections whose documents carry a schema version, in the order they are migrated
+var Collections = []string{
+	firebase_client.CollectionInvocationRegistrations,
+	firebase_client.CollectionInvocationCounts,
+	firebase_client.CollectionInvocationBuckets,
+	firebase_client.CollectionInvocationBatches,
+	firebase_client.CollectionLeases,
+	firebase_client.CollectionDeadLetters,
+	firebase_client.CollectionDeliveries,
+	firebase_client.CollectionRenewablesCache,
+}
+
+// Migration upgrades the documents of a collection from version From to From+1. A migration may be
+// interrupted and run again on documents it has already changed, so it must be idempotent.
+type Migration struct {
+	Collection  string
+	From        int
+	Description string
+	// Apply returns the upgraded document, which may be `doc` itself, or nil to delete the document
+	Apply func(id string, doc map[string]any) (map[string]any, error)
+}
+
+// Outcome is what upgrading a document did to it
+type Outcome int
+
+const (
+	Current  Outcome = iota // the document was already at the current version
+	Upgraded                // the document was upgraded, and must be written back
+	Deleted                 // the document was dropped by a migration, and must be deleted
+	Newer                   // the document was written by a later version of the service, and is left as it is
+)
+
+// Migrations are the migrations of every collection, in the order they are applied
+var Migrations = []Migration{
+	{
+		Collection:  firebase_client.CollectionInvocationRegistrations,
+		From:        0,
+		Description: "rename the fields written from the Go struct names to the names used by the API, and fill in missing webhook IDs",
+		Apply: func(id string, doc map[string]any) (map[string]any, error) {
+			rename(doc, firebase_client.LegacyRegistrationFields)
+			if webhookID, _ := doc["webhook_id"].(string); webhookID == "" {
+				doc["webhook_id"] = id
+			}
+			return doc, nil
+		},
+	},
+	{
+		Collection:  firebase_client.CollectionInvocationCounts,
+		From:        0,
+		Description: "drop the update time that was written next to the count",
+		Apply: func(id string, doc map[string]any) (map[string]any, error) {
+			delete(doc, "time")
+			return doc, nil
+		},
+	},
+	{
+		Collection:  firebase_client.CollectionRenewablesCache,
+		From:        0,
+		Description: "delete cached responses without an expiry time, as they are always treated as expired",
+		Apply: func(id string, doc map[string]any) (map[string]any, error) {
+			if _, ok := doc["expiresAt"]; !ok {
+				return nil, nil
+			}
+			return doc, nil
+		},
+	},
+}
+
+// rename moves the fields to their new names, unless a field with the new name exists
+func rename(doc map[string]any, names map[string]string) {
+	for old, name := range names {
+		value, ok := doc[old]
+		if !ok {
+			continue
+		}
+		delete(doc, old)
+		if _, exists := doc[name]; !exists {
+			doc[name] = value
+		}
+	}
+}
+
+// Upgrade applies the migrations of the collection to a document, from its schema version to the current
+// version. The upgraded document is stamped with the current version. `doc` may be modified.
+func Upgrade(collection string, id string, doc map[string]any) (map[string]any, Outcome, error) {
+	version, err := Version(doc)
+	if err != nil {
+		return nil, Current, fmt.Errorf("%s/%s: %w", collection, id, err)
+	}
+	if version > types.SchemaVersion {
+		return doc, Newer, nil
+	}
+	if version == types.SchemaVersion {
+		return doc, Current, nil
+	}
+	for ; version < types.SchemaVersion; version++ {
+		for _, migration := range Migrations {
+			if migration.Collection != collection || migration.From != version {
+				continue
+			}
+			if doc, err = migration.Apply(id, doc); err != nil {
+				return nil, Current, fmt.Errorf("%s/%s: %s: %w", collection, id, migration.Description, err)
+			}
+			if doc == nil {
+				return nil, Deleted, nil
+			}
+		}
+	}
+	doc[types.SchemaField] = types.SchemaVersion
+	return doc, Upgraded, nil
+}
+
+// Version returns the schema version of a document, which is 0 if the document has none. The version may
+// be decoded from Firestore or from JSON, with or without json.Decoder.UseNumber.
+func Version(doc map[string]any) (int, error) {
+	switch version := doc[types.SchemaField].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return version, nil
+	case int64:
+		return int(version), nil
+	case float64:
+		return int(version), nil
+	case json.Number:
+		n, err := version.Int64()
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("invalid schema version %v", version)
+	}
+}
+
+// CollectionReport counts the documents of a collection by what a migration run did to them
+type CollectionReport struct {
+	Scanned  int `json:"scanned"`
+	Upgraded int `json:"upgraded"`
+	Deleted  int `json:"deleted"`
+	Newer    int `json:"newer"` // written by a later version of the service, and left as they are
+}
+
+// Report describes a migration run. In a dry run, the documents are counted as if they had been written.
+type Report struct {
+	Version     int                          `json:"version"`
+	DryRun      bool                         `json:"dry_run"`
+	Collections map[string]*CollectionReport `json:"collections"`
+}
+
+// NewReport returns an empty report of a migration to the current version
+func NewReport(dryRun bool) *Report {
+	return &Report{Version: types.SchemaVersion, DryRun: dryRun, Collections: map[string]*CollectionReport{}}
+}
+
+// Add counts a document of the collection with the outcome of its upgrade
+func (r *Report) Add(collection string, outcome Outcome) {
+	counts, ok := r.Collections[collection]
+	if !ok {
+		counts = &CollectionReport{}
+		r.Collections[collection] = counts
+	}
+	counts.Scanned++
+	switch outcome {
+	case Upgraded:
+		counts.Upgraded++
+	case Deleted:
+		counts.Deleted++
+	case Newer:
+		counts.Newer++
+	}
+}
+
+// Changed returns the number of documents that were upgraded or deleted
+func (r *Report) Changed() int {
+	changed := 0
+	for _, counts := range r.Collections {
+		changed += counts.Upgraded + counts.Deleted
+	}
+	return changed
+}
+
+// String summarises the changes by collection, e.g. "Invocation registrations: 2 upgraded, 0 deleted, 0 newer"
+func (r *Report) String() string {
+	prefix := ""
+	if r.DryRun {
+		prefix = "dry run, "
+	}
+	names := make([]string, 0, len(r.Collections))
+	for name, counts := range r.Collections {
+		if counts.Upgraded+counts.Deleted+counts.Newer > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("%sall documents are at schema version %d", prefix, r.Version)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		counts := r.Collections[name]
+		parts[i] = fmt.Sprintf("%s: %d upgraded, %d deleted, %d newer", name, counts.Upgraded, counts.Deleted, counts.Newer)
+	}
+	return prefix + strings.Join(parts, "; ")
+}
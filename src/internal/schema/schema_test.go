@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"assignment2/internal/firebase_client"
+	"assignment2/internal/types"
+	"encoding/json"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	// Test 1: registrations written from the Go struct names are renamed, and stamped with the current version
+	legacy := map[string]any{"URL": "http://example.com", "Country": "NOR", "Calls": int64(5)}
+	doc, outcome, err := Upgrade(firebase_client.CollectionInvocationRegistrations, "abc", legacy)
+	if err != nil || outcome != Upgraded {
+		t.Fatal("expected the registration to be upgraded, got: ", outcome, err)
+	}
+	if doc["webhook_id"] != "abc" || doc["url"] != "http://example.com" || doc["calls"] != int64(5) || doc["URL"] != nil ||
+		doc[types.SchemaField] != types.SchemaVersion {
+		t.Fatal("unexpected upgraded registration: ", doc)
+	}
+
+	// Test 2: upgrading is idempotent, and leaves current documents as they are
+	if _, outcome, _ := Upgrade(firebase_client.CollectionInvocationRegistrations, "abc", doc); outcome != Current {
+		t.Fatal("expected the upgraded registration to be current, got: ", outcome)
+	}
+	var decoded map[string]any
+	_ = json.Unmarshal([]byte(`{"count": 3, "schema_version": 1}`), &decoded)
+	if _, outcome, _ := Upgrade(firebase_client.CollectionInvocationCounts, "NOR", decoded); outcome != Current {
+		t.Fatal("expected a decoded current document to be current, got: ", outcome)
+	}
+
+	// Test 3: cached responses without an expiry time are deleted, and counts lose their update time
+	if _, outcome, _ := Upgrade(firebase_client.CollectionRenewablesCache, "key", map[string]any{"yearRecords": []any{}}); outcome != Deleted {
+		t.Fatal("expected the cached response without an expiry time to be deleted, got: ", outcome)
+	}
+	doc, _, _ = Upgrade(firebase_client.CollectionInvocationCounts, "NOR", map[string]any{"count": int64(3), "time": "2023-04-20"})
+	if _, ok := doc["time"]; ok || doc["count"] != int64(3) {
+		t.Fatal("unexpected upgraded count: ", doc)
+	}
+
+	// Test 4: documents of a later version are left as they are, and invalid versions are rejected
+	if _, outcome, _ := Upgrade(firebase_client.CollectionLeases, "x", map[string]any{types.SchemaField: int64(types.SchemaVersion + 1)}); outcome != Newer {
+		t.Fatal("expected the document to be newer, got: ", outcome)
+	}
+	if _, _, err := Upgrade(firebase_client.CollectionLeases, "x", map[string]any{types.SchemaField: "one"}); err == nil {
+		t.Fatal("expected an invalid schema version to be rejected")
+	}
+
+	// Test 5: the report counts the documents by outcome
+	report := NewReport(true)
+	report.Add(firebase_client.CollectionLeases, Upgraded)
+	report.Add(firebase_client.CollectionLeases, Current)
+	report.Add(firebase_client.CollectionRenewablesCache, Deleted)
+	if report.Changed() != 2 || report.Collections[firebase_client.CollectionLeases].Scanned != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
@@ -0,0 +1,80 @@
+// Package signing signs the webhooks posted by the service with the secret of their registration, so
+// that receivers can verify that a webhook was posted by the service, and has not been changed or replayed.
+//
+// Every webhook carries the time it was posted, in Unix seconds, in TimestampHeader, and an HMAC-SHA256
+// of the timestamp, a dot and the body in SignatureHeader, as "v1=" followed by the signature in hex.
+// While the secret of a registration is being rotated, the header holds a signature for both the new and
+// the old secret, separated by commas, so that receivers may verify with either of them.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Webhook-Timestamp" // header holding the time the webhook was posted, in Unix seconds
+	SignatureHeader = "X-Webhook-Signature" // header holding the signatures of the webhook
+	Scheme          = "v1"                  // prefix of HMAC-SHA256 signatures in SignatureHeader
+	Tolerance       = 5 * time.Minute       // recommended age beyond which receivers reject a webhook as replayed
+	SecretPrefix    = "whsec_"              // prefix of the secrets generated by the service
+)
+
+// ErrTimestamp is returned by Verify when the timestamp is missing, unreadable or outside the tolerance
+var ErrTimestamp = errors.New("signing: timestamp outside the tolerance")
+
+// ErrSignature is returned by Verify when no signature matches the secret
+var ErrSignature = errors.New("signing: no signature matches the secret")
+
+// NewSecret returns a random secret for signing the webhooks of a registration
+func NewSecret() string {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return SecretPrefix + hex.EncodeToString(secret)
+}
+
+// Sign returns the signature of the body posted at `timestamp`, in Unix seconds, with the secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Header returns the value of SignatureHeader for the body posted at `timestamp`, with a signature for
+// every secret in order
+func Header(secrets []string, timestamp int64, body []byte) string {
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		signatures = append(signatures, Scheme+"="+Sign(secret, timestamp, body))
+	}
+	return strings.Join(signatures, ",")
+}
+
+// Verify checks the values of TimestampHeader and SignatureHeader of a webhook against the secret. An
+// error is returned if the timestamp is further than `tolerance` from `now`, or if none of the signatures
+// matches. Signatures are compared in constant time.
+func Verify(secret string, timestamp string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrTimestamp
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrTimestamp
+	}
+	expected := []byte(Sign(secret, seconds, body))
+	for _, signature := range strings.Split(header, ",") {
+		scheme, value, ok := strings.Cut(strings.TrimSpace(signature), "=")
+		if ok && scheme == Scheme && hmac.Equal([]byte(value), expected) {
+			return nil
+		}
+	}
+	return ErrSignature
+}
@@ -0,0 +1,54 @@
+package signing
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigning(t *testing.T) {
+	body := []byte(`{"webhook_id":"abc","country":"Norway","calls":5}`)
+	now := time.Unix(1682000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	// Test 1: a signature verifies with its secret, and not with another secret or another body
+	header := Header([]string{"secret"}, now.Unix(), body)
+	if !strings.HasPrefix(header, Scheme+"=") {
+		t.Fatal("expected a versioned signature, got: ", header)
+	}
+	if err := Verify("secret", timestamp, header, body, now, Tolerance); err != nil {
+		t.Fatal("expected the signature to verify, got: ", err)
+	}
+	if err := Verify("other", timestamp, header, body, now, Tolerance); !errors.Is(err, ErrSignature) {
+		t.Fatal("expected another secret to be rejected, got: ", err)
+	}
+	if err := Verify("secret", timestamp, header, []byte(`{"calls":6}`), now, Tolerance); !errors.Is(err, ErrSignature) {
+		t.Fatal("expected a changed body to be rejected, got: ", err)
+	}
+
+	// Test 2: webhooks posted longer ago than the tolerance are rejected as replayed
+	if err := Verify("secret", timestamp, header, body, now.Add(Tolerance+time.Second), Tolerance); !errors.Is(err, ErrTimestamp) {
+		t.Fatal("expected an old timestamp to be rejected, got: ", err)
+	}
+	if err := Verify("secret", "", header, body, now, Tolerance); !errors.Is(err, ErrTimestamp) {
+		t.Fatal("expected a missing timestamp to be rejected, got: ", err)
+	}
+
+	// Test 3: while a secret is rotated, both the new and the old secret verify
+	header = Header([]string{"new", "old"}, now.Unix(), body)
+	if strings.Count(header, ",") != 1 {
+		t.Fatal("expected two signatures, got: ", header)
+	}
+	for _, secret := range []string{"new", "old"} {
+		if err := Verify(secret, timestamp, header, body, now, Tolerance); err != nil {
+			t.Fatalf("expected the %s secret to verify, got: %v", secret, err)
+		}
+	}
+
+	// Test 4: generated secrets are random
+	if a, b := NewSecret(), NewSecret(); a == b || !strings.HasPrefix(a, SecretPrefix) || len(a) != len(SecretPrefix)+64 {
+		t.Fatal("expected random secrets, got: ", a, b)
+	}
+}
@@ -0,0 +1,573 @@
+package store
+
+import (
+	"assignment2/internal/firebase_client"
+	"assignment2/internal/schema"
+	"assignment2/internal/types"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// documents is a minimal store of JSON documents grouped in collections. It is implemented by the
+// memory, JSON-file and SQLite backends, which share the mapping to a Store in docStore.
+type documents interface {
+	// get returns the document, or ErrNotFound
+	get(collection string, id string) ([]byte, error)
+	// list returns every document in the collection by its id
+	list(collection string) (map[string][]byte, error)
+	// apply performs all writes, or none of them if an error is returned
+	apply(writes []write) error
+	// update calls `f` to read documents and decide on writes, and performs the writes without any other
+	// writes in between. Nothing is written if `f` or the writes fail.
+	update(f func(get reader) ([]write, error)) error
+	// ping returns an error if the documents cannot be reached
+	ping() error
+	// close releases the resources held by the documents
+	close() error
+}
+
+// reader returns a document, or ErrNotFound
+type reader func(collection string, id string) ([]byte, error)
+
+// write sets a document, or deletes it if `doc` is nil
+type write struct {
+	collection string
+	id         string
+	doc        []byte
+}
+
+// writeList collects the writes of an update
+type writeList []write
+
+// set adds a write that sets the document to `value` encoded as a JSON object, stamped with the schema version
+func (l *writeList) set(collection string, id string, value any) error {
+	doc, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, write{collection: collection, id: id, doc: stamp(doc)})
+	return nil
+}
+
+// stamp adds the schema version to a document encoded as a JSON object
+func stamp(doc []byte) []byte {
+	stamped := []byte(`{"` + types.SchemaField + `":` + strconv.Itoa(types.SchemaVersion))
+	if len(bytes.TrimSpace(doc[1:len(doc)-1])) > 0 {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, doc[1:]...)
+}
+
+// delete adds a write that deletes the document
+func (l *writeList) delete(collection string, id string) {
+	*l = append(*l, write{collection: collection, id: id})
+}
+
+// docStore implements Store on top of documents, using the same collections as Firestore
+type docStore struct {
+	name string
+	docs documents
+}
+
+// countDoc is the document stored for the invocation count of a country
+type countDoc struct {
+	Count int64 `json:"count"`
+}
+
+// batchDoc is the document stored for the last invocation batch added by an instance
+type batchDoc struct {
+	Seq int64 `json:"seq"`
+}
+
+// Name returns the name of the backend
+func (s *docStore) Name() string {
+	return s.name
+}
+
+// GetAllInvocationCounts returns the invocation count of every country
+func (s *docStore) GetAllInvocationCounts() (map[string]int64, error) {
+	docs, err := s.docs.list(firebase_client.CollectionInvocationCounts)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(docs))
+	for country, doc := range docs {
+		var count countDoc
+		if err := json.Unmarshal(doc, &count); err != nil {
+			return nil, err
+		}
+		counts[country] = count.Count
+	}
+	return counts, nil
+}
+
+// GetInvocationCounts returns the invocation counts of the countries that have been invocated
+func (s *docStore) GetInvocationCounts(countries []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(countries))
+	for _, country := range countries {
+		var count countDoc
+		found, err := decode(s.docs.get, firebase_client.CollectionInvocationCounts, country, &count)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			counts[country] = count.Count
+		}
+	}
+	return counts, nil
+}
+
+// GetInvocationBuckets returns the hourly and daily invocation buckets that start from `from` until `to`
+func (s *docStore) GetInvocationBuckets(from time.Time, to time.Time) ([]types.InvocationBucket, error) {
+	docs, err := s.docs.list(firebase_client.CollectionInvocationBuckets)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []types.InvocationBucket
+	for _, doc := range docs {
+		var bucket types.InvocationBucket
+		if err := json.Unmarshal(doc, &bucket); err != nil {
+			return nil, err
+		}
+		if !bucket.Start.Before(from) && bucket.Start.Before(to) {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets, nil
+}
+
+// GetAllRegistrations returns every webhook registration by its webhook ID
+func (s *docStore) GetAllRegistrations() (map[string]types.InvocationRegistration, error) {
+	docs, err := s.docs.list(firebase_client.CollectionInvocationRegistrations)
+	if err != nil {
+		return nil, err
+	}
+	registrations := make(map[string]types.InvocationRegistration, len(docs))
+	for id, doc := range docs {
+		var registration types.InvocationRegistration
+		if err := json.Unmarshal(doc, &registration); err != nil {
+			return nil, err
+		}
+		registrations[id] = registration
+	}
+	return registrations, nil
+}
+
+// GetAllDeadLetters returns every webhook that could not be delivered by its ID
+func (s *docStore) GetAllDeadLetters() (map[string]types.DeadLetter, error) {
+	docs, err := s.docs.list(firebase_client.CollectionDeadLetters)
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := make(map[string]types.DeadLetter, len(docs))
+	for id, doc := range docs {
+		var deadLetter types.DeadLetter
+		if err := json.Unmarshal(doc, &deadLetter); err != nil {
+			return nil, err
+		}
+		deadLetters[id] = deadLetter
+	}
+	return deadLetters, nil
+}
+
+// GetAllDeliveries returns the delivery log of every registration by its webhook ID
+func (s *docStore) GetAllDeliveries() (map[string]types.DeliveryLog, error) {
+	docs, err := s.docs.list(firebase_client.CollectionDeliveries)
+	if err != nil {
+		return nil, err
+	}
+	logs := make(map[string]types.DeliveryLog, len(docs))
+	for id, doc := range docs {
+		var deliveries types.DeliveryLog
+		if err := json.Unmarshal(doc, &deliveries); err != nil {
+			return nil, err
+		}
+		logs[id] = deliveries
+	}
+	return logs, nil
+}
+
+// GetCacheEntry returns the cached response for the key, or ErrNotFound
+func (s *docStore) GetCacheEntry(key string) (types.CacheEntry, error) {
+	doc, err := s.docs.get(firebase_client.CollectionRenewablesCache, key)
+	if err != nil {
+		return types.CacheEntry{}, err
+	}
+	var entry types.CacheEntry
+	err = json.Unmarshal(doc, &entry)
+	return entry, err
+}
+
+// GetAllCacheEntries returns every cached response, including expired entries. Entries that cannot be read are skipped.
+func (s *docStore) GetAllCacheEntries() ([]types.CacheEntry, error) {
+	docs, err := s.docs.list(firebase_client.CollectionRenewablesCache)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]types.CacheEntry, 0, len(docs))
+	for _, doc := range docs {
+		var entry types.CacheEntry
+		if err := json.Unmarshal(doc, &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// DeleteCacheEntry removes the cached response for the key
+func (s *docStore) DeleteCacheEntry(key string) error {
+	return s.docs.apply([]write{{collection: firebase_client.CollectionRenewablesCache, id: key}})
+}
+
+// PurgeExpiredCache removes every expired cached response, including entries that cannot be read
+func (s *docStore) PurgeExpiredCache() (int, error) {
+	docs, err := s.docs.list(firebase_client.CollectionRenewablesCache)
+	if err != nil {
+		return 0, err
+	}
+	var writes []write
+	for key, doc := range docs {
+		var entry types.CacheEntry
+		if err := json.Unmarshal(doc, &entry); err != nil || entry.Expired() {
+			writes = append(writes, write{collection: firebase_client.CollectionRenewablesCache, id: key})
+		}
+	}
+	if len(writes) == 0 {
+		return 0, nil
+	}
+	return len(writes), s.docs.apply(writes)
+}
+
+// BulkWrite adds the bundled invocation batches in order, and applies the registrations, dead letters, delivery
+// logs and cached responses in a single batch
+func (s *docStore) BulkWrite(updates *types.BundledUpdate) error {
+	for _, batch := range updates.Invocations {
+		if err := s.AddInvocations(batch); err != nil {
+			return err
+		}
+	}
+	if len(updates.Registrations) == 0 && len(updates.DeadLetters) == 0 && len(updates.Deliveries) == 0 && len(updates.Cache) == 0 {
+		return nil
+	}
+	return s.docs.update(func(get reader) ([]write, error) {
+		var writes writeList
+
+		for id, action := range updates.Registrations {
+			lease := types.WebhookLease(id)
+			if !action.Add {
+				writes.delete(firebase_client.CollectionInvocationRegistrations, id)
+				writes.delete(firebase_client.CollectionLeases, lease)
+				writes.delete(firebase_client.CollectionDeliveries, id)
+				continue
+			}
+			if err := writes.set(firebase_client.CollectionInvocationRegistrations, id, action.Registration); err != nil {
+				return nil, err
+			}
+			// a lease that exists has been acquired since, and has the latest checkpoint
+			if _, err := get(firebase_client.CollectionLeases, lease); errors.Is(err, ErrNotFound) {
+				if err := writes.set(firebase_client.CollectionLeases, lease, types.Lease{Name: lease, Checkpoint: action.Checkpoint}); err != nil {
+					return nil, err
+				}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		for id, action := range updates.DeadLetters {
+			if !action.Add {
+				writes.delete(firebase_client.CollectionDeadLetters, id)
+			} else if err := writes.set(firebase_client.CollectionDeadLetters, id, action.DeadLetter); err != nil {
+				return nil, err
+			}
+		}
+		for id, deliveries := range updates.Deliveries {
+			if action, ok := updates.Registrations[id]; ok && !action.Add {
+				continue
+			}
+			if err := writes.set(firebase_client.CollectionDeliveries, id, deliveries); err != nil {
+				return nil, err
+			}
+		}
+		for key, entry := range updates.Cache {
+			if err := writes.set(firebase_client.CollectionRenewablesCache, key, entry); err != nil {
+				return nil, err
+			}
+		}
+		return writes, nil
+	})
+}
+
+// AddInvocations adds the counts of the batch to the invocation counts and buckets, and records the sequence
+// number of the batch for its instance, in a single update
+func (s *docStore) AddInvocations(batch types.InvocationBatch) error {
+	return s.docs.update(func(get reader) ([]write, error) {
+		var last batchDoc
+		if _, err := decode(get, firebase_client.CollectionInvocationBatches, batch.Instance, &last); err != nil {
+			return nil, err
+		}
+		if batch.Seq <= last.Seq {
+			return nil, nil
+		}
+
+		var writes writeList
+		for country, n := range batch.Counts {
+			var count countDoc
+			if _, err := decode(get, firebase_client.CollectionInvocationCounts, country, &count); err != nil {
+				return nil, err
+			}
+			count.Count += n
+			if err := writes.set(firebase_client.CollectionInvocationCounts, country, count); err != nil {
+				return nil, err
+			}
+		}
+		for _, bucket := range batch.Buckets {
+			var stored types.InvocationBucket
+			if _, err := decode(get, firebase_client.CollectionInvocationBuckets, bucket.ID(), &stored); err != nil {
+				return nil, err
+			}
+			bucket.Count += stored.Count
+			if err := writes.set(firebase_client.CollectionInvocationBuckets, bucket.ID(), bucket); err != nil {
+				return nil, err
+			}
+		}
+		if err := writes.set(firebase_client.CollectionInvocationBatches, batch.Instance, batchDoc{Seq: batch.Seq}); err != nil {
+			return nil, err
+		}
+		return writes, nil
+	})
+}
+
+// Restore writes the registrations, invocation counts and buckets of the archive in a single update
+func (s *docStore) Restore(archive *types.Archive, replace bool) error {
+	var registrations, counts, buckets, deadLetters, logs map[string][]byte
+	if replace {
+		var err error
+		if registrations, err = s.docs.list(firebase_client.CollectionInvocationRegistrations); err != nil {
+			return err
+		}
+		if counts, err = s.docs.list(firebase_client.CollectionInvocationCounts); err != nil {
+			return err
+		}
+		if buckets, err = s.docs.list(firebase_client.CollectionInvocationBuckets); err != nil {
+			return err
+		}
+		if deadLetters, err = s.docs.list(firebase_client.CollectionDeadLetters); err != nil {
+			return err
+		}
+		if logs, err = s.docs.list(firebase_client.CollectionDeliveries); err != nil {
+			return err
+		}
+	}
+	return s.docs.update(func(get reader) ([]write, error) {
+		var writes writeList
+
+		// the count of every archived country after the restore, to checkpoint the webhook leases
+		final := make(map[string]int64, len(archive.InvocationCounts))
+		for country, n := range archive.InvocationCounts {
+			delete(counts, country)
+			if !replace {
+				var stored countDoc
+				if _, err := decode(get, firebase_client.CollectionInvocationCounts, country, &stored); err != nil {
+					return nil, err
+				}
+				if stored.Count > n {
+					final[country] = stored.Count
+					continue
+				}
+			}
+			final[country] = n
+			if err := writes.set(firebase_client.CollectionInvocationCounts, country, countDoc{Count: n}); err != nil {
+				return nil, err
+			}
+		}
+		for _, bucket := range archive.InvocationBuckets {
+			delete(buckets, bucket.ID())
+			if !replace {
+				var stored types.InvocationBucket
+				if _, err := decode(get, firebase_client.CollectionInvocationBuckets, bucket.ID(), &stored); err != nil {
+					return nil, err
+				}
+				if stored.Count > bucket.Count {
+					continue
+				}
+			}
+			if err := writes.set(firebase_client.CollectionInvocationBuckets, bucket.ID(), bucket); err != nil {
+				return nil, err
+			}
+		}
+		for _, registration := range archive.Registrations {
+			delete(registrations, registration.WebhookID)
+			if err := writes.set(firebase_client.CollectionInvocationRegistrations, registration.WebhookID, registration); err != nil {
+				return nil, err
+			}
+			lease := types.WebhookLease(registration.WebhookID)
+			if !replace {
+				if _, err := get(firebase_client.CollectionLeases, lease); err == nil {
+					continue
+				} else if !errors.Is(err, ErrNotFound) {
+					return nil, err
+				}
+			}
+			if err := writes.set(firebase_client.CollectionLeases, lease, types.Lease{Name: lease, Checkpoint: final[registration.Country]}); err != nil {
+				return nil, err
+			}
+		}
+		for _, deadLetter := range archive.DeadLetters {
+			delete(deadLetters, deadLetter.ID)
+			if err := writes.set(firebase_client.CollectionDeadLetters, deadLetter.ID, deadLetter); err != nil {
+				return nil, err
+			}
+		}
+		for _, deliveries := range archive.Deliveries {
+			delete(logs, deliveries.WebhookID)
+			if err := writes.set(firebase_client.CollectionDeliveries, deliveries.WebhookID, deliveries); err != nil {
+				return nil, err
+			}
+		}
+
+		// only left with the documents that are not in the archive when replacing
+		for id := range registrations {
+			writes.delete(firebase_client.CollectionInvocationRegistrations, id)
+			writes.delete(firebase_client.CollectionLeases, types.WebhookLease(id))
+		}
+		for country := range counts {
+			writes.delete(firebase_client.CollectionInvocationCounts, country)
+		}
+		for id := range buckets {
+			writes.delete(firebase_client.CollectionInvocationBuckets, id)
+		}
+		for id := range deadLetters {
+			writes.delete(firebase_client.CollectionDeadLetters, id)
+		}
+		for id := range logs {
+			writes.delete(firebase_client.CollectionDeliveries, id)
+		}
+		return writes, nil
+	})
+}
+
+// AcquireLease acquires or renews the lease for `owner`, unless another owner holds it
+func (s *docStore) AcquireLease(name string, owner string, ttl time.Duration, checkpoint int64) (types.Lease, bool, error) {
+	var lease types.Lease
+	acquired := false
+	err := s.docs.update(func(get reader) ([]write, error) {
+		lease = types.Lease{Name: name, Checkpoint: checkpoint}
+		if _, err := decode(get, firebase_client.CollectionLeases, name, &lease); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if lease.Held(owner, now) {
+			return nil, nil
+		}
+		lease.Owner, lease.Expires = owner, now.Add(ttl)
+		var writes writeList
+		if err := writes.set(firebase_client.CollectionLeases, name, lease); err != nil {
+			return nil, err
+		}
+		acquired = true
+		return writes, nil
+	})
+	if err != nil {
+		return types.Lease{}, false, err
+	}
+	return lease, acquired, nil
+}
+
+// UpdateLease stores the lease, or returns ErrLeaseLost if another owner has acquired it
+func (s *docStore) UpdateLease(lease types.Lease) error {
+	return s.docs.update(func(get reader) ([]write, error) {
+		var stored types.Lease
+		found, err := decode(get, firebase_client.CollectionLeases, lease.Name, &stored)
+		if err != nil {
+			return nil, err
+		}
+		if !found || stored.Owner != lease.Owner {
+			return nil, ErrLeaseLost
+		}
+		var writes writeList
+		err = writes.set(firebase_client.CollectionLeases, lease.Name, lease)
+		return writes, err
+	})
+}
+
+// Migrate upgrades the documents of every collection in a single update per collection
+func (s *docStore) Migrate(dryRun bool) (*schema.Report, error) {
+	report := schema.NewReport(dryRun)
+	for _, collection := range schema.Collections {
+		docs, err := s.docs.list(collection)
+		if err != nil {
+			return nil, err
+		}
+		var outcomes []schema.Outcome
+		err = s.docs.update(func(get reader) ([]write, error) {
+			var writes writeList
+			outcomes = outcomes[:0]
+			for id := range docs {
+				data, err := get(collection, id)
+				if errors.Is(err, ErrNotFound) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				decoder := json.NewDecoder(bytes.NewReader(data))
+				decoder.UseNumber()
+				var doc map[string]any
+				if err := decoder.Decode(&doc); err != nil {
+					return nil, fmt.Errorf("%s/%s: %w", collection, id, err)
+				}
+				upgraded, outcome, err := schema.Upgrade(collection, id, doc)
+				if err != nil {
+					return nil, err
+				}
+				outcomes = append(outcomes, outcome)
+				switch outcome {
+				case schema.Upgraded:
+					// the upgraded document holds the schema version already
+					doc, err := json.Marshal(upgraded)
+					if err != nil {
+						return nil, err
+					}
+					writes = append(writes, write{collection: collection, id: id, doc: doc})
+				case schema.Deleted:
+					writes.delete(collection, id)
+				}
+			}
+			if dryRun {
+				return nil, nil
+			}
+			return writes, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, outcome := range outcomes {
+			report.Add(collection, outcome)
+		}
+	}
+	return report, nil
+}
+
+// decode reads a document into `value`, and returns false if it does not exist
+func decode(get reader, collection string, id string, value any) (bool, error) {
+	doc, err := get(collection, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(doc, value)
+}
+
+// Ping returns an error if the documents cannot be reached
+func (s *docStore) Ping() error {
+	return s.docs.ping()
+}
+
+// Close releases the resources held by the documents
+func (s *docStore) Close() error {
+	return s.docs.close()
+}
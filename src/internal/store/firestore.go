@@ -0,0 +1,271 @@
+package store
+
+import (
+	"assignment2/internal/firebase_client"
+	"assignment2/internal/schema"
+	"assignment2/internal/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// firestoreStore implements Store with a single long-lived Firebase client. The client is replaced
+// when Firestore reports that the connection is broken, and reconnected on the next operation.
+type firestoreStore struct {
+	dial        func() (*firebase_client.FirebaseClient, error)
+	mu          sync.Mutex
+	client      *firebase_client.FirebaseClient // nil while disconnected
+	lastAttempt time.Time
+	lastErr     error
+}
+
+// NewFirestore returns a store backed by Firestore, connected with the Firebase secret key
+func NewFirestore() (Store, error) {
+	f := &firestoreStore{dial: firebase_client.NewFirebaseClient}
+	if _, err := f.connect(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// connect returns the current client, or dials a new one. While disconnected, Firestore is dialled at
+// most once every ReconnectBackoff, and the error of the last attempt is returned in between.
+func (f *firestoreStore) connect() (*firebase_client.FirebaseClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.client != nil {
+		return f.client, nil
+	}
+	if f.lastErr != nil && time.Since(f.lastAttempt) < firebase_client.ReconnectBackoff {
+		return nil, f.lastErr
+	}
+	f.lastAttempt = time.Now()
+	f.client, f.lastErr = f.dial()
+	if f.lastErr != nil {
+		f.client = nil
+	}
+	return f.client, f.lastErr
+}
+
+// disconnect drops the client if `err` means that the connection is broken, so that the next operation reconnects
+func (f *firestoreStore) disconnect(client *firebase_client.FirebaseClient, err error) {
+	if !isConnectionError(err) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.client == client {
+		f.client = nil
+		client.Close()
+	}
+}
+
+// isConnectionError returns true for errors that are not fixed by retrying with the same client
+func isConnectionError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Unauthenticated:
+		return true
+	}
+	return false
+}
+
+// withClient calls `do` with the long-lived client, and drops the client if the connection is broken
+func withClient[T any](f *firestoreStore, do func(client *firebase_client.FirebaseClient) (T, error)) (T, error) {
+	client, err := f.connect()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	result, err := do(client)
+	f.disconnect(client, err)
+	return result, err
+}
+
+// Name returns "firestore"
+func (f *firestoreStore) Name() string {
+	return Firestore
+}
+
+// GetAllInvocationCounts returns the invocation count of every country
+func (f *firestoreStore) GetAllInvocationCounts() (map[string]int64, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (map[string]int64, error) {
+		return client.GetAllInvocationCounts()
+	})
+}
+
+// GetInvocationCounts returns the invocation counts of the countries that have been invocated
+func (f *firestoreStore) GetInvocationCounts(countries []string) (map[string]int64, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (map[string]int64, error) {
+		return client.GetInvocationCounts(countries)
+	})
+}
+
+// AddInvocations increments the invocation counts and buckets in a transaction, unless the batch was added before
+func (f *firestoreStore) AddInvocations(batch types.InvocationBatch) error {
+	_, err := withClient(f, func(client *firebase_client.FirebaseClient) (any, error) {
+		return nil, client.AddInvocations(batch)
+	})
+	return err
+}
+
+// GetInvocationBuckets returns the hourly and daily invocation buckets that start from `from` until `to`
+func (f *firestoreStore) GetInvocationBuckets(from time.Time, to time.Time) ([]types.InvocationBucket, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) ([]types.InvocationBucket, error) {
+		return client.GetInvocationBuckets(from, to)
+	})
+}
+
+// GetAllRegistrations returns every webhook registration by its webhook ID
+func (f *firestoreStore) GetAllRegistrations() (map[string]types.InvocationRegistration, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (map[string]types.InvocationRegistration, error) {
+		return client.GetAllInvocationRegistrations()
+	})
+}
+
+// GetAllDeadLetters returns every webhook that could not be delivered by its ID
+func (f *firestoreStore) GetAllDeadLetters() (map[string]types.DeadLetter, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (map[string]types.DeadLetter, error) {
+		return client.GetAllDeadLetters()
+	})
+}
+
+// GetAllDeliveries returns the delivery log of every registration by its webhook ID
+func (f *firestoreStore) GetAllDeliveries() (map[string]types.DeliveryLog, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (map[string]types.DeliveryLog, error) {
+		return client.GetAllDeliveries()
+	})
+}
+
+// GetCacheEntry returns the cached response for the key, or ErrNotFound
+func (f *firestoreStore) GetCacheEntry(key string) (types.CacheEntry, error) {
+	entry, err := withClient(f, func(client *firebase_client.FirebaseClient) (types.CacheEntry, error) {
+		return client.GetRenewablesCache(key)
+	})
+	if status.Code(err) == codes.NotFound {
+		return entry, ErrNotFound
+	}
+	return entry, err
+}
+
+// GetAllCacheEntries returns every cached response, including expired entries
+func (f *firestoreStore) GetAllCacheEntries() ([]types.CacheEntry, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) ([]types.CacheEntry, error) {
+		return client.GetAllRenewablesCache()
+	})
+}
+
+// DeleteCacheEntry removes the cached response for the key
+func (f *firestoreStore) DeleteCacheEntry(key string) error {
+	_, err := withClient(f, func(client *firebase_client.FirebaseClient) (any, error) {
+		return nil, client.DeleteRenewablesCache(key)
+	})
+	return err
+}
+
+// PurgeExpiredCache pages through the cache collection and deletes expired entries with the bulk writer
+func (f *firestoreStore) PurgeExpiredCache() (int, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (int, error) {
+		return client.PurgeExpiredRenewablesCache(firebase_client.PurgePageSize)
+	})
+}
+
+// BulkWrite adds the invocation batches in order with transactions, and applies the other updates with the
+// Firestore bulk writer. An error is returned if any update could not be written, and the invocation
+// batches that were added are not counted again when the updates are retried.
+func (f *firestoreStore) BulkWrite(updates *types.BundledUpdate) error {
+	for _, batch := range updates.Invocations {
+		if err := f.AddInvocations(batch); err != nil {
+			return err
+		}
+	}
+	_, err := withClient(f, func(client *firebase_client.FirebaseClient) (any, error) {
+		return nil, client.BulkWrite(updates)
+	})
+	return err
+}
+
+// AcquireLease acquires or renews the lease for `owner` in a transaction, unless another owner holds it
+func (f *firestoreStore) AcquireLease(name string, owner string, ttl time.Duration, checkpoint int64) (types.Lease, bool, error) {
+	var acquired bool
+	lease, err := withClient(f, func(client *firebase_client.FirebaseClient) (types.Lease, error) {
+		var lease types.Lease
+		var err error
+		lease, acquired, err = client.AcquireLease(name, owner, ttl, checkpoint)
+		return lease, err
+	})
+	return lease, acquired, err
+}
+
+// UpdateLease stores the lease in a transaction, or returns ErrLeaseLost if another owner has acquired it
+func (f *firestoreStore) UpdateLease(lease types.Lease) error {
+	owned, err := withClient(f, func(client *firebase_client.FirebaseClient) (bool, error) {
+		return client.UpdateLease(lease)
+	})
+	if err == nil && !owned {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// Restore writes the archive with the Firestore bulk writer. Unlike the other backends, the writes are not atomic.
+func (f *firestoreStore) Restore(archive *types.Archive, replace bool) error {
+	_, err := withClient(f, func(client *firebase_client.FirebaseClient) (any, error) {
+		return nil, client.Restore(archive, replace)
+	})
+	return err
+}
+
+// Migrate reads every collection, and upgrades the documents of earlier schema versions one by one in a
+// transaction. Documents that are current when they are read are not written, so that repeated runs only
+// cost the reads.
+func (f *firestoreStore) Migrate(dryRun bool) (*schema.Report, error) {
+	return withClient(f, func(client *firebase_client.FirebaseClient) (*schema.Report, error) {
+		report := schema.NewReport(dryRun)
+		for _, collection := range schema.Collections {
+			docs, err := client.GetAllDocuments(collection)
+			if err != nil {
+				return nil, err
+			}
+			for _, doc := range docs {
+				id := doc.Ref.ID
+				_, outcome, err := schema.Upgrade(collection, id, doc.Data())
+				if err != nil {
+					return nil, err
+				}
+				if !dryRun && (outcome == schema.Upgraded || outcome == schema.Deleted) {
+					// upgraded again from the document read in the transaction, as it may have changed since
+					err = client.UpdateDocument(collection, id, func(data map[string]interface{}) (map[string]interface{}, bool, error) {
+						upgraded, latest, err := schema.Upgrade(collection, id, data)
+						outcome = latest
+						return upgraded, latest == schema.Upgraded || latest == schema.Deleted, err
+					})
+					if err != nil {
+						return nil, err
+					}
+				}
+				report.Add(collection, outcome)
+			}
+		}
+		return report, nil
+	})
+}
+
+// Ping reads at most one document, and returns an error if Firestore cannot be reached within PingTimeout
+func (f *firestoreStore) Ping() error {
+	_, err := withClient(f, func(client *firebase_client.FirebaseClient) (any, error) {
+		return nil, client.Ping(firebase_client.PingTimeout)
+	})
+	return err
+}
+
+// Close closes the client
+func (f *firestoreStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.client != nil {
+		f.client.Close()
+		f.client = nil
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"assignment2/internal/firebase_client"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestFirestoreReconnect(t *testing.T) {
+	dials := 0
+	f := &firestoreStore{dial: func() (*firebase_client.FirebaseClient, error) {
+		dials++
+		return nil, errors.New("no secret key")
+	}}
+
+	// Test 1: a failed dial is reported, and not retried before the backoff has passed
+	if _, err := f.connect(); err == nil {
+		t.Fatal("expected dial error to be returned")
+	}
+	if err := f.Ping(); err == nil || dials != 1 {
+		t.Fatal("expected last dial error to be returned without dialling again, dials: ", dials)
+	}
+
+	// Test 2: only errors meaning that the connection is broken drop the client
+	if !isConnectionError(status.Error(codes.Unavailable, "down")) {
+		t.Fatal("expected Unavailable to be a connection error")
+	}
+	if isConnectionError(status.Error(codes.NotFound, "missing")) || isConnectionError(nil) {
+		t.Fatal("expected NotFound and nil not to be connection errors")
+	}
+}
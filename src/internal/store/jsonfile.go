@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// jsonDocuments keeps the documents in memory, and rewrites the whole JSON file after every batch of
+// writes. The file is replaced atomically, so it is never left half written. This suits the small
+// number of registrations and counts the service keeps, but not large caches.
+type jsonDocuments struct {
+	*memoryDocuments
+	path string
+}
+
+// NewJSONFile returns a store backed by the JSON file at `path`, which is created on the first write
+func NewJSONFile(path string) (Store, error) {
+	docs := &jsonDocuments{memoryDocuments: newMemoryDocuments(), path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &docStore{name: JSONFile, docs: docs}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var collections map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &collections); err != nil {
+		return nil, errors.New("store: could not read " + path + ": " + err.Error())
+	}
+	for collection, entries := range collections {
+		docs.collections[collection] = make(map[string][]byte, len(entries))
+		for id, doc := range entries {
+			docs.collections[collection][id] = doc
+		}
+	}
+	return &docStore{name: JSONFile, docs: docs}, nil
+}
+
+// apply performs all writes and saves the file. The writes are discarded if the file cannot be saved.
+func (j *jsonDocuments) apply(writes []write) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.applyAndSaveLocked(writes)
+}
+
+// update calls `f` and performs the writes while holding the lock, and saves the file
+func (j *jsonDocuments) update(f func(get reader) ([]write, error)) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	writes, err := f(j.getLocked)
+	if err != nil {
+		return err
+	}
+	return j.applyAndSaveLocked(writes)
+}
+
+// applyAndSaveLocked performs all writes and saves the file, or restores the previous state if the file
+// cannot be saved. The lock must be held.
+func (j *jsonDocuments) applyAndSaveLocked(writes []write) error {
+	// keep the previous state, so that it can be restored if the file cannot be written
+	previous := make(map[string]map[string][]byte, len(j.collections))
+	for collection, docs := range j.collections {
+		previous[collection] = make(map[string][]byte, len(docs))
+		for id, doc := range docs {
+			previous[collection][id] = doc
+		}
+	}
+	j.applyLocked(writes)
+	if err := j.save(); err != nil {
+		j.collections = previous
+		return err
+	}
+	return nil
+}
+
+// save writes every collection to a temporary file and renames it over the JSON file. The lock must be held.
+func (j *jsonDocuments) save() error {
+	collections := make(map[string]map[string]json.RawMessage, len(j.collections))
+	for collection, docs := range j.collections {
+		collections[collection] = make(map[string]json.RawMessage, len(docs))
+		for id, doc := range docs {
+			collections[collection][id] = doc
+		}
+	}
+	data, err := json.MarshalIndent(collections, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
+
+// ping returns an error if the directory of the JSON file cannot be reached
+func (j *jsonDocuments) ping() error {
+	_, err := os.Stat(filepath.Dir(j.path))
+	return err
+}
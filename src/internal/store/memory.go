@@ -0,0 +1,90 @@
+package store
+
+import "sync"
+
+// memoryDocuments keeps the documents in maps, and is the base of the JSON-file backend
+type memoryDocuments struct {
+	lock        sync.RWMutex
+	collections map[string]map[string][]byte
+}
+
+// NewMemory returns an empty store that only lives in memory
+func NewMemory() Store {
+	return &docStore{name: Memory, docs: newMemoryDocuments()}
+}
+
+// newMemoryDocuments returns documents without any collections
+func newMemoryDocuments() *memoryDocuments {
+	return &memoryDocuments{collections: map[string]map[string][]byte{}}
+}
+
+// get returns the document, or ErrNotFound
+func (m *memoryDocuments) get(collection string, id string) ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.getLocked(collection, id)
+}
+
+// getLocked returns the document, or ErrNotFound. The lock must be held.
+func (m *memoryDocuments) getLocked(collection string, id string) ([]byte, error) {
+	doc, ok := m.collections[collection][id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return doc, nil
+}
+
+// list returns a copy of the documents in the collection
+func (m *memoryDocuments) list(collection string) (map[string][]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	docs := make(map[string][]byte, len(m.collections[collection]))
+	for id, doc := range m.collections[collection] {
+		docs[id] = doc
+	}
+	return docs, nil
+}
+
+// apply performs all writes
+func (m *memoryDocuments) apply(writes []write) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.applyLocked(writes)
+	return nil
+}
+
+// update calls `f` and performs the writes while holding the lock
+func (m *memoryDocuments) update(f func(get reader) ([]write, error)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	writes, err := f(m.getLocked)
+	if err != nil {
+		return err
+	}
+	m.applyLocked(writes)
+	return nil
+}
+
+// applyLocked performs all writes. The lock must be held.
+func (m *memoryDocuments) applyLocked(writes []write) {
+	for _, w := range writes {
+		if w.doc == nil {
+			delete(m.collections[w.collection], w.id)
+			continue
+		}
+		if _, ok := m.collections[w.collection]; !ok {
+			m.collections[w.collection] = map[string][]byte{}
+		}
+		m.collections[w.collection][w.id] = w.doc
+	}
+}
+
+// ping always succeeds, as the documents are in memory
+func (m *memoryDocuments) ping() error {
+	return nil
+}
+
+// close does nothing, as there are no resources to release
+func (m *memoryDocuments) close() error {
+	return nil
+}
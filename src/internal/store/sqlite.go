@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	_ "modernc.org/sqlite" // pure Go SQLite driver, so the binary can still be built without cgo
+)
+
+// sqliteDocuments keeps the documents in a single table of an embedded SQLite database
+type sqliteDocuments struct {
+	db *sql.DB
+}
+
+// NewSQLite returns a store backed by the SQLite database at `path`, which is created if it does not exist
+func NewSQLite(path string) (Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so writes are serialised through one connection
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS documents (
+		collection TEXT NOT NULL,
+		id         TEXT NOT NULL,
+		doc        BLOB NOT NULL,
+		PRIMARY KEY (collection, id)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &docStore{name: SQLite, docs: &sqliteDocuments{db: db}}, nil
+}
+
+// get returns the document, or ErrNotFound
+func (s *sqliteDocuments) get(collection string, id string) ([]byte, error) {
+	var doc []byte
+	err := s.db.QueryRow(`SELECT doc FROM documents WHERE collection = ? AND id = ?`, collection, id).Scan(&doc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return doc, err
+}
+
+// list returns every document in the collection by its id
+func (s *sqliteDocuments) list(collection string) (map[string][]byte, error) {
+	rows, err := s.db.Query(`SELECT id, doc FROM documents WHERE collection = ?`, collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	docs := map[string][]byte{}
+	for rows.Next() {
+		var id string
+		var doc []byte
+		if err := rows.Scan(&id, &doc); err != nil {
+			return nil, err
+		}
+		docs[id] = doc
+	}
+	return docs, rows.Err()
+}
+
+// apply performs all writes in a single transaction
+func (s *sqliteDocuments) apply(writes []write) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := applyTx(tx, writes); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// update calls `f` to read documents in a transaction, and performs the writes in the same transaction
+func (s *sqliteDocuments) update(f func(get reader) ([]write, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	writes, err := f(func(collection string, id string) ([]byte, error) {
+		var doc []byte
+		err := tx.QueryRow(`SELECT doc FROM documents WHERE collection = ? AND id = ?`, collection, id).Scan(&doc)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return doc, err
+	})
+	if err == nil {
+		err = applyTx(tx, writes)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyTx performs the writes in the transaction
+func applyTx(tx *sql.Tx, writes []write) error {
+	var err error
+	for _, w := range writes {
+		if w.doc == nil {
+			_, err = tx.Exec(`DELETE FROM documents WHERE collection = ? AND id = ?`, w.collection, w.id)
+		} else {
+			_, err = tx.Exec(`INSERT INTO documents (collection, id, doc) VALUES (?, ?, ?)
+				ON CONFLICT (collection, id) DO UPDATE SET doc = excluded.doc`, w.collection, w.id, w.doc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ping returns an error if the database cannot be reached
+func (s *sqliteDocuments) ping() error {
+	return s.db.Ping()
+}
+
+// close closes the database
+func (s *sqliteDocuments) close() error {
+	return s.db.Close()
+}
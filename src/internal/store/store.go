@@ -0,0 +1,94 @@
+package store
+
+import (
+	"assignment2/internal/schema"
+	"assignment2/internal/types"
+	"errors"
+	"time"
+)
+
+// Names of the storage backends that can be selected with Open
+const (
+	Firestore = "firestore" // Google Firestore, requires the Firebase secret key
+	SQLite    = "sqlite"    // embedded SQLite database in a single file, implemented in pure Go
+	JSONFile  = "json"      // single JSON file, rewritten on every bulk write
+	Memory    = "memory"    // in-memory only, nothing survives a restart
+)
+
+// ErrNotFound is returned when a document does not exist in the store
+var ErrNotFound = errors.New("store: document not found")
+
+// ErrLeaseLost is returned when a lease is updated by an instance that no longer owns it
+var ErrLeaseLost = errors.New("store: lease is owned by another instance")
+
+// Store persists the webhook registrations, invocation counts and cached responses of the service.
+// Writes are bundled by the update worker and applied with BulkWrite. The store may be shared by
+// several instances of the service: invocations are added to the counts atomically, and leases let
+// a single instance dispatch the webhooks of a registration.
+type Store interface {
+	// Name returns the name of the backend, e.g. "firestore"
+	Name() string
+	// GetAllInvocationCounts returns the invocation count of every country
+	GetAllInvocationCounts() (map[string]int64, error)
+	// GetInvocationCounts returns the invocation counts of the countries. Countries without invocations are left out.
+	GetInvocationCounts(countries []string) (map[string]int64, error)
+	// AddInvocations adds the counts of the batch to the invocation counts and buckets in a single
+	// transaction, unless the instance has already added a batch with the same or a later sequence number
+	AddInvocations(batch types.InvocationBatch) error
+	// GetInvocationBuckets returns the hourly and daily invocation buckets that start from `from` until `to`
+	GetInvocationBuckets(from time.Time, to time.Time) ([]types.InvocationBucket, error)
+	// GetAllRegistrations returns every webhook registration by its webhook ID
+	GetAllRegistrations() (map[string]types.InvocationRegistration, error)
+	// GetAllDeadLetters returns every webhook that could not be delivered by its ID
+	GetAllDeadLetters() (map[string]types.DeadLetter, error)
+	// GetAllDeliveries returns the delivery log of every registration by its webhook ID
+	GetAllDeliveries() (map[string]types.DeliveryLog, error)
+	// GetCacheEntry returns the cached response for the key, or ErrNotFound
+	GetCacheEntry(key string) (types.CacheEntry, error)
+	// GetAllCacheEntries returns every cached response, including expired entries
+	GetAllCacheEntries() ([]types.CacheEntry, error)
+	// DeleteCacheEntry removes the cached response for the key. Deleting a missing key is not an error.
+	DeleteCacheEntry(key string) error
+	// PurgeExpiredCache removes every expired cached response, and returns the number removed
+	PurgeExpiredCache() (int, error)
+	// BulkWrite adds the bundled invocation batches in order, and applies the registrations, dead letters,
+	// delivery logs and cached responses. Adding a registration creates its webhook lease, and deleting it
+	// deletes the lease and the delivery log, which is not written if it is in the same update.
+	BulkWrite(updates *types.BundledUpdate) error
+	// AcquireLease acquires or renews the lease for `owner` until `ttl` from now, unless another owner holds
+	// it. The lease is created with `checkpoint` if it does not exist. The lease is returned either way.
+	AcquireLease(name string, owner string, ttl time.Duration, checkpoint int64) (types.Lease, bool, error)
+	// UpdateLease stores the checkpoint and expiry of a lease, or returns ErrLeaseLost if another owner has acquired it
+	UpdateLease(lease types.Lease) error
+	// Restore writes the registrations, invocation counts, buckets, dead letters and delivery logs of the
+	// archive. With `replace`, everything of these that is not in the archive is deleted, and the webhook
+	// leases are reset to the archived count of their country. Otherwise, registrations, dead letters and
+	// delivery logs replace those with the same ID, the higher of the stored and archived count is kept,
+	// and missing webhook leases are created.
+	Restore(archive *types.Archive, replace bool) error
+	// Migrate upgrades the documents of earlier schema versions with the migrations of the schema package.
+	// Nothing is written in a dry run, but the report counts the documents that would change. Documents
+	// written by a store are always at the current schema version.
+	Migrate(dryRun bool) (*schema.Report, error)
+	// Ping returns an error if the backend cannot be reached
+	Ping() error
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// Open returns the store for the backend. `path` is the database or JSON file for the sqlite and
+// json backends, and is ignored by the others.
+func Open(backend string, path string) (Store, error) {
+	switch backend {
+	case Firestore:
+		return NewFirestore()
+	case SQLite:
+		return NewSQLite(path)
+	case JSONFile:
+		return NewJSONFile(path)
+	case Memory:
+		return NewMemory(), nil
+	default:
+		return nil, errors.New("unknown store backend \"" + backend + "\", expected firestore, sqlite, json or memory")
+	}
+}
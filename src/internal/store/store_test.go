@@ -0,0 +1,117 @@
+package store_test
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/store/storetest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testWithFirestore = false
+)
+
+// fileBackend returns a backend that opens a new file in a temporary directory, and reopens the same file
+func fileBackend(name string, open func(path string) (store.Store, error)) storetest.Backend {
+	var path string
+	openPath := func(t *testing.T) store.Store {
+		s, err := open(path)
+		if err != nil {
+			t.Fatal("could not open store: ", err)
+		}
+		return s
+	}
+	return storetest.Backend{
+		Open: func(t *testing.T) store.Store {
+			path = filepath.Join(t.TempDir(), name)
+			return openPath(t)
+		},
+		Reopen: func(t *testing.T, s store.Store) store.Store {
+			if err := s.Close(); err != nil {
+				t.Fatal("could not close store: ", err)
+			}
+			return openPath(t)
+		},
+	}
+}
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, storetest.Backend{Open: func(t *testing.T) store.Store { return store.NewMemory() }})
+}
+
+func TestJSONFile(t *testing.T) {
+	storetest.Run(t, fileBackend("store.json", store.NewJSONFile))
+
+	// an unreadable file is reported instead of being overwritten
+	path := filepath.Join(t.TempDir(), "corrupt.json")
+	_ = os.WriteFile(path, []byte("{not json"), 0o600)
+	if _, err := store.NewJSONFile(path); err == nil {
+		t.Fatal("expected error for a corrupt JSON file")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.json")
+	legacy := `{
+		"Invocation registrations": {"abc": {"WebhookID": "abc", "URL": "http://example.com", "Country": "NOR", "Calls": 5}},
+		"Invocation counts": {"NOR": {"count": 12, "time": "2023-04-20T10:00:00Z"}},
+		"Renewables cache": {"old": {"yearRecords": []}},
+		"Leases": {"next": {"name": "next", "schema_version": 99}}
+	}`
+	_ = os.WriteFile(path, []byte(legacy), 0o600)
+	s, err := store.NewJSONFile(path)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer s.Close()
+
+	// Test 1: a dry run reports the documents to upgrade without writing them
+	report, err := s.Migrate(true)
+	if err != nil || report.Changed() != 3 || report.Collections["Leases"].Newer != 1 {
+		t.Fatalf("unexpected dry run report: %+v %v", report, err)
+	}
+	if registrations, _ := s.GetAllRegistrations(); registrations["abc"].WebhookID != "" {
+		t.Fatal("expected the dry run to leave the registration as it is, got: ", registrations)
+	}
+
+	// Test 2: migrating upgrades the documents, and a second run finds nothing to upgrade
+	if report, err := s.Migrate(false); err != nil || report.Changed() != 3 {
+		t.Fatalf("unexpected report: %+v %v", report, err)
+	}
+	registrations, _ := s.GetAllRegistrations()
+	counts, _ := s.GetAllInvocationCounts()
+	entries, _ := s.GetAllCacheEntries()
+	if registrations["abc"].URL != "http://example.com" || registrations["abc"].Calls != 5 || counts["NOR"] != 12 || len(entries) != 0 {
+		t.Fatal("unexpected migrated state: ", registrations, counts, entries)
+	}
+	if report, _ := s.Migrate(false); report.Changed() != 0 {
+		t.Fatalf("expected nothing to upgrade on the second run, got: %s", report)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, fileBackend("store.db", store.NewSQLite))
+}
+
+func TestFirestore(t *testing.T) {
+	if !testWithFirestore {
+		t.Skip("requires Firebase credentials")
+	}
+	storetest.Run(t, storetest.Backend{Open: func(t *testing.T) store.Store {
+		s, err := store.NewFirestore()
+		if err != nil {
+			t.Fatal("could not connect to Firestore: ", err)
+		}
+		return s
+	}})
+}
+
+func TestOpen(t *testing.T) {
+	if s, err := store.Open(store.Memory, ""); err != nil || s.Name() != store.Memory {
+		t.Fatal("expected memory store to be opened, got: ", err)
+	}
+	if _, err := store.Open("floppy", ""); err == nil {
+		t.Fatal("expected error for an unknown backend")
+	}
+}
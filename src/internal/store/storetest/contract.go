@@ -0,0 +1,396 @@
+// Package storetest contains the contract tests that every store.Store backend must pass
+package storetest
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Backend describes how to open a store for the contract tests
+type Backend struct {
+	// Open returns a new, empty store
+	Open func(t *testing.T) store.Store
+	// Reopen closes the store and opens it again from the same location, or is nil if the backend does not persist
+	Reopen func(t *testing.T, s store.Store) store.Store
+}
+
+// Run runs the contract tests against the backend
+func Run(t *testing.T, backend Backend) {
+	registration := types.InvocationRegistration{WebhookID: "abc", URL: "http://example.com", Country: "NOR", Calls: 5, Secret: "whsec_abc"}
+	nor := types.YearRecordList{{Name: "Norway", ISO: "NOR", Year: "2021", Percentage: 71.5}}
+	deadLetter := types.DeadLetter{ID: "dl1", WebhookID: "abc", URL: "http://example.com", Country: "Norway", Calls: 5,
+		Attempts: 3, Error: "503 Service Unavailable", FailedAt: time.Date(2023, 4, 20, 10, 0, 0, 0, time.UTC)}
+	deliveries := types.DeliveryLog{WebhookID: "abc", Deliveries: []types.Delivery{{Time: deadLetter.FailedAt,
+		Payload: types.DeliveryPayload{WebhookID: "abc", Country: "Norway", Calls: 5}, Status: 200, Latency: 12, Attempt: 1}}}
+
+	t.Run("Empty", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		counts, err := s.GetAllInvocationCounts()
+		if err != nil || len(counts) != 0 {
+			t.Fatal("expected no invocation counts, got: ", counts, err)
+		}
+		registrations, err := s.GetAllRegistrations()
+		if err != nil || len(registrations) != 0 {
+			t.Fatal("expected no registrations, got: ", registrations, err)
+		}
+		deadLetters, err := s.GetAllDeadLetters()
+		if err != nil || len(deadLetters) != 0 {
+			t.Fatal("expected no dead letters, got: ", deadLetters, err)
+		}
+		logs, err := s.GetAllDeliveries()
+		if err != nil || len(logs) != 0 {
+			t.Fatal("expected no delivery logs, got: ", logs, err)
+		}
+		if _, err := s.GetCacheEntry("missing"); !errors.Is(err, store.ErrNotFound) {
+			t.Fatal("expected ErrNotFound for a missing cache entry, got: ", err)
+		}
+		if err := s.Ping(); err != nil {
+			t.Fatal("expected ping to succeed, got: ", err)
+		}
+	})
+
+	t.Run("BulkWrite", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		updates := types.NewBundledUpdate()
+		updates.Invocations = []types.InvocationBatch{{Instance: "a", Seq: 1, Counts: map[string]int64{"NOR": 3, "SWE": 1}}}
+		updates.Registrations[registration.WebhookID] = types.RegistrationAction{Add: true, Registration: registration, Checkpoint: 2}
+		updates.Cache["/current/NOR?neighbours=false"] = types.NewCacheEntry("/current/NOR?neighbours=false", nor, time.Hour)
+		updates.DeadLetters[deadLetter.ID] = types.DeadLetterAction{Add: true, DeadLetter: deadLetter}
+		updates.Deliveries[deliveries.WebhookID] = deliveries
+		if err := s.BulkWrite(updates); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		counts, _ := s.GetAllInvocationCounts()
+		if len(counts) != 2 || counts["NOR"] != 3 {
+			t.Fatal("expected invocation counts to be stored, got: ", counts)
+		}
+		registrations, _ := s.GetAllRegistrations()
+		if registrations["abc"] != registration {
+			t.Fatal("expected registration to be stored, got: ", registrations)
+		}
+		entry, err := s.GetCacheEntry("/current/NOR?neighbours=false")
+		if err != nil || entry.Key != "/current/NOR?neighbours=false" || len(entry.Records) != 1 || entry.Records[0] != nor[0] {
+			t.Fatalf("expected cache entry to be stored, got: %+v %v", entry, err)
+		}
+		if entry.Expired() || entry.ExpiresAt.Sub(entry.CreatedAt) != time.Hour {
+			t.Fatalf("expected expiry to be stored, got: %+v", entry)
+		}
+		lease, owned, err := s.AcquireLease(types.WebhookLease("abc"), "a", time.Minute, 0)
+		if err != nil || !owned || lease.Checkpoint != 2 {
+			t.Fatalf("expected webhook lease to be created with the registration, got: %+v %v", lease, err)
+		}
+		deadLetters, _ := s.GetAllDeadLetters()
+		if stored := deadLetters[deadLetter.ID]; stored.WebhookID != "abc" || stored.Attempts != 3 || !stored.FailedAt.Equal(deadLetter.FailedAt) {
+			t.Fatalf("expected dead letter to be stored, got: %+v", deadLetters)
+		}
+		logs, _ := s.GetAllDeliveries()
+		if stored := logs["abc"].Deliveries; len(stored) != 1 || stored[0].Payload != deliveries.Deliveries[0].Payload ||
+			stored[0].Status != 200 || !stored[0].Time.Equal(deliveries.Deliveries[0].Time) {
+			t.Fatalf("expected delivery log to be stored, got: %+v", logs)
+		}
+
+		// batches add to the counts, and registrations are deleted together with their lease and delivery
+		// log, which is not written again by the same update
+		updates = types.NewBundledUpdate()
+		updates.Invocations = []types.InvocationBatch{{Instance: "a", Seq: 2, Counts: map[string]int64{"NOR": 1}}}
+		updates.Registrations[registration.WebhookID] = types.RegistrationAction{Add: false, Registration: registration}
+		updates.DeadLetters[deadLetter.ID] = types.DeadLetterAction{Add: false, DeadLetter: deadLetter}
+		updates.Deliveries[deliveries.WebhookID] = deliveries
+		if err := s.BulkWrite(updates); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		counts, _ = s.GetAllInvocationCounts()
+		registrations, _ = s.GetAllRegistrations()
+		deadLetters, _ = s.GetAllDeadLetters()
+		logs, _ = s.GetAllDeliveries()
+		if counts["NOR"] != 4 || counts["SWE"] != 1 || len(registrations) != 0 || len(deadLetters) != 0 || len(logs) != 0 {
+			t.Fatal("expected count to be updated, and registration, dead letter and delivery log deleted, got: ",
+				counts, registrations, deadLetters, logs)
+		}
+		if lease, _, _ := s.AcquireLease(types.WebhookLease("abc"), "b", time.Minute, 9); lease.Checkpoint != 9 {
+			t.Fatalf("expected webhook lease to be deleted with the registration, got: %+v", lease)
+		}
+	})
+
+	t.Run("Invocations", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		add := func(instance string, seq int64, count int64) {
+			if err := s.AddInvocations(types.InvocationBatch{Instance: instance, Seq: seq, Counts: map[string]int64{"NOR": count}}); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+		}
+
+		// batches are added once, in the order of their sequence numbers per instance
+		add("a", 1, 3)
+		add("a", 1, 3)
+		add("a", 2, 2)
+		add("b", 1, 1)
+		add("a", 1, 3)
+		counts, err := s.GetInvocationCounts([]string{"NOR", "SWE"})
+		if err != nil || len(counts) != 1 || counts["NOR"] != 6 {
+			t.Fatal("expected every batch to be added once, got: ", counts, err)
+		}
+
+		// batches of concurrent instances are all added
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(instance string) {
+				defer wg.Done()
+				for seq := int64(1); seq <= 10; seq++ {
+					if err := s.AddInvocations(types.InvocationBatch{Instance: instance, Seq: seq, Counts: map[string]int64{"NOR": 1}}); err != nil {
+						t.Error("unexpected error: ", err)
+					}
+				}
+			}(fmt.Sprint("instance ", i))
+		}
+		wg.Wait()
+		if counts, _ := s.GetInvocationCounts([]string{"NOR"}); counts["NOR"] != 46 {
+			t.Fatal("expected concurrent batches to be added, got: ", counts["NOR"])
+		}
+	})
+
+	t.Run("Buckets", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		day := time.Date(2023, 4, 20, 0, 0, 0, 0, time.UTC)
+		err := s.AddInvocations(types.InvocationBatch{Instance: "a", Seq: 1, Counts: map[string]int64{"NOR": 3}, Buckets: []types.InvocationBucket{
+			{Country: "NOR", Granularity: types.Daily, Start: day, Count: 3},
+			{Country: "NOR", Granularity: types.Hourly, Start: day.Add(13 * time.Hour), Count: 2},
+			{Country: "NOR", Granularity: types.Daily, Start: day.Add(24 * time.Hour), Count: 1},
+		}})
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		// buckets are selected by their start, and added to by their ID
+		buckets, err := s.GetInvocationBuckets(day, day.Add(24*time.Hour))
+		if err != nil || len(buckets) != 2 {
+			t.Fatalf("expected 2 buckets on the first day, got: %+v %v", buckets, err)
+		}
+		err = s.AddInvocations(types.InvocationBatch{Instance: "b", Seq: 1, Counts: map[string]int64{"NOR": 1}, Buckets: []types.InvocationBucket{
+			{Country: "NOR", Granularity: types.Daily, Start: day, Count: 1},
+		}})
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		buckets, _ = s.GetInvocationBuckets(day, day.Add(time.Hour))
+		if len(buckets) != 1 || buckets[0].Count != 4 || !buckets[0].Start.Equal(day) {
+			t.Fatalf("expected updated daily bucket, got: %+v", buckets)
+		}
+	})
+
+	t.Run("Leases", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+
+		// a lease is created with the checkpoint, and cannot be acquired by another owner while it is held
+		lease, owned, err := s.AcquireLease("task", "a", time.Hour, 5)
+		if err != nil || !owned || lease.Owner != "a" || lease.Checkpoint != 5 {
+			t.Fatalf("expected lease to be acquired, got: %+v %v %v", lease, owned, err)
+		}
+		if held, owned, _ := s.AcquireLease("task", "b", time.Hour, 0); owned || held.Owner != "a" {
+			t.Fatalf("expected lease to be held by a, got: %+v", held)
+		}
+
+		// only the owner can update the lease, and renewing it keeps the checkpoint
+		lease.Checkpoint = 8
+		if err := s.UpdateLease(lease); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		if err := s.UpdateLease(types.Lease{Name: "task", Owner: "b", Checkpoint: 9}); !errors.Is(err, store.ErrLeaseLost) {
+			t.Fatal("expected ErrLeaseLost, got: ", err)
+		}
+		if renewed, owned, _ := s.AcquireLease("task", "a", time.Hour, 0); !owned || renewed.Checkpoint != 8 {
+			t.Fatalf("expected lease to be renewed with its checkpoint, got: %+v", renewed)
+		}
+
+		// an expired lease can be acquired by another owner, after which the previous owner cannot update it
+		expired, _, _ := s.AcquireLease("expired", "a", -time.Second, 1)
+		if lease, owned, _ := s.AcquireLease("expired", "b", time.Hour, 0); !owned || lease.Checkpoint != 1 {
+			t.Fatalf("expected expired lease to be taken over, got: %+v", lease)
+		}
+		if err := s.UpdateLease(expired); !errors.Is(err, store.ErrLeaseLost) {
+			t.Fatal("expected ErrLeaseLost for the previous owner, got: ", err)
+		}
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		updates := types.NewBundledUpdate()
+		updates.Cache["valid"] = types.NewCacheEntry("valid", nor, time.Hour)
+		updates.Cache["expired"] = types.NewCacheEntry("expired", nor, -time.Hour)
+		updates.Cache["deleted"] = types.NewCacheEntry("deleted", nor, time.Hour)
+		if err := s.BulkWrite(updates); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		if err := s.DeleteCacheEntry("deleted"); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		if err := s.DeleteCacheEntry("never stored"); err != nil {
+			t.Fatal("expected deleting a missing entry to succeed, got: ", err)
+		}
+		if entries, _ := s.GetAllCacheEntries(); len(entries) != 2 {
+			t.Fatal("expected 2 cache entries including the expired one, got: ", len(entries))
+		}
+
+		purged, err := s.PurgeExpiredCache()
+		if err != nil || purged != 1 {
+			t.Fatal("expected 1 expired entry to be purged, got: ", purged, err)
+		}
+		entries, _ := s.GetAllCacheEntries()
+		if len(entries) != 1 || entries[0].Key != "valid" {
+			t.Fatalf("expected only the valid entry to remain, got: %+v", entries)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		day := time.Date(2023, 4, 20, 0, 0, 0, 0, time.UTC)
+		stale := types.InvocationRegistration{WebhookID: "stale", URL: "http://example.com", Country: "SWE", Calls: 1}
+		updates := types.NewBundledUpdate()
+		updates.Registrations["abc"] = types.RegistrationAction{Add: true, Registration: registration, Checkpoint: 2}
+		updates.Registrations["stale"] = types.RegistrationAction{Add: true, Registration: stale, Checkpoint: 3}
+		staleDeadLetter := types.DeadLetter{ID: "dl2", WebhookID: "stale", URL: stale.URL, Country: "Sweden", Calls: 1, Attempts: 1}
+		updates.DeadLetters[staleDeadLetter.ID] = types.DeadLetterAction{Add: true, DeadLetter: staleDeadLetter}
+		updates.Deliveries["stale"] = types.DeliveryLog{WebhookID: "stale", Deliveries: deliveries.Deliveries}
+		updates.Invocations = []types.InvocationBatch{{Instance: "a", Seq: 1, Counts: map[string]int64{"NOR": 2, "SWE": 9},
+			Buckets: []types.InvocationBucket{{Country: "SWE", Granularity: types.Daily, Start: day, Count: 9}}}}
+		if err := s.BulkWrite(updates); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		archive := &types.Archive{
+			Version:           types.ArchiveVersion,
+			Registrations:     []types.InvocationRegistration{registration},
+			InvocationCounts:  map[string]int64{"NOR": 7, "SWE": 4},
+			InvocationBuckets: []types.InvocationBucket{{Country: "NOR", Granularity: types.Daily, Start: day, Count: 7}},
+			DeadLetters:       []types.DeadLetter{deadLetter},
+			Deliveries:        []types.DeliveryLog{deliveries},
+		}
+
+		// merging keeps the higher count, the other registrations, buckets, dead letters and delivery logs,
+		// and the existing leases
+		if err := s.Restore(archive, false); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		counts, _ := s.GetAllInvocationCounts()
+		if counts["NOR"] != 7 || counts["SWE"] != 9 {
+			t.Fatal("expected the higher counts to be kept, got: ", counts)
+		}
+		if registrations, _ := s.GetAllRegistrations(); len(registrations) != 2 {
+			t.Fatal("expected 2 registrations after merging, got: ", registrations)
+		}
+		if buckets, _ := s.GetInvocationBuckets(day, day.Add(time.Hour)); len(buckets) != 2 {
+			t.Fatalf("expected 2 buckets after merging, got: %+v", buckets)
+		}
+		if deadLetters, _ := s.GetAllDeadLetters(); len(deadLetters) != 2 || deadLetters[deadLetter.ID].Error != deadLetter.Error {
+			t.Fatalf("expected 2 dead letters after merging, got: %+v", deadLetters)
+		}
+		if logs, _ := s.GetAllDeliveries(); len(logs) != 2 || len(logs["abc"].Deliveries) != len(deliveries.Deliveries) {
+			t.Fatalf("expected 2 delivery logs after merging, got: %+v", logs)
+		}
+		if lease, _, _ := s.AcquireLease(types.WebhookLease("abc"), "a", -time.Second, 0); lease.Checkpoint != 2 {
+			t.Fatalf("expected the existing lease to be kept, got: %+v", lease)
+		}
+
+		// replacing removes everything that is not in the archive, and resets the leases to the archived count
+		if err := s.Restore(archive, true); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		counts, _ = s.GetAllInvocationCounts()
+		if len(counts) != 2 || counts["NOR"] != 7 || counts["SWE"] != 4 {
+			t.Fatal("expected the archived counts, got: ", counts)
+		}
+		registrations, _ := s.GetAllRegistrations()
+		if _, found := registrations["stale"]; found || len(registrations) != 1 {
+			t.Fatal("expected only the archived registration, got: ", registrations)
+		}
+		buckets, _ := s.GetInvocationBuckets(day, day.Add(time.Hour))
+		if len(buckets) != 1 || buckets[0].Country != "NOR" || buckets[0].Count != 7 {
+			t.Fatalf("expected only the archived bucket, got: %+v", buckets)
+		}
+		if deadLetters, _ := s.GetAllDeadLetters(); len(deadLetters) != 1 || deadLetters[deadLetter.ID].WebhookID != "abc" {
+			t.Fatalf("expected only the archived dead letter, got: %+v", deadLetters)
+		}
+		if logs, _ := s.GetAllDeliveries(); len(logs) != 1 || logs["abc"].WebhookID != "abc" {
+			t.Fatalf("expected only the archived delivery log, got: %+v", logs)
+		}
+		if lease, _, _ := s.AcquireLease(types.WebhookLease("abc"), "b", time.Hour, 0); lease.Checkpoint != 7 {
+			t.Fatalf("expected the lease to be reset to the archived count, got: %+v", lease)
+		}
+		if lease, _, _ := s.AcquireLease(types.WebhookLease("stale"), "b", time.Hour, 0); lease.Checkpoint != 0 {
+			t.Fatalf("expected the lease of the removed registration to be deleted, got: %+v", lease)
+		}
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		s := backend.Open(t)
+		defer s.Close()
+		updates := types.NewBundledUpdate()
+		updates.Invocations = []types.InvocationBatch{{Instance: "a", Seq: 1, Counts: map[string]int64{"NOR": 7},
+			Buckets: []types.InvocationBucket{{Country: "NOR", Granularity: types.Daily, Start: time.Now(), Count: 7}}}}
+		updates.Registrations[registration.WebhookID] = types.RegistrationAction{Add: true, Registration: registration}
+		updates.Cache["valid"] = types.NewCacheEntry("valid", nor, time.Hour)
+		updates.Deliveries[deliveries.WebhookID] = deliveries
+		if err := s.BulkWrite(updates); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		if _, _, err := s.AcquireLease("task", "a", time.Hour, 0); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		// every document written by the store is at the current schema version
+		report, err := s.Migrate(false)
+		if err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+		scanned := 0
+		for _, counts := range report.Collections {
+			scanned += counts.Scanned
+		}
+		if report.Changed() != 0 || scanned != 8 {
+			t.Fatalf("expected 8 current documents, got: %d scanned, %s", scanned, report)
+		}
+		registrations, _ := s.GetAllRegistrations()
+		if registrations["abc"] != registration {
+			t.Fatal("expected the registration to be readable after migrating, got: ", registrations)
+		}
+	})
+
+	t.Run("Persistence", func(t *testing.T) {
+		if backend.Reopen == nil {
+			t.Skip("backend does not persist")
+		}
+		s := backend.Open(t)
+		updates := types.NewBundledUpdate()
+		updates.Invocations = []types.InvocationBatch{{Instance: "a", Seq: 1, Counts: map[string]int64{"NOR": 7}}}
+		updates.Registrations[registration.WebhookID] = types.RegistrationAction{Add: true, Registration: registration}
+		updates.Cache["valid"] = types.NewCacheEntry("valid", nor, time.Hour)
+		if err := s.BulkWrite(updates); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+
+		s = backend.Reopen(t, s)
+		defer s.Close()
+		counts, _ := s.GetAllInvocationCounts()
+		registrations, _ := s.GetAllRegistrations()
+		if counts["NOR"] != 7 || registrations["abc"] != registration {
+			t.Fatal("expected counts and registrations to survive a restart, got: ", counts, registrations)
+		}
+		if _, err := s.GetCacheEntry("valid"); err != nil {
+			t.Fatal("expected cache entry to survive a restart, got: ", err)
+		}
+	})
+}
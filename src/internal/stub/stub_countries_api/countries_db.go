@@ -1,19 +1,20 @@
 package stub_countries_api
 
 import (
+	"assignment2/res"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 )
 
 type JSONdata []interface{}
 
-// ParseJSON will read a JSON file and return a data structure for it
+// ParseJSON will read a JSON file and return a data structure for it. If `filepath` is
+// res.Embedded, then the dataset compiled into the binary is read instead
 func ParseJSON(filepath string) JSONdata {
 	// open file
-	file, err := os.Open(filepath)
+	file, err := res.Open(filepath, res.RestCountriesJSON)
 	if err != nil {
 		log.Fatal("could not load json file", err)
 	}
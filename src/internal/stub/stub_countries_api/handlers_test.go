@@ -2,15 +2,15 @@ package stub_countries_api
 
 import (
 	"assignment2/internal/web"
+	"assignment2/res"
 	"net/http"
 	"net/http/httptest"
-	"path"
 	"strconv"
 	"testing"
 )
 
 func TestEnergyCurrentHandler(t *testing.T) {
-	jsonData := ParseJSON(path.Join("..", "..", "..", "res", JSONFileName))
+	jsonData := ParseJSON(res.Embedded)
 	server := httptest.NewServer(http.HandlerFunc(StubHandler(&jsonData)))
 	defer server.Close()
 
@@ -0,0 +1,24 @@
+package types
+
+import "time"
+
+// ArchiveVersion is the version of the archive format written by this version of the service. Archives
+// of a later version are rejected, as they may contain state that would be lost on import.
+//
+// Version 2 added the secrets of the registrations, the type, threshold and baseline of threshold
+// registrations, and the dead letters and delivery logs.
+const ArchiveVersion = 2
+
+// Archive is a snapshot of the persisted state of the service: the webhook registrations with their dead
+// letters and delivery logs, and the invocation counts and buckets. Cached responses are left out, as they
+// can be recomputed.
+type Archive struct {
+	Version           int                      `json:"version"`
+	CreatedAt         time.Time                `json:"created_at"`
+	Backend           string                   `json:"backend"` // store the archive was exported from
+	Registrations     []InvocationRegistration `json:"registrations"`
+	InvocationCounts  map[string]int64         `json:"invocation_counts"`
+	InvocationBuckets []InvocationBucket       `json:"invocation_buckets"`
+	DeadLetters       []DeadLetter             `json:"dead_letters"`
+	Deliveries        []DeliveryLog            `json:"deliveries"`
+}
@@ -0,0 +1,38 @@
+package types
+
+import (
+	"strings"
+	"time"
+)
+
+// CacheEntry is a cached response of the renewables endpoints, together with the time it was
+// stored and the time it expires
+type CacheEntry struct {
+	Key       string         `json:"key" firestore:"key"`
+	Records   YearRecordList `json:"yearRecords" firestore:"yearRecords"`
+	CreatedAt time.Time      `json:"createdAt" firestore:"createdAt"`
+	ExpiresAt time.Time      `json:"expiresAt" firestore:"expiresAt"`
+	Source    string         `json:"-" firestore:"-"` // cache layer the entry was read from, e.g. "memory" or "firestore"
+}
+
+// NewCacheEntry returns an entry for the key that is created now and expires after `ttl`
+func NewCacheEntry(key string, records YearRecordList, ttl time.Duration) CacheEntry {
+	now := time.Now()
+	return CacheEntry{Key: key, Records: records, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+}
+
+// Expired returns true if the entry has passed its expiry time. Entries without an expiry time
+// were written before expiry was stored explicitly, and are treated as expired.
+func (e CacheEntry) Expired() bool {
+	return e.ExpiresAt.IsZero() || time.Now().After(e.ExpiresAt)
+}
+
+// HasCountry returns true if the cached response contains records for the country code
+func (e CacheEntry) HasCountry(countryCode string) bool {
+	for _, record := range e.Records {
+		if strings.EqualFold(record.ISO, countryCode) {
+			return true
+		}
+	}
+	return false
+}
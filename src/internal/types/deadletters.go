@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+// DeadLetter is a webhook that could not be delivered after every attempt. It is kept until it is
+// replayed or discarded through the notifications API.
+type DeadLetter struct {
+	ID        string `json:"id" firestore:"id"`
+	WebhookID string `json:"webhook_id" firestore:"webhook_id"`
+	URL       string `json:"url" firestore:"url"`
+	Country   string `json:"country" firestore:"country"` // name of the country, as posted to the webhook
+	Calls     int64  `json:"calls,omitempty" firestore:"calls"`
+	Type      string `json:"type,omitempty" firestore:"type"`
+	// a named field rather than an embedded pointer, which Firestore cannot encode or decode when it is nil
+	Crossing *ThresholdCrossing `json:"threshold,omitempty" firestore:"threshold,omitempty"`
+	Attempts int                `json:"attempts" firestore:"attempts"`
+	Error    string             `json:"error" firestore:"error"` // error of the last attempt
+	FailedAt time.Time          `json:"failed_at" firestore:"failed_at"`
+}
+
+// DeadLetterAction represents an action to add or remove a dead letter
+type DeadLetterAction struct {
+	Add        bool
+	DeadLetter DeadLetter
+}
@@ -0,0 +1,29 @@
+package types
+
+import "time"
+
+// DeliveryPayload is the body posted to the URL of a webhook registration
+type DeliveryPayload struct {
+	WebhookID string `json:"webhook_id" firestore:"webhook_id"`
+	Type      string `json:"type,omitempty" firestore:"type"`
+	Country   string `json:"country" firestore:"country"` // name of the country
+	Calls     int64  `json:"calls,omitempty" firestore:"calls"`
+	// a named field rather than an embedded pointer, which Firestore cannot encode or decode when it is nil
+	Crossing *ThresholdCrossing `json:"threshold,omitempty" firestore:"threshold,omitempty"`
+}
+
+// Delivery is an attempt to post a webhook to the URL of its registration
+type Delivery struct {
+	Time    time.Time       `json:"time" firestore:"time"` // when the attempt started
+	Payload DeliveryPayload `json:"payload" firestore:"payload"`
+	Status  int             `json:"status" firestore:"status"`   // status code of the response, or 0 if there was none
+	Latency int64           `json:"latency" firestore:"latency"` // milliseconds until the response, or until the attempt failed
+	Error   string          `json:"error,omitempty" firestore:"error"`
+	Attempt int             `json:"attempt" firestore:"attempt"` // starting from 1, and counted again when a dead letter is replayed
+}
+
+// DeliveryLog holds the most recent delivery attempts of a webhook registration, oldest first
+type DeliveryLog struct {
+	WebhookID  string     `json:"webhook_id" firestore:"webhook_id"`
+	Deliveries []Delivery `json:"deliveries" firestore:"deliveries"`
+}
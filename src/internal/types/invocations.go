@@ -0,0 +1,50 @@
+package types
+
+import "time"
+
+// Granularities of invocation buckets
+const (
+	Hourly = "hour"
+	Daily  = "day"
+)
+
+// InvocationBucket is the number of invocations of a country during an hour or a day, starting at Start in UTC
+type InvocationBucket struct {
+	Country     string    `json:"country" firestore:"country"`
+	Granularity string    `json:"granularity" firestore:"granularity"`
+	Start       time.Time `json:"start" firestore:"start"`
+	Count       int64     `json:"count" firestore:"count"`
+}
+
+// InvocationBatch is the number of invocations counted by an instance of the service since its previous
+// batch, by country and by bucket. The batches of an instance are added to the store in the order of their
+// sequence numbers, and a batch that has been added before is skipped, so that a batch can be retried
+// without being counted twice.
+type InvocationBatch struct {
+	Instance string             `json:"instance"`
+	Seq      int64              `json:"seq"`
+	Counts   map[string]int64   `json:"counts"`
+	Buckets  []InvocationBucket `json:"buckets,omitempty"` // one per bucket, counting the invocations in the batch
+}
+
+// ID returns the document identifier of the bucket, e.g. "NOR_day_2023-04-20T00"
+func (b InvocationBucket) ID() string {
+	return b.Country + "_" + b.Granularity + "_" + b.Start.UTC().Format("2006-01-02T15")
+}
+
+// BucketStart returns the start of the hour or day in UTC that contains `t`
+func BucketStart(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == Daily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// BucketDuration returns the length of a bucket with the granularity
+func BucketDuration(granularity string) time.Duration {
+	if granularity == Daily {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+// Lease gives one instance of the service the right to perform a task until it expires, such as
+// dispatching the webhooks of a registration. The owner renews the lease while it is running.
+type Lease struct {
+	Name       string    `json:"name" firestore:"name"`
+	Owner      string    `json:"owner" firestore:"owner"` // empty until the lease is first acquired
+	Expires    time.Time `json:"expires" firestore:"expires"`
+	Checkpoint int64     `json:"checkpoint" firestore:"checkpoint"` // progress of the task, e.g. the last invocation count notified
+}
+
+// Held returns true if the lease is held by an owner other than `owner` at `now`
+func (l Lease) Held(owner string, now time.Time) bool {
+	return l.Owner != "" && l.Owner != owner && now.Before(l.Expires)
+}
+
+// WebhookLease returns the name of the lease for dispatching the webhooks of a registration
+func WebhookLease(webhookID string) string {
+	return "webhook_" + webhookID
+}
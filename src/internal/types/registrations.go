@@ -1,23 +1,126 @@
 package types
 
-// InvocationRegistration represents a webhook registration with its associated information.
+import "time"
+
+// Types of webhook registrations
+const (
+	RegistrationCalls     = "calls"     // notified every Calls invocations of the country
+	RegistrationThreshold = "threshold" // notified when the latest renewable share of the country crosses Threshold
+)
+
+// Directions in which the renewable share of a country crosses the threshold of a registration
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+// InvocationRegistration represents a webhook registration with its associated information. The webhooks
+// are signed with Secret, and also with PreviousSecret until PreviousSecretExpires while the secret is
+// rotated. Registrations created by earlier versions have no secret, and their webhooks are not signed.
+//
+// Registrations of the threshold type are notified when the latest renewable share of their country
+// crosses Threshold in Direction, or in either direction if it is empty. LastValue and LastYear hold the
+// latest share when the dataset was last evaluated, which the share of a new dataset is compared with.
 type InvocationRegistration struct {
-	WebhookID string `json:"webhook_id"`
-	URL       string `json:"url"`
-	Country   string `json:"country"`
-	Calls     int64  `json:"calls"`
+	WebhookID             string     `json:"webhook_id" firestore:"webhook_id"`
+	Type                  string     `json:"type,omitempty" firestore:"type"` // RegistrationCalls if empty, as in earlier versions
+	URL                   string     `json:"url" firestore:"url"`
+	Country               string     `json:"country" firestore:"country"`
+	Calls                 int64      `json:"calls,omitempty" firestore:"calls"`
+	Threshold             float64    `json:"threshold,omitempty" firestore:"threshold"`
+	Direction             string     `json:"direction,omitempty" firestore:"direction"`
+	LastValue             *float64   `json:"last_value,omitempty" firestore:"last_value"`
+	LastYear              string     `json:"last_year,omitempty" firestore:"last_year"`
+	Secret                string     `json:"secret,omitempty" firestore:"secret"`
+	PreviousSecret        string     `json:"previous_secret,omitempty" firestore:"previous_secret"`
+	PreviousSecretExpires *time.Time `json:"previous_secret_expires,omitempty" firestore:"previous_secret_expires"`
+}
+
+// IsThreshold returns true if the registration is of the threshold type
+func (r InvocationRegistration) IsThreshold() bool {
+	return r.Type == RegistrationThreshold
+}
+
+// Crossing returns the crossing of the threshold of the registration when the renewable share of its
+// country changes from LastValue to `value` in `year`, or nil if the threshold was not crossed in the
+// direction of the registration, or if there is no LastValue to compare with. A share crosses the
+// threshold upward when it reaches it from below, and downward when it falls below it.
+func (r InvocationRegistration) Crossing(value float64, year string) *ThresholdCrossing {
+	if !r.IsThreshold() || r.LastValue == nil {
+		return nil
+	}
+	old := *r.LastValue
+	var direction string
+	switch {
+	case old < r.Threshold && value >= r.Threshold:
+		direction = DirectionUp
+	case old >= r.Threshold && value < r.Threshold:
+		direction = DirectionDown
+	default:
+		return nil
+	}
+	if r.Direction != "" && r.Direction != direction {
+		return nil
+	}
+	return &ThresholdCrossing{Threshold: r.Threshold, Direction: direction, OldValue: old, NewValue: value, Year: year}
 }
 
-// RegistrationAction represents an action to add or remove a webhook registration.
+// ThresholdCrossing describes how the renewable share of a country crossed the threshold of a registration.
+// It is embedded in the webhooks of threshold registrations, in place of the number of calls, and kept as
+// the crossing of their delivery payloads and dead letters.
+type ThresholdCrossing struct {
+	Threshold float64 `json:"threshold" firestore:"threshold"`
+	Direction string  `json:"direction" firestore:"direction"`
+	OldValue  float64 `json:"old_value" firestore:"old_value"` // latest share when the dataset was last evaluated
+	NewValue  float64 `json:"new_value" firestore:"new_value"` // latest share in the new dataset
+	Year      string  `json:"year" firestore:"year"`           // year of the new share
+}
+
+// SigningSecrets returns the secrets the webhooks of the registration are signed with at `now`, the
+// current secret first
+func (r InvocationRegistration) SigningSecrets(now time.Time) []string {
+	var secrets []string
+	if r.Secret != "" {
+		secrets = append(secrets, r.Secret)
+	}
+	if r.PreviousSecret != "" && r.PreviousSecretExpires != nil && now.Before(*r.PreviousSecretExpires) {
+		secrets = append(secrets, r.PreviousSecret)
+	}
+	return secrets
+}
+
+// Redacted returns the registration without its secrets, as shown by the notifications endpoint
+func (r InvocationRegistration) Redacted() InvocationRegistration {
+	r.Secret, r.PreviousSecret = "", ""
+	return r
+}
+
+// RegistrationAction represents an action to add or remove a webhook registration. When a registration
+// is added, Checkpoint is the invocation count of its country, so that only later invocations are notified.
 type RegistrationAction struct {
 	Add          bool
 	Registration InvocationRegistration
+	Checkpoint   int64
 }
 
-// BundledUpdate represents a set of updates to be performed, including invocation counts, registrations, and cache updates.
+// BundledUpdate represents a set of updates to be performed, including batches of invocations,
+// registrations, dead letters, delivery logs and cache updates.
 type BundledUpdate struct {
-	Ready           bool
-	InvocationCount map[string]int64
-	Registrations   map[string]RegistrationAction
-	Cache           map[string]YearRecordList
+	Ready         bool
+	Invocations   []InvocationBatch // in the order they were counted
+	Registrations map[string]RegistrationAction
+	DeadLetters   map[string]DeadLetterAction
+	Deliveries    map[string]DeliveryLog // by webhook ID, written unless the registration is deleted
+	Cache         map[string]CacheEntry
+}
+
+// NewBundledUpdate returns an empty BundledUpdate that is ready to be filled
+func NewBundledUpdate() *BundledUpdate {
+	return &BundledUpdate{
+		Ready:         false,
+		Registrations: make(map[string]RegistrationAction),
+		DeadLetters:   make(map[string]DeadLetterAction),
+		Deliveries:    make(map[string]DeliveryLog),
+		Cache:         make(map[string]CacheEntry),
+	}
 }
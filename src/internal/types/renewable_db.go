@@ -1,13 +1,17 @@
 package types
 
 import (
+	"assignment2/res"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"io"
 	"log"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -28,20 +32,47 @@ type YearRecordList []YearRecord
 // RenewableDB is a map representing renewable energy data organized by country code.
 type RenewableDB map[string]YearRecordList
 
-// ParseCSV will load a CSV file into RenewableDB
+// Dataset is a RenewableDB together with the version of the CSV file it was loaded from
+type Dataset struct {
+	DB       RenewableDB
+	Version  string    // hex encoded SHA-256 of the CSV file
+	LoadedAt time.Time // time the CSV file was loaded
+}
+
+// ParseCSV will load a CSV file into RenewableDB. If `filepath` is res.Embedded,
+// then the dataset compiled into the binary is loaded instead
 func ParseCSV(filepath string) RenewableDB {
+	return LoadDataset(filepath).DB
+}
+
+// LoadDataset will load a CSV file into RenewableDB, and record the version of its contents
+// and when it was loaded. If `filepath` is res.Embedded, then the dataset compiled into the binary is loaded instead
+func LoadDataset(filepath string) Dataset {
+	dataset, err := ReadDataset(filepath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dataset
+}
+
+// ReadDataset is LoadDataset for a dataset that is reloaded while the service is running, and returns
+// an error instead of exiting if the CSV file cannot be read
+func ReadDataset(filepath string) (Dataset, error) {
 	db := make(RenewableDB)
 	// open file
-	file, err := os.Open(filepath)
+	file, err := res.Open(filepath, res.RenewablesCSV)
 	if err != nil {
-		log.Fatal("Could not open file"+filepath, err)
+		return Dataset{}, errors.New("Could not open file " + filepath + ": " + err.Error())
 	}
-	reader := csv.NewReader(file)
+	defer file.Close()
+	// the contents are hashed while parsing to version the dataset
+	hash := sha256.New()
+	reader := csv.NewReader(io.TeeReader(file, hash))
 
 	// discards header line of the file
 	_, err = reader.Read()
 	if err != nil {
-		log.Fatal(err)
+		return Dataset{}, err
 	}
 
 	// read each record until EOF and appends them to the global structure
@@ -51,21 +82,18 @@ func ParseCSV(filepath string) RenewableDB {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return Dataset{}, err
+		}
+		if err := db.insert(record); err != nil {
+			return Dataset{}, err
 		}
-		db.insert(record)
 	}
 
 	// sort each struct for every country by year in case the CSV file is in incorrect order
 	for _, countryList := range db {
 		countryList.sortByYear(true)
 	}
-
-	err = file.Close()
-	if err != nil {
-		log.Fatal("could not close file" + filepath + "maybe it has been closed already")
-	}
-	return db
+	return Dataset{DB: db, Version: hex.EncodeToString(hash.Sum(nil)), LoadedAt: time.Now()}, nil
 }
 
 /*
@@ -140,14 +168,17 @@ func (db *RenewableDB) GetHistoric(countryCode string, start, end int, sortByPer
 	return data
 }
 
-// insert will append single record into the renewableDB
-func (db *RenewableDB) insert(record []string) {
+// insert will append single record into the renewableDB, or return an error if the record is malformed
+func (db *RenewableDB) insert(record []string) error {
+	if len(record) < 4 {
+		return errors.New("expected 4 fields in renewables record, got: " + strings.Join(record, ","))
+	}
 	isoCode := strings.ToUpper(record[1])
 	if len(isoCode) == 3 {
 		// converts percentage to float64
 		percentage, err := strconv.ParseFloat(record[3], 64)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		entry := YearRecord{
 			Name:       record[0],
@@ -162,6 +193,7 @@ func (db *RenewableDB) insert(record []string) {
 		}
 		(*db)[isoCode] = append((*db)[isoCode], entry)
 	}
+	return nil
 }
 
 // RetrieveLatest gets the newest data on record for a specific country
@@ -0,0 +1,9 @@
+package types
+
+const (
+	// SchemaVersion is the version of the documents written to the store by this version of the service.
+	// Documents of earlier versions are upgraded by the migrations in the schema package.
+	SchemaVersion = 1
+	// SchemaField is the field holding the schema version of a document. Documents without it are version 0.
+	SchemaField = "schema_version"
+)
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvStr returns the value of an environment variable, or `fallback` if it has not been set
+func GetEnvStr(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvBool returns the value of an environment variable as a bool, or `fallback` if it
+// has not been set or could not be parsed
+func GetEnvBool(key string, fallback bool) bool {
+	value := GetEnvStr(key, "")
+	if len(value) == 0 {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("$%s is not a valid bool (%s). Default: %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// GetEnvInt returns the value of an environment variable as an integer, or `fallback` if it
+// has not been set or could not be parsed
+func GetEnvInt(key string, fallback int) int {
+	value := GetEnvStr(key, "")
+	if len(value) == 0 {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("$%s is not a valid integer (%s). Default: %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// GetEnvDuration returns the value of an environment variable as a duration (e.g. "90s" or "24h"),
+// or `fallback` if it has not been set or could not be parsed
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := GetEnvStr(key, "")
+	if len(value) == 0 {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("$%s is not a valid duration (%s). Default: %v", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetEnv(t *testing.T) {
+	t.Setenv("TEST_ENV_STR", "value")
+	t.Setenv("TEST_ENV_BOOL", "true")
+	t.Setenv("TEST_ENV_INT", "42")
+	t.Setenv("TEST_ENV_DURATION", "90s")
+	t.Setenv("TEST_ENV_INVALID", "not a number")
+
+	if GetEnvStr("TEST_ENV_STR", "fallback") != "value" {
+		t.Fatal("GetEnvStr did not return the value of the variable")
+	}
+	if GetEnvStr("TEST_ENV_UNSET", "fallback") != "fallback" {
+		t.Fatal("GetEnvStr did not return fallback for unset variable")
+	}
+	if !GetEnvBool("TEST_ENV_BOOL", false) {
+		t.Fatal("GetEnvBool did not parse the variable")
+	}
+	if GetEnvInt("TEST_ENV_INT", 0) != 42 {
+		t.Fatal("GetEnvInt did not parse the variable")
+	}
+	if GetEnvInt("TEST_ENV_INVALID", 7) != 7 {
+		t.Fatal("GetEnvInt did not return fallback for invalid variable")
+	}
+	if GetEnvDuration("TEST_ENV_DURATION", 0) != 90*time.Second {
+		t.Fatal("GetEnvDuration did not parse the variable")
+	}
+	if GetEnvDuration("TEST_ENV_INVALID", time.Minute) != time.Minute {
+		t.Fatal("GetEnvDuration did not return fallback for invalid variable")
+	}
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"assignment2/internal/utils"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminCacheHandler handles the administration of the response cache. It supports GET for listing
+// the cached entries with their age, and DELETE for invalidating entries by key, prefix or country.
+func (s *State) AdminCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	segments := utils.GetSegments(r.URL, AdminCachePath)
+	if len(segments) > 0 {
+		http.Error(w, "Usage: "+AdminCachePath+"{?key=key|prefix=prefix|country=code}", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		listCacheEntries(w, s)
+	case http.MethodDelete:
+		invalidateCache(w, r, s)
+	default:
+		http.Error(w, "Only GET and DELETE Method is supported", http.StatusBadRequest)
+	}
+}
+
+// listCacheEntries responds with all valid entries in the response cache, newest first
+func listCacheEntries(w http.ResponseWriter, s *State) {
+	now := time.Now()
+	entries := s.cache.Entries()
+	list := make([]CacheEntryInfo, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, CacheEntryInfo{
+			Key:       entry.Key,
+			Source:    entry.Source,
+			Records:   len(entry.Records),
+			Age:       int(now.Sub(entry.CreatedAt).Seconds()),
+			ExpiresIn: int(entry.ExpiresAt.Sub(now).Seconds()),
+			CreatedAt: entry.CreatedAt,
+			ExpiresAt: entry.ExpiresAt,
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	httpRespondJSON(w, list, nil)
+}
+
+// invalidateCache removes the entries matching exactly one of the key, prefix or country query
+// parameters, and responds with the number of entries removed
+func invalidateCache(w http.ResponseWriter, r *http.Request, s *State) {
+	query := r.URL.Query()
+	key, hasKey := query.Get("key"), query.Has("key")
+	prefix, hasPrefix := query.Get("prefix"), query.Has("prefix")
+	country, hasCountry := query.Get("country"), query.Has("country")
+
+	var match func(entry types.CacheEntry) bool
+	switch {
+	case hasKey && !hasPrefix && !hasCountry:
+		match = func(entry types.CacheEntry) bool { return entry.Key == key }
+	case hasPrefix && !hasKey && !hasCountry:
+		match = func(entry types.CacheEntry) bool { return strings.HasPrefix(entry.Key, prefix) }
+	case hasCountry && !hasKey && !hasPrefix:
+		match = func(entry types.CacheEntry) bool { return entry.HasCountry(country) }
+	default:
+		http.Error(w, "Expected exactly one of the query parameters key, prefix or country", http.StatusBadRequest)
+		return
+	}
+	httpRespondJSON(w, CacheDeleteResponse{Deleted: s.cache.DeleteFunc(match)}, nil)
+}
+
+// requireAdmin returns true if the request carries the admin token, and otherwise responds with 401, or
+// with 503 if no admin token is configured, as the admin endpoints are disabled without one
+func (s *State) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.adminToken) == 0 {
+		http.Error(w, "Admin endpoints are disabled until ADMIN_TOKEN is set", http.StatusServiceUnavailable)
+		return false
+	}
+	if !s.authorizeAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Missing or invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// authorizeAdmin returns true if an admin token is configured, and the request carries it as a bearer token
+func (s *State) authorizeAdmin(r *http.Request) bool {
+	if len(s.adminToken) == 0 {
+		return false
+	}
+	expected := []byte("Bearer " + s.adminToken)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+}
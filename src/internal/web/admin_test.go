@@ -0,0 +1,128 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/res"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdminCacheHandler(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, AdminToken("secret"), WarmupConfig(0, 0))
+	server := httptest.NewServer(SetupRoutes("8080", s))
+	defer server.Close()
+
+	do := func(method string, path string, token string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Request to URL failed:", err.Error())
+		}
+		return res
+	}
+	for _, path := range []string{"nor?neighbours=true", "fin", ""} {
+		do(http.MethodGet, RenewablesCurrentPath+path, "").Body.Close()
+	}
+	do(http.MethodGet, RenewablesHistoryPath+"nor", "").Body.Close()
+
+	// Test 1: the admin endpoints require the admin token
+	if res := do(http.MethodGet, AdminCachePath, "wrong"); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("expected 401 without a valid token, got: ", res.StatusCode)
+	}
+
+	// Test 2: entries are listed with their age and expiry
+	res := do(http.MethodGet, AdminCachePath, "secret")
+	var entries []CacheEntryInfo
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		t.Fatal("Error during decoding", err.Error())
+	}
+	res.Body.Close()
+	if len(entries) != 4 || entries[0].ExpiresIn <= 0 {
+		t.Fatalf("unexpected cache entries: %+v", entries)
+	}
+
+	// Test 3: entries are invalidated by country, including responses with the country as a neighbour
+	deleted := CacheDeleteResponse{}
+	res = do(http.MethodDelete, AdminCachePath+"?country=swe", "secret")
+	_ = json.NewDecoder(res.Body).Decode(&deleted)
+	res.Body.Close()
+	if deleted.Deleted != 2 {
+		t.Fatal("expected the NOR neighbours and all countries responses to be deleted, got: ", deleted.Deleted)
+	}
+
+	// Test 4: entries are invalidated by prefix and by key
+	res = do(http.MethodDelete, AdminCachePath+"?prefix="+RenewablesHistoryPath, "secret")
+	_ = json.NewDecoder(res.Body).Decode(&deleted)
+	res.Body.Close()
+	if deleted.Deleted != 1 {
+		t.Fatal("expected history entry to be deleted by prefix, got: ", deleted.Deleted)
+	}
+	// keys are canonical, with upper-cased country codes, explicit defaults and the dataset version
+	key := RenewablesCurrentPath + "FIN?dataset=" + s.getDataset().Version[:datasetKeyLength] + "&neighbours=false"
+	res = do(http.MethodDelete, AdminCachePath+"?key="+url.QueryEscape(key), "secret")
+	_ = json.NewDecoder(res.Body).Decode(&deleted)
+	res.Body.Close()
+	if deleted.Deleted != 1 || len(s.cache.Entries()) != 0 {
+		t.Fatal("expected remaining entry to be deleted by key, got: ", deleted.Deleted)
+	}
+
+	// Test 5: exactly one filter must be given
+	if res := do(http.MethodDelete, AdminCachePath+"?key=a&prefix=b", "secret"); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected 400 for ambiguous filters, got: ", res.StatusCode)
+	}
+}
+
+// TestAdminWithoutToken verifies that the admin endpoints are disabled when no admin token is configured
+func TestAdminWithoutToken(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, WarmupConfig(0, 0))
+	server := httptest.NewServer(SetupRoutes("8080", s))
+	defer server.Close()
+
+	// Test 1: every admin endpoint responds with 503, with or without a bearer token
+	for _, path := range []string{AdminCachePath, AdminBackupPath, AdminDatasetPath} {
+		if status := HttpGetStatusCode(t, server.URL+path); status != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 for %s without an admin token, got: %d", path, status)
+		}
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer ")
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Request to URL failed:", err.Error())
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 for %s with an empty bearer token, got: %d", path, response.StatusCode)
+		}
+	}
+}
+
+func TestCacheTTLPolicy(t *testing.T) {
+	policy, err := ParseCacheTTLPolicy("current=1h, history=48h")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, CacheTTLPolicy(policy))
+
+	// Test 1: the TTL is selected by endpoint, and endpoints without a policy use the default
+	if s.cacheTTL(RenewablesHistoryPath+"nor") != 48*time.Hour || s.cacheTTL(RenewablesCurrentPath) != time.Hour {
+		t.Fatal("expected TTL to be selected by endpoint")
+	}
+	if s.cacheTTL(StatusPath) != 0 {
+		t.Fatal("expected no TTL for endpoints without a policy")
+	}
+
+	// Test 2: unknown endpoints and invalid durations are rejected
+	if _, err := ParseCacheTTLPolicy("status=1h"); err == nil {
+		t.Fatal("expected error for unknown endpoint")
+	}
+	if _, err := ParseCacheTTLPolicy("current=soon"); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+}
@@ -0,0 +1,183 @@
+package web
+
+import (
+	"assignment2/internal/backup"
+	"assignment2/internal/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AdminBackupHandler handles the backup of the persisted state. It supports GET for exporting the
+// registrations, invocation counts and buckets as a versioned archive, and POST for importing an
+// archive with ?mode=merge (the default) or ?mode=replace.
+func (s *State) AdminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	segments := utils.GetSegments(r.URL, AdminBackupPath)
+	if len(segments) > 0 {
+		http.Error(w, "Usage: "+AdminBackupPath+"{?mode=merge|replace}", http.StatusBadRequest)
+		return
+	}
+	if s.store == nil {
+		http.Error(w, "Backups are not available without a store", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		exportArchive(w, s)
+	case http.MethodPost:
+		importArchive(w, r, s)
+	default:
+		http.Error(w, "Only GET and POST Method is supported", http.StatusBadRequest)
+	}
+}
+
+// exportArchive flushes the pending updates, so that the archive includes them, and responds with the archive
+// as an attachment
+func exportArchive(w http.ResponseWriter, s *State) {
+	if _, err := s.flushUpdates(); err != nil {
+		http.Error(w, "Could not write pending updates to the store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	archive, err := backup.Export(s.store)
+	if err != nil {
+		http.Error(w, "Could not export the store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition",
+		"attachment; filename=\"backup-"+archive.CreatedAt.Format("20060102T150405Z")+".json\"")
+	httpRespondJSON(w, archive, nil)
+}
+
+// importArchive restores the archive in the request body into the store, and reloads the registrations,
+// invocation counts, dead letters and delivery logs of this instance from the store. The pending updates are flushed first, and no
+// flush happens until the state has been reloaded, so that they are neither lost nor counted twice.
+func importArchive(w http.ResponseWriter, r *http.Request, s *State) {
+	mode := backup.Merge
+	if r.URL.Query().Has("mode") {
+		mode = r.URL.Query().Get("mode")
+	}
+	if mode != backup.Merge && mode != backup.Replace {
+		http.Error(w, "Expected mode to be merge or replace", http.StatusBadRequest)
+		return
+	}
+	archive, err := backup.Read(http.MaxBytesReader(w, r.Body, MaxArchiveSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.flush.Lock()
+	defer s.flush.Unlock()
+	if _, err := s.flushUpdatesLocked(); err != nil {
+		http.Error(w, "Could not write pending updates to the store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := backup.Import(s.store, archive, mode); err != nil {
+		http.Error(w, "Could not import the archive: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.reloadState(); err != nil {
+		http.Error(w, "Imported the archive, but could not reload the state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Imported archive with %d registrations in %s mode", len(archive.Registrations), mode)
+	httpRespondJSON(w, ImportResponse{
+		Mode:          mode,
+		Registrations: len(archive.Registrations),
+		Countries:     len(archive.InvocationCounts),
+		Buckets:       len(archive.InvocationBuckets),
+		DeadLetters:   len(archive.DeadLetters),
+		Deliveries:    len(archive.Deliveries),
+	}, nil)
+}
+
+// reloadState replaces the registrations, invocation counts, dead letters and delivery logs with those in
+// the store, keeping the increments not yet queued for the store, and forgets the last dispatches so that
+// the webhooks are checked against their restored leases on the next store update
+func (s *State) reloadState() error {
+	registrations, err := s.store.GetAllRegistrations()
+	if err != nil {
+		return err
+	}
+	counts, err := s.store.GetAllInvocationCounts()
+	if err != nil {
+		return err
+	}
+	deadLetters, err := s.store.GetAllDeadLetters()
+	if err != nil {
+		return err
+	}
+	logs, err := s.store.GetAllDeliveries()
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for countryCode, delta := range s.countDeltas {
+		counts[countryCode] += delta
+	}
+	s.replaceRegistrationsLocked(registrations)
+	s.invocationCounts = counts
+	s.dispatched = map[string]int64{}
+	s.deadLetters = deadLetters
+	s.deliveries = map[string]*deliveryRing{}
+	for _, deliveries := range logs {
+		s.setDeliveriesLocked(deliveries)
+	}
+	return nil
+}
+
+// snapshotWorker runs in the background alongside storeUpdateWorker, and snapshots the store to the
+// snapshot directory every `interval`, deleting the oldest snapshots beyond the number to keep
+func snapshotWorker(s *State, interval time.Duration) {
+	defer s.workers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.snapshot()
+		}
+	}
+}
+
+// snapshot writes a snapshot of the store, and records the result for the status endpoint
+func (s *State) snapshot() {
+	s.lock.RLock()
+	dir, keep := s.snapshotStats.Dir, s.snapshotStats.Keep
+	s.lock.RUnlock()
+
+	start := time.Now()
+	path, err := backup.Snapshot(s.store, dir, keep)
+	if err != nil {
+		log.Println("Could not snapshot the store: " + err.Error())
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.snapshotStats.Runs++
+	s.snapshotStats.LastRun = start
+	s.snapshotStats.Error = ""
+	if err != nil {
+		s.snapshotStats.Error = err.Error()
+	} else {
+		s.snapshotStats.LastPath = path
+	}
+}
+
+// getSnapshotStats returns the result of the latest snapshot, or nil if the snapshots are disabled
+func (s *State) getSnapshotStats() *SnapshotStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.snapshotStats.Interval <= 0 {
+		return nil
+	}
+	stats := s.snapshotStats
+	return &stats
+}
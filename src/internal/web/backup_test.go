@@ -0,0 +1,125 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAdminBackupHandler(t *testing.T) {
+	source := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, AdminToken("secret"), WarmupConfig(0, 0))
+	target := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, AdminToken("secret"), WarmupConfig(0, 0))
+	source.newRegistration(types.InvocationRegistration{WebhookID: "abc", URL: "http://example.com", Country: "NOR", Calls: 2})
+	target.newRegistration(types.InvocationRegistration{WebhookID: "old", URL: "http://example.com", Country: "SWE", Calls: 1})
+	for i := 0; i < 3; i++ {
+		source.incrementInvocationCount("NOR")
+	}
+	target.incrementInvocationCount("SWE")
+	source.addDeadLetter(webhookEvent{URL: "http://example.com", Payload: WebhookResponse{WebhookID: "abc", Country: "Norway", Calls: 2}, Attempts: 5}, errors.New("unreachable"))
+	source.recordDelivery(types.Delivery{Payload: types.DeliveryPayload{WebhookID: "abc"}, Status: http.StatusInternalServerError, Attempt: 5})
+	target.addDeadLetter(webhookEvent{URL: "http://example.com", Payload: WebhookResponse{WebhookID: "old", Country: "Sweden", Calls: 1}, Attempts: 5}, errors.New("unreachable"))
+
+	do := func(s *State, method string, path string, token string, body []byte) *http.Response {
+		server := httptest.NewServer(SetupRoutes("8080", s))
+		defer server.Close()
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Request to URL failed:", err.Error())
+		}
+		return res
+	}
+
+	// Test 1: the backup endpoint requires the admin token
+	if res := do(source, http.MethodGet, AdminBackupPath, "", nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatal("expected 401 without a valid token, got: ", res.StatusCode)
+	}
+
+	// Test 2: the export includes the updates that were still pending
+	response := do(source, http.MethodGet, AdminBackupPath, "secret", nil)
+	data, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	var archive types.Archive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		t.Fatal("Error during decoding", err.Error())
+	}
+	if response.Header.Get("Content-Disposition") == "" || archive.Version != types.ArchiveVersion ||
+		len(archive.Registrations) != 1 || archive.InvocationCounts["NOR"] != 3 || len(archive.DeadLetters) != 1 || len(archive.Deliveries) != 1 {
+		t.Fatalf("unexpected archive: %+v", archive)
+	}
+
+	// Test 3: replacing reloads the registrations, counts, dead letters and delivery logs of the instance,
+	// discarding what is not in the archive
+	response = do(target, http.MethodPost, AdminBackupPath+"?mode=replace", "secret", data)
+	var imported ImportResponse
+	_ = json.NewDecoder(response.Body).Decode(&imported)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || imported.Mode != "replace" || imported.Registrations != 1 || imported.DeadLetters != 1 || imported.Deliveries != 1 {
+		t.Fatalf("unexpected import response: %d %+v", response.StatusCode, imported)
+	}
+	if _, ok := target.getRegistration("old"); ok || target.getNumberOfRegistrations() != 1 {
+		t.Fatal("expected only the archived registration, got: ", target.getAllRegistrations())
+	}
+	if target.getInvocationCount("NOR") != 3 || target.getInvocationCount("SWE") != 0 {
+		t.Fatal("expected the archived counts, got: ", target.invocationCounts)
+	}
+	if deadLetters := target.getAllDeadLetters(); len(deadLetters) != 1 || deadLetters[0].WebhookID != "abc" {
+		t.Fatalf("expected only the archived dead letter, got: %+v", deadLetters)
+	}
+	if deliveries, _ := target.getDeliveries("abc"); len(deliveries) != 1 || deliveries[0].Status != http.StatusInternalServerError {
+		t.Fatalf("expected the archived delivery log, got: %+v", deliveries)
+	}
+
+	// Test 4: unknown modes, invalid archives and services without a store are rejected
+	if res := do(target, http.MethodPost, AdminBackupPath+"?mode=overwrite", "secret", data); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected 400 for an unknown mode, got: ", res.StatusCode)
+	}
+	if res := do(target, http.MethodPost, AdminBackupPath, "secret", []byte(`{"version": 99}`)); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected 400 for an archive of a later version, got: ", res.StatusCode)
+	}
+	without := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, AdminToken("secret"), WarmupConfig(0, 0))
+	if res := do(without, http.MethodGet, AdminBackupPath, "secret", nil); res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("expected 503 without a store, got: ", res.StatusCode)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory},
+		SnapshotConfig(dir, time.Hour, 1), WarmupConfig(0, 0))
+
+	// Test 1: a snapshot is written to the directory, and reported on the status endpoint
+	s.snapshot()
+	stats := s.getSnapshotStats()
+	if stats == nil || stats.Runs != 1 || stats.Error != "" || stats.Keep != 1 {
+		t.Fatalf("unexpected snapshot stats: %+v", stats)
+	}
+	if _, err := os.Stat(stats.LastPath); err != nil {
+		t.Fatal("expected the snapshot to be written, got: ", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(s.StatusHandler))
+	defer server.Close()
+	status := APIStatus{}
+	HttpGetAndDecode(t, server.URL+StatusPath, &status)
+	if status.Snapshots == nil || status.Snapshots.Interval != 3600 {
+		t.Fatalf("unexpected snapshot status: %+v", status.Snapshots)
+	}
+
+	// Test 2: snapshots are disabled without a directory
+	disabled := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, WarmupConfig(0, 0))
+	if disabled.getSnapshotStats() != nil {
+		t.Fatal("expected no snapshot stats when the snapshots are disabled")
+	}
+}
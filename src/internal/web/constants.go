@@ -1,5 +1,7 @@
 package web
 
+import "time"
+
 const (
 	Version               = "v1"
 	DefaultPath           = "/energy/" + Version + "/"
@@ -7,5 +9,38 @@ const (
 	RenewablesHistoryPath = DefaultPath + "renewables/history/"
 	NotificationsPath     = DefaultPath + "notifications/"
 	StatusPath            = DefaultPath + "status/"
-	FirebaseUpdateFreq    = 5 // update firebase every 5 seconds
+	AdminCachePath        = DefaultPath + "admin/cache/"
+	AdminBackupPath       = DefaultPath + "admin/backup/"
+	AdminDatasetPath      = DefaultPath + "admin/dataset/"
+	ReadinessPath         = DefaultPath + "ready/"
+	StatsPath             = DefaultPath + "stats/"
+	FirebaseUpdateFreq    = 5                  // update firebase every 5 seconds
+	CountriesCacheTTL     = 24 * time.Hour     // default time before cached country metadata is refreshed
+	CountriesCooldown     = 30 * time.Second   // default time a failing countries provider is skipped
+	StatusProbeTimeout    = 3 * time.Second    // deadline for probing dependent services on the status endpoint
+	WebhookTimeout        = 10 * time.Second   // deadline for delivering a webhook
+	CacheSize             = 1000               // default number of responses in the in-memory cache
+	CacheTTL              = time.Hour          // default time a response is kept in the in-memory cache
+	FirestoreCacheTTL     = 7 * 24 * time.Hour // default time a response is kept in the Firestore cache
+	CacheSweepInterval    = time.Hour          // default time between purges of expired cache entries
+	WarmupSize            = 20                 // default number of most requested countries cached at startup
+	WarmupTimeout         = 30 * time.Second   // default time the startup waits for the warm-up before accepting requests
+	HTTPCacheMaxAge       = time.Hour          // default time clients and CDNs may cache a response
+	ShutdownTimeout       = 8 * time.Second    // default time to drain requests and webhooks on shutdown, within the 10 seconds given by docker stop
+	WebhookLeaseTTL       = 15 * time.Second   // time an instance keeps dispatching the webhooks of a registration without renewing its lease, i.e. three store updates
+	SnapshotInterval      = 24 * time.Hour     // default time between snapshots of the store
+	SnapshotKeep          = 7                  // default number of snapshots kept in the snapshot directory
+	MaxArchiveSize        = 64 << 20           // largest archive accepted by the backup endpoint, in bytes
+	WebhookWorkers        = 8                  // default number of workers posting webhooks
+	WebhookQueueSize      = 1000               // default number of webhooks waiting to be posted before new ones are dropped
+	WebhookHostLimit      = 2                  // default number of webhooks posted to the same host at once
+	WebhookMaxAttempts    = 5                  // default number of times a webhook is posted before it is dead-lettered
+	WebhookRetryBackoff   = time.Second        // default backoff before the first retry of a webhook, doubled on every further retry
+	WebhookMaxBackoff     = time.Minute        // longest backoff between retries of a webhook
+	DeliveryLogSize       = 20                 // default number of delivery attempts kept for every registration
+	HourlyStatsRetention  = 7 * 24 * time.Hour // time hourly invocation buckets are kept in memory without a store
+	DailyStatsRetention   = 8784 * time.Hour   // time daily invocation buckets are kept in memory without a store, i.e. 366 days
+	SecretGracePeriod     = 24 * time.Hour     // default time the old secret of a registration keeps verifying after it is rotated
+	MinSecretLength       = 16                 // shortest secret accepted from a client
+	DeadLettersPath       = NotificationsPath + "dead-letters/"
 )
@@ -0,0 +1,172 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// sharedStore is a storage mode that opens the same store for several instances of the service
+type sharedStore struct {
+	store store.Store
+}
+
+// open returns the shared store
+func (m sharedStore) open() (store.Store, error) {
+	return m.store, nil
+}
+
+// TestInvocationCounters verifies that no increments are lost when countries are invocated concurrently
+func TestInvocationCounters(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, JournalConfig(t.TempDir()), WarmupConfig(0, 0))
+	countries := s.getDataset().DB.RetrieveLatest("").MakeUniqueCCNACodes()
+
+	// Test 1: every increment is counted
+	const goroutines, calls = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				ProcessWebhookByCountry(countries, s)
+			}
+		}()
+	}
+	wg.Wait()
+	if count := s.getInvocationCount(countries[0]); count != goroutines*calls {
+		t.Fatalf("expected %d invocations, got: %d", goroutines*calls, count)
+	}
+
+	// Test 2: the counts are queued once per country, and written to the store on flush
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	counts, _ := s.store.GetAllInvocationCounts()
+	if len(counts) != len(countries) || counts[countries[0]] != goroutines*calls {
+		t.Fatalf("expected %d countries with %d invocations in the store, got: %d countries, %d invocations",
+			len(countries), goroutines*calls, len(counts), counts[countries[0]])
+	}
+}
+
+// TestMultipleInstances verifies that instances sharing a store count every invocation once, and that the
+// webhooks of a registration are posted once, by the instance holding its lease
+func TestMultipleInstances(t *testing.T) {
+	var lock sync.Mutex
+	var posted []int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data WebhookResponse
+		_ = json.NewDecoder(r.Body).Decode(&data)
+		lock.Lock()
+		posted = append(posted, data.Calls)
+		lock.Unlock()
+	}))
+	defer receiver.Close()
+	received := func() []int64 {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]int64{}, posted...)
+	}
+
+	shared := sharedStore{store: store.NewMemory()}
+	a := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), shared, InstanceID("a"), WarmupConfig(0, 0))
+	a.newRegistration(types.InvocationRegistration{WebhookID: "shared", URL: receiver.URL, Country: "NOR", Calls: 3})
+	if _, err := a.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	b := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), shared, InstanceID("b"), WarmupConfig(0, 0))
+
+	// Test 1: the invocations of both instances are added to the shared counts
+	for i := 0; i < 4; i++ {
+		ProcessWebhookByCountry([]string{"NOR"}, a)
+	}
+	for i := 0; i < 5; i++ {
+		ProcessWebhookByCountry([]string{"NOR"}, b)
+	}
+	if _, err := a.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	totalsB, _ := b.flushUpdates()
+	totalsA, _ := a.flushUpdates()
+	if totalsA["NOR"] != 9 || totalsB["NOR"] != 9 || a.getInvocationCount("NOR") != 9 || b.getInvocationCount("NOR") != 9 {
+		t.Fatal("expected 9 invocations on both instances, got: ", a.getInvocationCount("NOR"), b.getInvocationCount("NOR"))
+	}
+
+	// Test 2: only the instance holding the lease posts the webhook, once for every multiple of the calls
+	b.dispatchWebhooks(totalsB)
+	a.dispatchWebhooks(totalsA)
+	b.dispatchWebhooks(totalsB)
+	waitForDeliveries(t, a, b)
+	if calls := received(); !reflect.DeepEqual(calls, []int64{3, 6, 9}) {
+		t.Fatal("expected webhooks for 3, 6 and 9 invocations, got: ", calls)
+	}
+
+	// Test 3: invocations on the other instance are posted by the instance holding the lease
+	for i := 0; i < 3; i++ {
+		ProcessWebhookByCountry([]string{"NOR"}, a)
+	}
+	totalsA, _ = a.flushUpdates()
+	a.dispatchWebhooks(totalsA)
+	totalsB, _ = b.flushUpdates()
+	b.dispatchWebhooks(totalsB)
+	waitForDeliveries(t, a, b)
+	if calls := received(); !reflect.DeepEqual(calls, []int64{3, 6, 9, 12}) {
+		t.Fatal("expected webhook for 12 invocations from the lease holder, got: ", calls)
+	}
+
+	// Test 4: registrations created on the other instance are picked up on the next flush
+	b.newRegistration(types.InvocationRegistration{WebhookID: "other", URL: receiver.URL, Country: "SWE", Calls: 5})
+	if _, err := b.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, err := a.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if registration, ok := a.getRegistration("other"); !ok || registration.Calls != 5 {
+		t.Fatal("expected the registration of the other instance, got: ", registration)
+	}
+
+	// Test 5: registrations deleted on the other instance are dropped, and their webhooks are no longer posted
+	if err := b.deleteRegistration("shared"); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, err := b.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	for i := 0; i < 3; i++ {
+		ProcessWebhookByCountry([]string{"NOR"}, a)
+	}
+	totalsA, _ = a.flushUpdates()
+	a.dispatchWebhooks(totalsA)
+	waitForDeliveries(t, a)
+	if _, ok := a.getRegistration("shared"); ok {
+		t.Fatal("expected the deleted registration to be dropped")
+	}
+	if calls := received(); !reflect.DeepEqual(calls, []int64{3, 6, 9, 12}) {
+		t.Fatal("expected no webhooks for the deleted registration, got: ", calls)
+	}
+	// the lease is only created with the checkpoint if it is missing, i.e. it was not created again
+	if lease, _, err := shared.store.AcquireLease(types.WebhookLease("shared"), "c", WebhookLeaseTTL, -1); err != nil || lease.Checkpoint != -1 {
+		t.Fatalf("expected the lease of the deleted registration not to be created again, got: %+v", lease)
+	}
+}
+
+// BenchmarkProcessWebhookByCountry measures the throughput of counting the invocations of a request for
+// all countries, as done for every call to /renewables/current/
+func BenchmarkProcessWebhookByCountry(b *testing.B) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, JournalConfig(b.TempDir()), WarmupConfig(0, 0))
+	countries := s.getDataset().DB.RetrieveLatest("").MakeUniqueCCNACodes()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ProcessWebhookByCountry(countries, s)
+		}
+	})
+}
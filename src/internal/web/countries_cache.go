@@ -0,0 +1,161 @@
+package web
+
+import (
+	"assignment2/api"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedRestCountries wraps another restCountriesMode and keeps the country metadata in memory.
+// Entries older than the TTL are still served (stale-while-revalidate), but trigger a refresh
+// in the background so that the next request gets fresh data.
+type CachedRestCountries struct {
+	mode       restCountriesMode
+	ttl        time.Duration
+	lock       sync.RWMutex
+	entries    map[string]countryCacheEntry
+	refreshing map[string]bool
+	requests   singleflight.Group // coalesces concurrent fetches of the same country
+	hits       atomic.Int64
+	staleHits  atomic.Int64
+	misses     atomic.Int64
+}
+
+// countryCacheEntry holds a cached country and the time it was fetched
+type countryCacheEntry struct {
+	country api.Country
+	fetched time.Time
+}
+
+// CountriesCacheStats holds the counters reported for the countries cache on the status endpoint
+type CountriesCacheStats struct {
+	Entries   int   `json:"entries"`
+	Hits      int64 `json:"hits"`
+	StaleHits int64 `json:"stale_hits"`
+	Misses    int64 `json:"misses"`
+	TTL       int   `json:"ttl"`
+}
+
+// NewCachedRestCountries returns a cache in front of `mode`, where entries are considered fresh for `ttl`
+func NewCachedRestCountries(mode restCountriesMode, ttl time.Duration) *CachedRestCountries {
+	return &CachedRestCountries{
+		mode:       mode,
+		ttl:        ttl,
+		entries:    make(map[string]countryCacheEntry),
+		refreshing: make(map[string]bool),
+	}
+}
+
+// Prefetch warms up the cache with all countries from the underlying mode
+func (c *CachedRestCountries) Prefetch() error {
+	countries, err := c.getAllCountries()
+	if err != nil {
+		return err
+	}
+	log.Printf("Prefetched %d countries into the countries cache", len(countries))
+	return nil
+}
+
+// getCountry returns the country from the cache if present. A missing entry is fetched synchronously,
+// while a stale entry is returned immediately and refreshed in the background.
+func (c *CachedRestCountries) getCountry(cca string) (api.Country, error) {
+	cca = strings.ToUpper(cca)
+
+	c.lock.RLock()
+	entry, ok := c.entries[cca]
+	c.lock.RUnlock()
+
+	if !ok {
+		c.misses.Add(1)
+		return c.fetch(cca)
+	}
+	if time.Since(entry.fetched) > c.ttl {
+		c.staleHits.Add(1)
+		c.revalidate(cca)
+	} else {
+		c.hits.Add(1)
+	}
+	return entry.country, nil
+}
+
+// getAllCountries fetches all countries from the underlying mode and stores every one of them
+func (c *CachedRestCountries) getAllCountries() ([]api.Country, error) {
+	countries, err := c.mode.getAllCountries()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, country := range countries {
+		c.entries[strings.ToUpper(country.CCA3)] = countryCacheEntry{country: country, fetched: now}
+	}
+	return countries, nil
+}
+
+// getRestCountriesStatus reports the status of the underlying mode
+func (c *CachedRestCountries) getRestCountriesStatus() int {
+	return c.mode.getRestCountriesStatus()
+}
+
+// reportStatus adds the cache counters to the status, as well as anything reported by the underlying mode
+func (c *CachedRestCountries) reportStatus(status *APIStatus) {
+	status.CountriesCache = c.stats()
+	if reporter, ok := c.mode.(statusReporter); ok {
+		reporter.reportStatus(status)
+	}
+}
+
+// stats returns a snapshot of the cache counters
+func (c *CachedRestCountries) stats() *CountriesCacheStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return &CountriesCacheStats{
+		Entries:   len(c.entries),
+		Hits:      c.hits.Load(),
+		StaleHits: c.staleHits.Load(),
+		Misses:    c.misses.Load(),
+		TTL:       int(c.ttl.Seconds()),
+	}
+}
+
+// fetch retrieves a single country from the underlying mode and stores it in the cache. Concurrent
+// fetches of the same country are coalesced into a single request. Errors are not cached, so the
+// next request will try again.
+func (c *CachedRestCountries) fetch(cca string) (api.Country, error) {
+	result, err, _ := c.requests.Do(cca, func() (any, error) {
+		country, err := c.mode.getCountry(cca)
+		if err != nil {
+			return country, err
+		}
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.entries[cca] = countryCacheEntry{country: country, fetched: time.Now()}
+		return country, nil
+	})
+	return result.(api.Country), err
+}
+
+// revalidate refreshes a stale entry in the background, unless a refresh is already in progress
+func (c *CachedRestCountries) revalidate(cca string) {
+	c.lock.Lock()
+	if c.refreshing[cca] {
+		c.lock.Unlock()
+		return
+	}
+	c.refreshing[cca] = true
+	c.lock.Unlock()
+
+	go func() {
+		if _, err := c.fetch(cca); err != nil {
+			log.Println("Could not refresh " + cca + " in countries cache: " + err.Error())
+		}
+		c.lock.Lock()
+		delete(c.refreshing, cca)
+		c.lock.Unlock()
+	}()
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"assignment2/api"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCountries is a restCountriesMode that counts how many times it has been asked for data
+type countingCountries struct {
+	calls atomic.Int64
+	fail  atomic.Bool
+}
+
+func (c *countingCountries) getCountry(cca string) (api.Country, error) {
+	c.calls.Add(1)
+	if c.fail.Load() {
+		return api.Country{}, errors.New("unavailable")
+	}
+	return api.Country{CCA3: cca, Borders: []string{"SWE"}}, nil
+}
+
+func (c *countingCountries) getAllCountries() ([]api.Country, error) {
+	c.calls.Add(1)
+	return []api.Country{{CCA3: "NOR", Borders: []string{"SWE"}}, {CCA3: "SWE", Borders: []string{"NOR"}}}, nil
+}
+
+func (c *countingCountries) getRestCountriesStatus() int {
+	return http.StatusOK
+}
+
+func TestCachedRestCountries(t *testing.T) {
+	mode := &countingCountries{}
+	cache := NewCachedRestCountries(mode, time.Hour)
+
+	// Test 1: the first lookup is a miss, the second one a hit
+	if _, err := cache.getCountry("nor"); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, err := cache.getCountry("NOR"); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	stats := cache.stats()
+	if stats.Misses != 1 || stats.Hits != 1 || mode.calls.Load() != 1 {
+		t.Fatalf("expected 1 miss and 1 hit, got %d misses and %d hits", stats.Misses, stats.Hits)
+	}
+
+	// Test 2: errors are not cached
+	mode.fail.Store(true)
+	if _, err := cache.getCountry("FIN"); err == nil {
+		t.Fatal("expected error to be returned")
+	}
+	if cache.stats().Entries != 1 {
+		t.Fatal("expected failed lookup not to be cached")
+	}
+	mode.fail.Store(false)
+
+	// Test 3: prefetch fills the cache
+	if err := cache.Prefetch(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if cache.stats().Entries != 2 {
+		t.Fatal("expected 2 entries after prefetch, got: ", cache.stats().Entries)
+	}
+
+	// Test 4: stale entries are served and refreshed in the background
+	stale := NewCachedRestCountries(mode, 0)
+	_, _ = stale.getCountry("NOR")
+	before := mode.calls.Load()
+	country, err := stale.getCountry("NOR")
+	if err != nil || country.CCA3 != "NOR" {
+		t.Fatal("expected stale entry to be served")
+	}
+	for i := 0; i < 100 && mode.calls.Load() == before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mode.calls.Load() == before {
+		t.Fatal("expected stale entry to be refreshed in the background")
+	}
+	if stale.stats().StaleHits != 1 {
+		t.Fatal("expected 1 stale hit, got: ", stale.stats().StaleHits)
+	}
+}
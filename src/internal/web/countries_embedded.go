@@ -0,0 +1,80 @@
+package web
+
+import (
+	"assignment2/api"
+	"assignment2/res"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// errCountryNotFound is returned by modes when the requested country does not exist
+var errCountryNotFound = errors.New("could not find country")
+
+// EmbeddedRestCountries represents a mode where the countries are answered from a local copy of the
+// REST Countries dataset, by default the one compiled into the binary. No network access is needed in this mode.
+type EmbeddedRestCountries struct {
+	countries []api.Country
+	byCCA3    map[string]api.Country
+}
+
+// NewEmbeddedRestCountries parses the REST Countries dataset at `filepath` and indexes it by cca3 code.
+// If `filepath` is res.Embedded, then the dataset compiled into the binary is used.
+func NewEmbeddedRestCountries(filepath string) *EmbeddedRestCountries {
+	file, err := res.Open(filepath, res.RestCountriesJSON)
+	if err != nil {
+		log.Fatal("Could not open countries dataset "+filepath, err)
+	}
+	defer file.Close()
+
+	var countries []api.Country
+	if err := json.NewDecoder(file).Decode(&countries); err != nil {
+		log.Fatal("Could not decode countries dataset: ", err)
+	}
+	byCCA3 := make(map[string]api.Country, len(countries))
+	for _, country := range countries {
+		byCCA3[strings.ToUpper(country.CCA3)] = country
+	}
+	return &EmbeddedRestCountries{countries: countries, byCCA3: byCCA3}
+}
+
+// getCountry returns the country metadata from the embedded dataset
+func (e *EmbeddedRestCountries) getCountry(cca string) (api.Country, error) {
+	if country, ok := e.byCCA3[strings.ToUpper(cca)]; ok {
+		return country, nil
+	}
+	return api.Country{}, fmt.Errorf("%w: %s", errCountryNotFound, cca)
+}
+
+// getAllCountries returns all countries in the embedded dataset
+func (e *EmbeddedRestCountries) getAllCountries() ([]api.Country, error) {
+	return e.countries, nil
+}
+
+// getRestCountriesStatus always reports OK, as the embedded dataset is always available
+func (e *EmbeddedRestCountries) getRestCountriesStatus() int {
+	return http.StatusOK
+}
+
+// ParseCountriesMode returns the restCountriesMode with the given name. Supported names are
+// "remote" (3rd party API), "stub" (local stub service) and "embedded" (local dataset, read from
+// `countriesJSON` or compiled in if it is res.Embedded).
+// A URL prefixed by http:// or https:// is used as a mirror of the 3rd party API.
+func ParseCountriesMode(name string, countriesJSON string) (restCountriesMode, error) {
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		return UseRestCountries{BaseURL: name}, nil
+	}
+	switch strings.ToLower(name) {
+	case "remote":
+		return UseRestCountries{}, nil
+	case "stub":
+		return StubRestCountries{}, nil
+	case "embedded":
+		return NewEmbeddedRestCountries(countriesJSON), nil
+	default:
+		return nil, errors.New("unknown countries mode: " + name)
+	}
+}
@@ -0,0 +1,45 @@
+package web
+
+import (
+	"assignment2/res"
+	"net/http"
+	"testing"
+)
+
+func TestEmbeddedRestCountries(t *testing.T) {
+	mode := NewEmbeddedRestCountries(res.Embedded)
+
+	// Test 1: looking up a country returns its neighbours, name and region
+	country, err := mode.getCountry("nor")
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if country.Name.Common != "Norway" || country.Region != "Europe" {
+		t.Fatalf("unexpected metadata for NOR: %+v", country)
+	}
+	if len(country.Borders) != 3 {
+		t.Fatal("expected Norway to have 3 neighbours, got: ", len(country.Borders))
+	}
+
+	// Test 2: unknown countries return an error
+	if _, err := mode.getCountry("XYZ"); err == nil {
+		t.Fatal("expected error for unknown country")
+	}
+
+	// Test 3: all countries are returned, and the mode is always available
+	all, _ := mode.getAllCountries()
+	if len(all) != 250 {
+		t.Fatal("expected 250 countries, got: ", len(all))
+	}
+	if mode.getRestCountriesStatus() != http.StatusOK {
+		t.Fatal("expected embedded mode to always report OK")
+	}
+
+	// Test 4: modes can be selected by name
+	if _, err := ParseCountriesMode("embedded", res.Embedded); err != nil {
+		t.Fatal("expected embedded mode to be recognised")
+	}
+	if _, err := ParseCountriesMode("carrier pigeon", res.Embedded); err == nil {
+		t.Fatal("expected unknown mode to return an error")
+	}
+}
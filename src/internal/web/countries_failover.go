@@ -0,0 +1,219 @@
+package web
+
+import (
+	"assignment2/api"
+	"assignment2/internal/web_client"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CountriesProvider is a named restCountriesMode used as a link in a FailoverRestCountries chain
+type CountriesProvider struct {
+	Name string
+	Mode restCountriesMode
+}
+
+// FailoverRestCountries tries a chain of providers in order of priority. A provider that fails is
+// marked unhealthy and skipped until its cooldown has passed, after which it is tried again, so the
+// chain automatically falls back to e.g. mirrors or embedded data, and recovers when the primary is back.
+type FailoverRestCountries struct {
+	providers []*providerHealth
+	cooldown  time.Duration
+	lock      sync.Mutex
+	active    int
+	reason    string
+}
+
+// providerHealth tracks the health of a single provider in the chain
+type providerHealth struct {
+	CountriesProvider
+	failures       int
+	lastError      string
+	lastFailure    time.Time
+	unhealthyUntil time.Time
+}
+
+// CountriesProviderStatus describes the active provider in the chain, and why it is active
+type CountriesProviderStatus struct {
+	Active    string                  `json:"active"`
+	Reason    string                  `json:"reason"`
+	Providers []CountriesProviderInfo `json:"providers"`
+}
+
+// CountriesProviderInfo describes the health of a single provider in the chain
+type CountriesProviderInfo struct {
+	Name        string     `json:"name"`
+	Healthy     bool       `json:"healthy"`
+	Failures    int        `json:"failures"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+}
+
+// NewFailoverRestCountries returns a chain of the given providers, where a failing provider is
+// skipped for `cooldown` before it is tried again
+func NewFailoverRestCountries(cooldown time.Duration, providers ...CountriesProvider) *FailoverRestCountries {
+	f := FailoverRestCountries{cooldown: cooldown, reason: "primary provider has not been used yet"}
+	for _, provider := range providers {
+		f.providers = append(f.providers, &providerHealth{CountriesProvider: provider})
+	}
+	return &f
+}
+
+// ParseCountriesProviders takes a comma separated list of provider names (see ParseCountriesMode) and
+// returns a single mode, or a FailoverRestCountries chain if more than one provider is listed
+func ParseCountriesProviders(list string, countriesJSON string, cooldown time.Duration) (restCountriesMode, error) {
+	var providers []CountriesProvider
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		mode, err := ParseCountriesMode(name, countriesJSON)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, CountriesProvider{Name: name, Mode: mode})
+	}
+	switch len(providers) {
+	case 0:
+		return nil, errors.New("no countries providers specified")
+	case 1:
+		return providers[0].Mode, nil
+	default:
+		return NewFailoverRestCountries(cooldown, providers...), nil
+	}
+}
+
+// getCountry returns the country metadata from the first healthy provider
+func (f *FailoverRestCountries) getCountry(cca string) (api.Country, error) {
+	var country api.Country
+	err := f.call(func(mode restCountriesMode) error {
+		var err error
+		country, err = mode.getCountry(cca)
+		return err
+	})
+	return country, err
+}
+
+// getAllCountries returns the metadata for all countries from the first healthy provider
+func (f *FailoverRestCountries) getAllCountries() ([]api.Country, error) {
+	var countries []api.Country
+	err := f.call(func(mode restCountriesMode) error {
+		var err error
+		countries, err = mode.getAllCountries()
+		return err
+	})
+	return countries, err
+}
+
+// getRestCountriesStatus returns the status of the currently active provider
+func (f *FailoverRestCountries) getRestCountriesStatus() int {
+	f.lock.Lock()
+	active := f.providers[f.active]
+	f.lock.Unlock()
+	return active.Mode.getRestCountriesStatus()
+}
+
+// reportStatus adds the active provider, the reason it is active and the health of all providers
+func (f *FailoverRestCountries) reportStatus(status *APIStatus) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	now := time.Now()
+	providerStatus := CountriesProviderStatus{Active: f.providers[f.active].Name, Reason: f.reason}
+	for _, provider := range f.providers {
+		info := CountriesProviderInfo{
+			Name:      provider.Name,
+			Healthy:   !now.Before(provider.unhealthyUntil),
+			Failures:  provider.failures,
+			LastError: provider.lastError,
+		}
+		if !provider.lastFailure.IsZero() {
+			lastFailure := provider.lastFailure
+			info.LastFailure = &lastFailure
+		}
+		providerStatus.Providers = append(providerStatus.Providers, info)
+	}
+	status.CountriesProvider = &providerStatus
+}
+
+// call runs `fn` against each provider in order, skipping providers in cooldown, until one succeeds.
+// If every provider is in cooldown they are all tried anyway, as a stale health check is better than no answer.
+func (f *FailoverRestCountries) call(fn func(mode restCountriesMode) error) error {
+	var lastErr error
+	for _, skipUnhealthy := range []bool{true, false} {
+		for i, provider := range f.providers {
+			if skipUnhealthy && !f.isHealthy(provider) {
+				continue
+			}
+			err := fn(provider.Mode)
+			if err == nil || !isProviderFailure(err) {
+				f.markSuccess(i)
+				return err
+			}
+			f.markFailure(provider, err)
+			lastErr = err
+		}
+		if lastErr != nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+// isHealthy returns true if the provider is not in cooldown
+func (f *FailoverRestCountries) isHealthy(provider *providerHealth) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return !time.Now().Before(provider.unhealthyUntil)
+}
+
+// markSuccess resets the health of the provider at `index`, and makes it the active provider
+func (f *FailoverRestCountries) markSuccess(index int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	provider := f.providers[index]
+	provider.failures = 0
+	provider.unhealthyUntil = time.Time{}
+
+	if f.active != index {
+		log.Printf("Countries provider switched from %s to %s", f.providers[f.active].Name, provider.Name)
+	}
+	f.active = index
+	if index == 0 {
+		f.reason = "primary provider is healthy"
+		return
+	}
+	// explain why the providers before this one were skipped
+	var reasons []string
+	for _, skipped := range f.providers[:index] {
+		reasons = append(reasons, skipped.Name+": "+skipped.lastError)
+	}
+	f.reason = "failed over due to " + strings.Join(reasons, "; ")
+}
+
+// markFailure puts the provider in cooldown and records the error
+func (f *FailoverRestCountries) markFailure(provider *providerHealth, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	provider.failures++
+	provider.lastError = err.Error()
+	provider.lastFailure = time.Now()
+	provider.unhealthyUntil = provider.lastFailure.Add(f.cooldown)
+}
+
+// isProviderFailure returns false for errors that are a valid answer from a healthy provider,
+// such as an unknown country, and true for errors that indicate that the provider is down
+func isProviderFailure(err error) bool {
+	if errors.Is(err, errCountryNotFound) {
+		return false
+	}
+	var statusErr *web_client.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
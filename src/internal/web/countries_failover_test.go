@@ -0,0 +1,77 @@
+package web
+
+import (
+	"assignment2/res"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailoverRestCountries(t *testing.T) {
+	primary := &countingCountries{}
+	chain := NewFailoverRestCountries(50*time.Millisecond,
+		CountriesProvider{Name: "primary", Mode: primary},
+		CountriesProvider{Name: "embedded", Mode: NewEmbeddedRestCountries(res.Embedded)},
+	)
+
+	// Test 1: the primary provider is used while it is healthy
+	if _, err := chain.getCountry("NOR"); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	status := APIStatus{}
+	chain.reportStatus(&status)
+	if status.CountriesProvider.Active != "primary" {
+		t.Fatal("expected primary to be active, got: ", status.CountriesProvider.Active)
+	}
+
+	// Test 2: the chain fails over when the primary is down, and reports why
+	primary.fail.Store(true)
+	country, err := chain.getCountry("NOR")
+	if err != nil || len(country.Borders) != 3 {
+		t.Fatal("expected embedded provider to answer, got error: ", err)
+	}
+	chain.reportStatus(&status)
+	if status.CountriesProvider.Active != "embedded" || !strings.Contains(status.CountriesProvider.Reason, "unavailable") {
+		t.Fatalf("unexpected provider status: %+v", status.CountriesProvider)
+	}
+	if status.CountriesProvider.Providers[0].Healthy {
+		t.Fatal("expected primary to be reported as unhealthy")
+	}
+
+	// Test 3: the primary is skipped during its cooldown
+	calls := primary.calls.Load()
+	_, _ = chain.getCountry("NOR")
+	if primary.calls.Load() != calls {
+		t.Fatal("expected primary to be skipped during cooldown")
+	}
+
+	// Test 4: the chain recovers when the primary is back after the cooldown
+	primary.fail.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	_, _ = chain.getCountry("NOR")
+	chain.reportStatus(&status)
+	if status.CountriesProvider.Active != "primary" {
+		t.Fatal("expected chain to recover to primary, got: ", status.CountriesProvider.Active)
+	}
+
+	// Test 5: an unknown country is an answer, not a provider failure
+	embeddedOnly := NewFailoverRestCountries(time.Minute,
+		CountriesProvider{Name: "embedded", Mode: NewEmbeddedRestCountries(res.Embedded)},
+		CountriesProvider{Name: "primary", Mode: primary},
+	)
+	if _, err := embeddedOnly.getCountry("XYZ"); err == nil {
+		t.Fatal("expected unknown country to return an error")
+	}
+	embeddedOnly.reportStatus(&status)
+	if status.CountriesProvider.Active != "embedded" || status.CountriesProvider.Providers[0].Failures != 0 {
+		t.Fatal("expected unknown country not to count as a provider failure")
+	}
+
+	// Test 6: providers can be parsed from a comma separated list
+	if _, err := ParseCountriesProviders("http://mirror.example.com, embedded", res.Embedded, time.Minute); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, err := ParseCountriesProviders(" , ", res.Embedded, time.Minute); err == nil {
+		t.Fatal("expected error when no providers are listed")
+	}
+}
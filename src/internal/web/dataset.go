@@ -0,0 +1,56 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"assignment2/internal/utils"
+	"net/http"
+)
+
+// AdminDatasetHandler handles the administration of the renewables dataset. It supports GET for the
+// version of the loaded dataset, and POST for reloading the dataset from its CSV file.
+func (s *State) AdminDatasetHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	segments := utils.GetSegments(r.URL, AdminDatasetPath)
+	if len(segments) > 0 {
+		http.Error(w, "Usage: "+AdminDatasetPath, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		httpRespondJSON(w, datasetInfo(s.getDataset()), nil)
+	case http.MethodPost:
+		reloadDataset(w, s)
+	default:
+		http.Error(w, "Only GET and POST Method is supported", http.StatusBadRequest)
+	}
+}
+
+// reloadDataset reads the CSV file the dataset was loaded from again, and replaces the dataset if it could
+// be read. When the contents have changed, the cached responses are invalidated, including those still
+// queued for the store, so that they are not written after the reload, and the threshold
+// registrations are evaluated against the new dataset. The reload is reported in the response.
+func reloadDataset(w http.ResponseWriter, s *State) {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+	dataset, err := types.ReadDataset(s.datasetPath)
+	if err != nil {
+		http.Error(w, "Could not reload the dataset: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	previous := s.dataset.Swap(&dataset)
+
+	response := DatasetReloadResponse{DatasetInfo: datasetInfo(&dataset), Changed: previous.Version != dataset.Version}
+	if response.Changed {
+		response.CacheInvalidated = s.cache.DeleteFunc(func(types.CacheEntry) bool { return true })
+		response.Triggered = s.evaluateThresholds(dataset.DB)
+	}
+	httpRespondJSON(w, response, nil)
+}
+
+// datasetInfo returns the version of a dataset, as reported by the dataset endpoint
+func datasetInfo(dataset *types.Dataset) DatasetInfo {
+	return DatasetInfo{Version: dataset.Version, LoadedAt: dataset.LoadedAt, Countries: len(dataset.DB)}
+}
@@ -0,0 +1,182 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"assignment2/internal/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DeadLetterHandler handles the webhooks that could not be delivered after every attempt. It supports GET
+// for listing the dead letters or viewing one by its ID, POST for replaying all dead letters or one by its
+// ID, and DELETE for discarding one by its ID.
+func (s *State) DeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	segments := utils.GetSegments(r.URL, DeadLettersPath)
+	if len(segments) > 1 {
+		http.Error(w, "Usage: "+DeadLettersPath+"{id?}", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if len(segments) == 0 {
+			httpRespondJSON(w, s.getAllDeadLetters(), nil)
+		} else if deadLetter, ok := s.getDeadLetter(segments[0]); ok {
+			httpRespondJSON(w, deadLetter, nil)
+		} else {
+			http.Error(w, "Could not find the dead letter ID: "+segments[0], http.StatusBadRequest)
+		}
+	case http.MethodPost:
+		replayDeadLetters(w, segments, s)
+	case http.MethodDelete:
+		if len(segments) == 0 {
+			http.Error(w, "Usage: "+DeadLettersPath+"{id}", http.StatusBadRequest)
+		} else if _, ok := s.removeDeadLetter(segments[0]); ok {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			http.Error(w, "Could not find the dead letter ID: "+segments[0], http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "Only GET, POST and DELETE Method is supported", http.StatusBadRequest)
+	}
+}
+
+// replayDeadLetters queues the dead letter with the ID in `segments` for the dispatcher again, or every
+// dead letter if there is no ID, oldest first. The replayed webhooks are removed from the dead letters, and
+// come back if they fail again. Dead letters that do not fit in the webhook queue are left for later.
+func replayDeadLetters(w http.ResponseWriter, segments []string, s *State) {
+	var ids []string
+	if len(segments) == 0 {
+		for _, deadLetter := range s.getAllDeadLetters() {
+			ids = append(ids, deadLetter.ID)
+		}
+	} else if _, ok := s.getDeadLetter(segments[0]); ok {
+		ids = segments
+	} else {
+		http.Error(w, "Could not find the dead letter ID: "+segments[0], http.StatusBadRequest)
+		return
+	}
+
+	response := ReplayResponse{}
+	for _, id := range ids {
+		if s.replayDeadLetter(id) {
+			response.Replayed++
+		}
+	}
+	response.Remaining = s.getNumberOfDeadLetters()
+	if response.Replayed == 0 && len(ids) > 0 {
+		http.Error(w, "The webhook queue is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	httpRespondJSONStatus(w, http.StatusAccepted, response)
+}
+
+// newDeadLetterID returns a random identifier for a dead letter
+func newDeadLetterID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// addDeadLetter keeps a webhook that the dispatcher could not deliver after every attempt, and journals
+// it to be written to the store by storeUpdateWorker
+func (s *State) addDeadLetter(event webhookEvent, err error) {
+	deadLetter := types.DeadLetter{
+		ID:        newDeadLetterID(),
+		WebhookID: event.Payload.WebhookID,
+		URL:       event.URL,
+		Country:   event.Payload.Country,
+		Calls:     event.Payload.Calls,
+		Type:      event.Payload.Type,
+		Crossing:  event.Payload.ThresholdCrossing,
+		Attempts:  event.Attempts,
+		Error:     err.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+	log.Printf("Could not deliver webhook %s after %d attempts, moved to dead letters: %s",
+		deadLetter.WebhookID, deadLetter.Attempts, deadLetter.Error)
+	s.lock.Lock()
+	s.deadLetters[deadLetter.ID] = deadLetter
+	s.lock.Unlock()
+	s.queueDeadLetter(types.DeadLetterAction{Add: true, DeadLetter: deadLetter})
+}
+
+// replayDeadLetter queues a dead letter for the dispatcher again, and removes it from the dead letters.
+// False is returned if the dead letter does not exist, or if the webhook queue is full.
+func (s *State) replayDeadLetter(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	deadLetter, ok := s.deadLetters[id]
+	if !ok {
+		return false
+	}
+	event := webhookEvent{URL: deadLetter.URL, Payload: WebhookResponse{
+		WebhookID:         deadLetter.WebhookID,
+		Type:              deadLetter.Type,
+		Country:           deadLetter.Country,
+		Calls:             deadLetter.Calls,
+		ThresholdCrossing: deadLetter.Crossing,
+	}}
+	if !s.dispatcher.enqueue(event) {
+		return false
+	}
+	delete(s.deadLetters, id)
+	s.queueDeadLetter(types.DeadLetterAction{Add: false, DeadLetter: deadLetter})
+	return true
+}
+
+// removeDeadLetter discards a dead letter, and returns it if it existed
+func (s *State) removeDeadLetter(id string) (types.DeadLetter, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	deadLetter, ok := s.deadLetters[id]
+	if ok {
+		delete(s.deadLetters, id)
+		s.queueDeadLetter(types.DeadLetterAction{Add: false, DeadLetter: deadLetter})
+	}
+	return deadLetter, ok
+}
+
+// getDeadLetter returns a dead letter by its ID
+func (s *State) getDeadLetter(id string) (types.DeadLetter, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	deadLetter, ok := s.deadLetters[id]
+	return deadLetter, ok
+}
+
+// getAllDeadLetters returns every dead letter, oldest first
+func (s *State) getAllDeadLetters() []types.DeadLetter {
+	s.lock.RLock()
+	list := make([]types.DeadLetter, 0, len(s.deadLetters))
+	for _, deadLetter := range s.deadLetters {
+		list = append(list, deadLetter)
+	}
+	s.lock.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].FailedAt.Equal(list[j].FailedAt) {
+			return list[i].FailedAt.Before(list[j].FailedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// getNumberOfDeadLetters returns the number of dead letters
+func (s *State) getNumberOfDeadLetters() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.deadLetters)
+}
+
+// queueDeadLetter journals a new or removed dead letter, to be written to the store by storeUpdateWorker.
+// Nothing is queued when running without a store.
+func (s *State) queueDeadLetter(action types.DeadLetterAction) {
+	if s.queue != nil {
+		s.queue.AddDeadLetter(action)
+	}
+}
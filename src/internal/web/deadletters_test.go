@@ -0,0 +1,93 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterHandler(t *testing.T) {
+	var down atomic.Bool
+	var received atomic.Int64
+	down.Store(true)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received.Add(1)
+	}))
+	defer receiver.Close()
+
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory},
+		WebhookRetryConfig(2, time.Millisecond), WarmupConfig(0, 0))
+	server := httptest.NewServer(SetupRoutes("8080", s))
+	defer server.Close()
+	s.newRegistration(types.InvocationRegistration{WebhookID: "abc", URL: receiver.URL, Country: "NOR", Calls: 1})
+
+	// Test 1: a webhook that fails on every attempt is moved to the dead letters, and written to the store
+	s.dispatcher.enqueue(webhookEvent{URL: receiver.URL, Payload: WebhookResponse{WebhookID: "abc", Country: "Norway", Calls: 1}})
+	waitForDeliveries(t, s)
+	var deadLetters []types.DeadLetter
+	HttpGetAndDecode(t, server.URL+DeadLettersPath, &deadLetters)
+	if len(deadLetters) != 1 || deadLetters[0].WebhookID != "abc" || deadLetters[0].Attempts != 2 || deadLetters[0].Calls != 1 {
+		t.Fatalf("expected a dead letter after 2 attempts, got: %+v", deadLetters)
+	}
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if stored, _ := s.store.GetAllDeadLetters(); len(stored) != 1 {
+		t.Fatal("expected the dead letter to be written to the store, got: ", stored)
+	}
+	if stats := s.getDispatcherStats(); stats.Failed != 1 || stats.Retried != 1 || stats.DeadLetters != 1 {
+		t.Fatalf("unexpected dispatcher stats: %+v", stats)
+	}
+
+	// Test 2: a dead letter can be viewed by its ID, and unknown IDs are rejected
+	var deadLetter types.DeadLetter
+	HttpGetAndDecode(t, server.URL+DeadLettersPath+deadLetters[0].ID, &deadLetter)
+	if deadLetter != deadLetters[0] {
+		t.Fatalf("expected the dead letter by its ID, got: %+v", deadLetter)
+	}
+	if status := HttpGetStatusCode(t, server.URL+DeadLettersPath+"missing"); status != http.StatusBadRequest {
+		t.Fatal("expected 400 for an unknown dead letter, got: ", status)
+	}
+
+	// Test 3: a replayed dead letter is delivered once the receiver is back, and removed from the dead letters
+	down.Store(false)
+	response, err := http.Post(server.URL+DeadLettersPath+deadLetter.ID, "application/json", nil)
+	if err != nil {
+		t.Fatal("Request to URL failed:", err.Error())
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatal("expected 202 when replaying, got: ", response.StatusCode)
+	}
+	waitForDeliveries(t, s)
+	if received.Load() != 1 || s.getNumberOfDeadLetters() != 0 {
+		t.Fatal("expected the replayed webhook to be delivered, got: ", received.Load(), s.getAllDeadLetters())
+	}
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if stored, _ := s.store.GetAllDeadLetters(); len(stored) != 0 {
+		t.Fatal("expected the replayed dead letter to be deleted from the store, got: ", stored)
+	}
+
+	// Test 4: dead letters can be discarded
+	s.addDeadLetter(webhookEvent{URL: receiver.URL, Payload: WebhookResponse{WebhookID: "abc", Calls: 2}, Attempts: 2}, errors.New("timeout"))
+	id := s.getAllDeadLetters()[0].ID
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+DeadLettersPath+id, nil)
+	if response, err := http.DefaultClient.Do(req); err != nil || response.StatusCode != http.StatusAccepted {
+		t.Fatal("expected 202 when discarding a dead letter, got: ", response, err)
+	}
+	if s.getNumberOfDeadLetters() != 0 {
+		t.Fatal("expected the dead letter to be discarded, got: ", s.getAllDeadLetters())
+	}
+}
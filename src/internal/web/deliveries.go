@@ -0,0 +1,126 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"context"
+	"net/http"
+	"time"
+)
+
+// deliveryRing holds the most recent delivery attempts of a registration. Once it is full, every new
+// attempt replaces the oldest one.
+type deliveryRing struct {
+	deliveries []types.Delivery
+	next       int // index of the oldest attempt once the ring is full
+}
+
+// newDeliveryRing returns a ring keeping `size` attempts, filled with `deliveries` in order
+func newDeliveryRing(size int, deliveries []types.Delivery) *deliveryRing {
+	if size < 1 {
+		size = 1
+	}
+	ring := &deliveryRing{deliveries: make([]types.Delivery, 0, size)}
+	for _, delivery := range deliveries {
+		ring.add(delivery)
+	}
+	return ring
+}
+
+// add adds an attempt, replacing the oldest one if the ring is full
+func (r *deliveryRing) add(delivery types.Delivery) {
+	if len(r.deliveries) < cap(r.deliveries) {
+		r.deliveries = append(r.deliveries, delivery)
+		return
+	}
+	r.deliveries[r.next] = delivery
+	r.next = (r.next + 1) % len(r.deliveries)
+}
+
+// list returns a copy of the attempts, oldest first
+func (r *deliveryRing) list() []types.Delivery {
+	list := make([]types.Delivery, 0, len(r.deliveries))
+	list = append(list, r.deliveries[r.next:]...)
+	return append(list, r.deliveries[:r.next]...)
+}
+
+// listDeliveries is a function that sends the most recent delivery attempts of a registration, oldest
+// first, as a JSON response to the client if the registration is found, otherwise it sends an error.
+func listDeliveries(w http.ResponseWriter, webhookID string, s *State) {
+	if deliveries, ok := s.getDeliveries(webhookID); ok {
+		httpRespondJSON(w, deliveries, nil)
+		return
+	}
+	http.Error(w, "Could not find the webhook ID: "+webhookID, http.StatusBadRequest)
+}
+
+// deliverWebhook posts the webhook of the event for the dispatcher, signed with the secrets of its
+// registration at the time of the attempt, and records the attempt in the delivery log of the registration.
+// Webhooks of registrations that have been deleted, such as retries and replayed dead letters, are not
+// posted, as they could not be signed, and errRegistrationDeleted is returned instead.
+func (s *State) deliverWebhook(ctx context.Context, event webhookEvent) error {
+	start := time.Now()
+	registration, ok := s.getRegistration(event.Payload.WebhookID)
+	if !ok {
+		return errRegistrationDeleted
+	}
+	status, err := postToWebhook(ctx, event.URL, event.Payload, registration.SigningSecrets(start))
+	delivery := types.Delivery{
+		Time: start.UTC(),
+		Payload: types.DeliveryPayload{
+			WebhookID: event.Payload.WebhookID,
+			Type:      event.Payload.Type,
+			Country:   event.Payload.Country,
+			Calls:     event.Payload.Calls,
+			Crossing:  event.Payload.ThresholdCrossing,
+		},
+		Status:  status,
+		Latency: time.Since(start).Milliseconds(),
+		Attempt: event.Attempts + 1,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	s.recordDelivery(delivery)
+	return err
+}
+
+// recordDelivery adds an attempt to the delivery log of its registration, and journals the log to be
+// written to the store by storeUpdateWorker. Attempts for registrations that have been deleted are not
+// recorded, so that their logs are not written again.
+func (s *State) recordDelivery(delivery types.Delivery) {
+	webhookID := delivery.Payload.WebhookID
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.registrations[webhookID]; !ok {
+		return
+	}
+	ring, ok := s.deliveries[webhookID]
+	if !ok {
+		ring = newDeliveryRing(s.deliveryLogSize, nil)
+		s.deliveries[webhookID] = ring
+	}
+	ring.add(delivery)
+	if s.queue != nil {
+		s.queue.AddDeliveries(types.DeliveryLog{WebhookID: webhookID, Deliveries: ring.list()})
+	}
+}
+
+// getDeliveries returns the most recent delivery attempts of a registration, oldest first, and whether
+// the registration exists
+func (s *State) getDeliveries(webhookID string) ([]types.Delivery, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if _, ok := s.registrations[webhookID]; !ok {
+		return nil, false
+	}
+	if ring, ok := s.deliveries[webhookID]; ok {
+		return ring.list(), true
+	}
+	return []types.Delivery{}, true
+}
+
+// setDeliveriesLocked replaces the delivery log of a registration with one loaded from the store or
+// replayed from the journal. The caller must hold the lock.
+func (s *State) setDeliveriesLocked(deliveries types.DeliveryLog) {
+	s.deliveries[deliveries.WebhookID] = newDeliveryRing(s.deliveryLogSize, deliveries.Deliveries)
+}
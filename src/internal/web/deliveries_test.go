@@ -0,0 +1,99 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliveries(t *testing.T) {
+	var requests atomic.Int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request fails, and is retried
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory},
+		WebhookRetryConfig(2, time.Millisecond), DeliveryLogConfig(3), WarmupConfig(0, 0))
+	server := httptest.NewServer(SetupRoutes("8080", s))
+	defer server.Close()
+	s.newRegistration(types.InvocationRegistration{WebhookID: "abc", URL: receiver.URL, Country: "NOR", Calls: 1})
+	s.newRegistration(types.InvocationRegistration{WebhookID: "def", URL: receiver.URL, Country: "SWE", Calls: 1})
+	enqueue := func(calls int64) {
+		s.dispatcher.enqueue(webhookEvent{URL: receiver.URL, Payload: WebhookResponse{WebhookID: "abc", Country: "Norway", Calls: calls}})
+		waitForDeliveries(t, s)
+	}
+
+	// Test 1: every attempt is logged with its status, error and attempt number
+	enqueue(1)
+	var deliveries []types.Delivery
+	HttpGetAndDecode(t, server.URL+NotificationsPath+"abc/deliveries", &deliveries)
+	if len(deliveries) != 2 || deliveries[0].Status != http.StatusServiceUnavailable || deliveries[0].Error == "" ||
+		deliveries[0].Attempt != 1 || deliveries[1].Status != http.StatusOK || deliveries[1].Error != "" || deliveries[1].Attempt != 2 {
+		t.Fatalf("expected a failed and a successful attempt, got: %+v", deliveries)
+	}
+	if deliveries[1].Payload != (types.DeliveryPayload{WebhookID: "abc", Country: "Norway", Calls: 1}) || deliveries[1].Time.IsZero() {
+		t.Fatalf("expected the payload and time of the attempt, got: %+v", deliveries[1])
+	}
+
+	// Test 2: only the most recent attempts are kept, oldest first, and written to the store
+	enqueue(2)
+	enqueue(3)
+	HttpGetAndDecode(t, server.URL+NotificationsPath+"abc/deliveries", &deliveries)
+	calls := make([]int64, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		calls = append(calls, delivery.Payload.Calls)
+	}
+	if !reflect.DeepEqual(calls, []int64{1, 2, 3}) || deliveries[0].Attempt != 2 {
+		t.Fatalf("expected the 3 most recent attempts, got: %+v", deliveries)
+	}
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if stored, _ := s.store.GetAllDeliveries(); len(stored["abc"].Deliveries) != 3 {
+		t.Fatal("expected the delivery log to be written to the store, got: ", stored)
+	}
+
+	// Test 3: registrations without attempts have an empty log, and unknown registrations are rejected
+	HttpGetAndDecode(t, server.URL+NotificationsPath+"def/deliveries", &deliveries)
+	if deliveries == nil || len(deliveries) != 0 {
+		t.Fatal("expected an empty delivery log, got: ", deliveries)
+	}
+	if status := HttpGetStatusCode(t, server.URL+NotificationsPath+"missing/deliveries"); status != http.StatusBadRequest {
+		t.Fatal("expected 400 for an unknown webhook, got: ", status)
+	}
+
+	// Test 4: the delivery log is deleted together with its registration
+	if err := s.deleteRegistration("abc"); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if stored, _ := s.store.GetAllDeliveries(); len(stored) != 0 {
+		t.Fatal("expected the delivery log to be deleted from the store, got: ", stored)
+	}
+
+	// Test 5: webhooks of a deleted registration are discarded rather than posted unsigned or dead-lettered
+	before := requests.Load()
+	enqueue(4)
+	if stats := s.getDispatcherStats(); requests.Load() != before || stats.Discarded != 1 || stats.DeadLetters != 0 {
+		t.Fatalf("expected the webhook to be discarded, got %d requests and: %+v", requests.Load()-before, stats)
+	}
+
+	// Test 6: a log loaded from the store keeps its most recent attempts when it is larger than the ring
+	ring := newDeliveryRing(2, []types.Delivery{{Attempt: 1}, {Attempt: 2}, {Attempt: 3}})
+	ring.add(types.Delivery{Attempt: 4})
+	if list := ring.list(); len(list) != 2 || list[0].Attempt != 3 || list[1].Attempt != 4 {
+		t.Fatalf("expected the 2 most recent attempts, got: %+v", list)
+	}
+}
@@ -0,0 +1,314 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// errRegistrationDeleted is returned by the post function of the dispatcher for events whose registration
+// has been deleted since they were triggered. The events are discarded instead of being retried.
+var errRegistrationDeleted = errors.New("the registration of the webhook has been deleted")
+
+// webhookEvent is a webhook waiting to be posted to the URL of its registration
+type webhookEvent struct {
+	URL      string
+	Payload  WebhookResponse
+	Attempts int // failed attempts to post the webhook so far
+}
+
+// host returns the host the event is posted to, which concurrent deliveries are limited by
+func (e webhookEvent) host() string {
+	if u, err := url.Parse(e.URL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return e.URL
+}
+
+// hostState holds the deliveries to a single host. Events wait for the host when it has as many
+// deliveries in flight as allowed, or when an earlier event of the same webhook is in flight or
+// waiting to be retried, so that the webhooks of a registration are posted in order.
+type hostState struct {
+	active   int
+	webhooks map[string]bool // webhooks with a delivery in flight or waiting to be retried
+	waiting  []webhookEvent
+}
+
+// retry is a failed delivery waiting for its backoff to expire
+type retry struct {
+	event webhookEvent
+	err   error
+	timer *time.Timer
+}
+
+// dispatcher posts webhooks through a fixed number of workers. Events are taken from a bounded queue,
+// and dropped when it is full, so that slow receivers hold back neither the requests that invocate
+// them nor the memory of the service. Deliveries are limited per host, and a host at its limit does
+// not hold back the events for other hosts. Failed deliveries are retried with exponential backoff,
+// and handed to `deadLetter` once every attempt has failed.
+type dispatcher struct {
+	ctx         context.Context // cancelled to abort deliveries on shutdown
+	post        func(ctx context.Context, event webhookEvent) error
+	deadLetter  func(event webhookEvent, err error)
+	ready       chan webhookEvent // events that have been given a slot of their host, in the order they were enqueued
+	workers     sync.WaitGroup
+	capacity    int
+	hostLimit   int
+	maxAttempts int
+	backoff     time.Duration
+	lock        sync.Mutex
+	hosts       map[string]*hostState
+	retries     map[*retry]bool
+	closed      bool
+	drained     bool // whether the ready channel has been closed
+	stats       WebhookDispatcherStats
+}
+
+// newDispatcher starts `workers` workers posting the webhooks enqueued on the dispatcher, with at most
+// `capacity` events waiting to be delivered and `hostLimit` deliveries in flight to the same host. A
+// webhook is posted at most `maxAttempts` times, waiting about `backoff` before the first retry and
+// twice as long before every further retry, before it is handed to `deadLetter`.
+func newDispatcher(ctx context.Context, workers int, capacity int, hostLimit int, maxAttempts int,
+	backoff time.Duration, deadLetter func(event webhookEvent, err error)) *dispatcher {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if hostLimit < 1 {
+		hostLimit = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	d := &dispatcher{
+		ctx:        ctx,
+		post:       postEvent,
+		deadLetter: deadLetter,
+		// failed deliveries are queued again when they are retried, so the channel also needs room for
+		// the events that are in flight
+		ready:       make(chan webhookEvent, capacity+workers),
+		capacity:    capacity,
+		hostLimit:   hostLimit,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		hosts:       map[string]*hostState{},
+		retries:     map[*retry]bool{},
+	}
+	d.stats.Workers, d.stats.Capacity, d.stats.HostLimit, d.stats.MaxAttempts = workers, capacity, hostLimit, maxAttempts
+	d.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// postEvent posts the payload of the event to its URL, without signing it
+func postEvent(ctx context.Context, event webhookEvent) error {
+	_, err := postToWebhook(ctx, event.URL, event.Payload, nil)
+	return err
+}
+
+// enqueue queues the event for delivery, and returns false if it was dropped because the queue is
+// full or the dispatcher is closed. It never blocks.
+func (d *dispatcher) enqueue(event webhookEvent) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed || d.stats.Pending >= d.capacity {
+		d.stats.Dropped++
+		return false
+	}
+	d.stats.Enqueued++
+	d.stats.Pending++
+
+	host, ok := d.hosts[event.host()]
+	if !ok {
+		host = &hostState{webhooks: map[string]bool{}}
+		d.hosts[event.host()] = host
+	}
+	if host.active >= d.hostLimit || host.webhooks[event.Payload.WebhookID] {
+		host.waiting = append(host.waiting, event)
+		return true
+	}
+	host.active++
+	host.webhooks[event.Payload.WebhookID] = true
+	d.ready <- event
+	return true
+}
+
+// work delivers the events that are ready, and then the events that were waiting for their host
+func (d *dispatcher) work() {
+	defer d.workers.Done()
+	for event := range d.ready {
+		d.begin()
+		for ok := true; ok; {
+			err := d.post(d.ctx, event)
+			next, more, failed := d.finish(event, err)
+			if failed && d.deadLetter != nil {
+				event.Attempts++
+				d.deadLetter(event, err)
+			}
+			event, ok = next, more
+		}
+	}
+}
+
+// begin counts a delivery that a worker has started
+func (d *dispatcher) begin() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stats.Pending--
+	d.stats.InFlight++
+}
+
+// finish counts a delivery that has finished, and releases its slot of the host. A failed delivery is
+// scheduled to be retried, unless it was the last attempt or the dispatcher is closed, in which case
+// `failed` is true for the event to be dead-lettered. The first event waiting for the host that can be
+// delivered now is returned with `ok`, to be delivered by the same worker.
+func (d *dispatcher) finish(event webhookEvent, err error) (next webhookEvent, ok bool, failed bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stats.InFlight--
+	name := event.host()
+	host := d.hosts[name]
+	host.active--
+	switch {
+	case err == nil:
+		d.stats.Delivered++
+		delete(host.webhooks, event.Payload.WebhookID)
+	case errors.Is(err, errRegistrationDeleted):
+		d.stats.Discarded++
+		delete(host.webhooks, event.Payload.WebhookID)
+	case event.Attempts+1 < d.maxAttempts && !d.closed:
+		// the webhook stays in flight, so that its later events wait for the retry
+		event.Attempts++
+		r := &retry{event: event, err: err}
+		r.timer = time.AfterFunc(d.delay(event.Attempts), func() { d.resume(r) })
+		d.retries[r] = true
+		d.stats.Retried++
+		d.stats.Pending++
+	default:
+		d.stats.Failed++
+		delete(host.webhooks, event.Payload.WebhookID)
+		failed = true
+	}
+
+	for i, waiting := range host.waiting {
+		// a retry holds the place of its webhook, and is the only event of the webhook that may be delivered
+		if host.webhooks[waiting.Payload.WebhookID] && waiting.Attempts == 0 {
+			continue
+		}
+		host.waiting = append(host.waiting[:i], host.waiting[i+1:]...)
+		host.active++
+		host.webhooks[waiting.Payload.WebhookID] = true
+		d.stats.Pending--
+		d.stats.InFlight++
+		return waiting, true, failed
+	}
+	d.forgetLocked(name, host)
+	d.closeIfDrainedLocked()
+	return webhookEvent{}, false, failed
+}
+
+// resume queues a retry once its backoff has expired, ahead of the events waiting for its host
+func (d *dispatcher) resume(r *retry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.retries[r] {
+		// the retry was dead-lettered when the dispatcher was closed
+		return
+	}
+	delete(d.retries, r)
+	host := d.hosts[r.event.host()]
+	if host.active >= d.hostLimit {
+		host.waiting = append([]webhookEvent{r.event}, host.waiting...)
+		return
+	}
+	host.active++
+	d.ready <- r.event
+}
+
+// forgetLocked forgets a host without deliveries. The caller must hold the lock.
+func (d *dispatcher) forgetLocked(name string, host *hostState) {
+	if host.active == 0 && len(host.waiting) == 0 && len(host.webhooks) == 0 {
+		delete(d.hosts, name)
+	}
+}
+
+// closeIfDrainedLocked closes the ready channel, so that the workers return, once the dispatcher is
+// closed and every event has been delivered or dead-lettered. The caller must hold the lock.
+func (d *dispatcher) closeIfDrainedLocked() {
+	if d.closed && !d.drained && d.stats.Pending == 0 && d.stats.InFlight == 0 {
+		d.drained = true
+		close(d.ready)
+	}
+}
+
+// delay returns the exponential backoff with full jitter before the retry after `attempts` failed attempts
+func (d *dispatcher) delay(attempts int) time.Duration {
+	backoff := d.backoff << (attempts - 1)
+	if backoff <= 0 || backoff > WebhookMaxBackoff {
+		backoff = WebhookMaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// close stops accepting events. The events waiting to be retried are dead-lettered right away, and the
+// workers return once the other events have been delivered, which can be waited for with d.workers.
+func (d *dispatcher) close() {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+	d.closed = true
+	var dead []*retry
+	for r := range d.retries {
+		r.timer.Stop()
+		delete(d.retries, r)
+		dead = append(dead, r)
+		d.stats.Pending--
+		d.stats.Failed++
+
+		// the events of the webhook that were waiting for the retry can be delivered now
+		name := r.event.host()
+		host := d.hosts[name]
+		delete(host.webhooks, r.event.Payload.WebhookID)
+		d.admitLocked(host)
+		d.forgetLocked(name, host)
+	}
+	d.closeIfDrainedLocked()
+	d.lock.Unlock()
+
+	if d.deadLetter != nil {
+		for _, r := range dead {
+			d.deadLetter(r.event, r.err)
+		}
+	}
+}
+
+// admitLocked queues the events waiting for the host that can be delivered now. The caller must hold the lock.
+func (d *dispatcher) admitLocked(host *hostState) {
+	for i := 0; i < len(host.waiting) && host.active < d.hostLimit; {
+		next := host.waiting[i]
+		if host.webhooks[next.Payload.WebhookID] && next.Attempts == 0 {
+			i++
+			continue
+		}
+		host.waiting = append(host.waiting[:i], host.waiting[i+1:]...)
+		host.active++
+		host.webhooks[next.Payload.WebhookID] = true
+		d.ready <- next
+	}
+}
+
+// getStats returns the counters of the dispatcher
+func (d *dispatcher) getStats() WebhookDispatcherStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	stats := d.stats
+	stats.Hosts = len(d.hosts)
+	stats.Retrying = len(d.retries)
+	return stats
+}
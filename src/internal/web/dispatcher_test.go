@@ -0,0 +1,158 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForDeliveries waits until the dispatchers of the services have delivered every queued webhook
+func waitForDeliveries(t *testing.T, services ...*State) {
+	for _, s := range services {
+		waitForDispatcher(t, s.dispatcher)
+	}
+}
+
+// waitForDispatcher waits until the dispatcher has delivered or dead-lettered every queued webhook
+func waitForDispatcher(t *testing.T, d *dispatcher) {
+	deadline := time.Now().Add(5 * time.Second)
+	for stats := d.getStats(); stats.Pending > 0 || stats.InFlight > 0; stats = d.getStats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("webhooks not delivered in time: %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	event := func(host string, webhookID string, calls int64) webhookEvent {
+		return webhookEvent{URL: "http://" + host + "/hook", Payload: WebhookResponse{WebhookID: webhookID, Calls: calls}}
+	}
+
+	// Test 1: events beyond the capacity are dropped instead of blocking
+	d := newDispatcher(context.Background(), 0, 2, 1, 1, 0, nil)
+	for i := 0; i < 3; i++ {
+		d.enqueue(event("example.com", "a", int64(i)))
+	}
+	if stats := d.getStats(); stats.Enqueued != 2 || stats.Dropped != 1 || stats.Pending != 2 {
+		t.Fatalf("expected 2 queued events and 1 dropped, got: %+v", stats)
+	}
+	d.close()
+	if d.enqueue(event("example.com", "a", 3)) {
+		t.Fatal("expected events to be dropped once the dispatcher is closed")
+	}
+
+	// Test 2: deliveries to a host are limited, without holding back the other hosts, and the
+	// webhooks of a registration are delivered in order
+	var lock sync.Mutex
+	active := map[string]int{}
+	busiest := map[string]int{}
+	delivered := map[string][]int64{}
+	release := make(chan struct{})
+	d = newDispatcher(context.Background(), 4, 10, 2, 1, 0, nil)
+	d.post = func(ctx context.Context, e webhookEvent) error {
+		host := e.host()
+		lock.Lock()
+		active[host]++
+		if active[host] > busiest[host] {
+			busiest[host] = active[host]
+		}
+		lock.Unlock()
+		if host == "slow.example.com" {
+			<-release
+		}
+		lock.Lock()
+		active[host]--
+		delivered[e.Payload.WebhookID] = append(delivered[e.Payload.WebhookID], e.Payload.Calls)
+		lock.Unlock()
+		return nil
+	}
+	for i := int64(1); i <= 3; i++ {
+		d.enqueue(event("slow.example.com", "a", i))
+		d.enqueue(event("slow.example.com", "b", i))
+	}
+	d.enqueue(event("fast.example.com", "c", 1))
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		stats := d.getStats()
+		if stats.Delivered == 1 && stats.InFlight == 2 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected the fast host to be delivered while the slow host is busy, got: %+v", stats)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if stats := d.getStats(); stats.InFlight != 2 || stats.Pending != 4 || stats.Hosts != 1 {
+		t.Fatalf("expected 2 deliveries in flight and 4 waiting for the slow host, got: %+v", stats)
+	}
+	close(release)
+	d.close()
+	d.workers.Wait()
+	if busiest["slow.example.com"] != 2 {
+		t.Fatal("expected at most 2 concurrent deliveries to the slow host, got: ", busiest["slow.example.com"])
+	}
+	if !reflect.DeepEqual(delivered["a"], []int64{1, 2, 3}) || !reflect.DeepEqual(delivered["b"], []int64{1, 2, 3}) {
+		t.Fatal("expected the webhooks of a registration in order, got: ", delivered)
+	}
+	if stats := d.getStats(); stats.Delivered != 7 || stats.Pending != 0 || stats.Hosts != 0 {
+		t.Fatalf("expected every event to be delivered, got: %+v", stats)
+	}
+
+	// Test 3: failed deliveries are retried in order, and dead-lettered once every attempt has failed
+	attempts := map[int64]int{}
+	delivered = map[string][]int64{}
+	var dead []webhookEvent
+	d = newDispatcher(context.Background(), 2, 10, 2, 3, time.Millisecond, func(e webhookEvent, err error) {
+		lock.Lock()
+		dead = append(dead, e)
+		lock.Unlock()
+	})
+	d.post = func(ctx context.Context, e webhookEvent) error {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts[e.Payload.Calls]++
+		// the first event succeeds on the last attempt, the second never does
+		if e.Payload.Calls == 2 || attempts[e.Payload.Calls] < 3 && e.Payload.Calls == 1 {
+			return errors.New("503 Service Unavailable")
+		}
+		delivered[e.Payload.WebhookID] = append(delivered[e.Payload.WebhookID], e.Payload.Calls)
+		return nil
+	}
+	for i := int64(1); i <= 3; i++ {
+		d.enqueue(event("example.com", "a", i))
+	}
+	waitForDispatcher(t, d)
+	d.close()
+	d.workers.Wait()
+	if !reflect.DeepEqual(delivered["a"], []int64{1, 3}) || len(dead) != 1 || dead[0].Payload.Calls != 2 || dead[0].Attempts != 3 {
+		t.Fatalf("expected 1 and 3 to be delivered in order, and 2 to be dead-lettered after 3 attempts, got: %v %+v", delivered, dead)
+	}
+	if stats := d.getStats(); stats.Retried != 4 || stats.Failed != 1 || stats.Delivered != 2 || stats.Retrying != 0 {
+		t.Fatalf("unexpected stats after retries: %+v", stats)
+	}
+
+	// Test 4: retries waiting for their backoff are dead-lettered right away when the dispatcher is closed
+	dead = nil
+	d = newDispatcher(context.Background(), 1, 10, 1, 5, time.Hour, func(e webhookEvent, err error) {
+		lock.Lock()
+		dead = append(dead, e)
+		lock.Unlock()
+	})
+	d.post = func(ctx context.Context, e webhookEvent) error {
+		return errors.New("timeout")
+	}
+	d.enqueue(event("example.com", "a", 1))
+	for start := time.Now(); d.getStats().Retrying == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected the failed delivery to wait for a retry")
+		}
+	}
+	d.close()
+	d.workers.Wait()
+	if len(dead) != 1 || dead[0].Attempts != 1 {
+		t.Fatalf("expected the retry to be dead-lettered on close, got: %+v", dead)
+	}
+}
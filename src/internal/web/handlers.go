@@ -27,31 +27,20 @@ func (s *State) EnergyCurrentHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 
-		// Checking cache first
-		if cache, err := s.firestoreMode.GetCacheFromFirebase(r.URL); err == nil {
-			httpRespondJSON(w, cache, s)
+		query, err := parseCurrentQuery(r.URL)
+		if err != nil {
+			http.Error(w, err.Error()+". Usage: {country?}{?neighbours=bool?}", http.StatusBadRequest)
 			return
 		}
 
-		segments := utils.GetSegments(r.URL, RenewablesCurrentPath)
-		neighbours, _ := utils.GetQueryStr(r.URL, "neighbours")
-
-		switch len(segments) {
-		case 0:
-			// Return the latest data for all countries
-			httpCacheAndRespondJSON(w, r.URL, s.getCurrentRenewable("", false), s)
-		case 1:
-			returnData := s.getCurrentRenewable(segments[0], neighbours == "true")
-			switch len(returnData) {
-			case 0:
-				// Return the latest data for a specific country
-				http.Error(w, "Could not find specified country code", http.StatusBadRequest)
-			default:
-				httpCacheAndRespondJSON(w, r.URL, returnData, s)
-			}
-		default:
-			http.Error(w, "Usage: {country?}{?neighbours=bool?}", http.StatusBadRequest)
+		// Returns the latest data for all countries, or for a specific country. The response is
+		// taken from the cache if available, and identical concurrent requests are computed once.
+		returnData := s.getRenewables(query)
+		if len(query.country) > 0 && len(returnData) == 0 {
+			http.Error(w, "Could not find specified country code", http.StatusBadRequest)
+			return
 		}
+		httpRespondRenewables(w, r, query, returnData, s)
 	default:
 		http.Error(w, "Only GET Method is supported", http.StatusBadRequest)
 	}
@@ -63,32 +52,21 @@ func (s *State) EnergyHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 
-		// Check cache first
-		if cache, err := s.firestoreMode.GetCacheFromFirebase(r.URL); err == nil {
-			httpRespondJSON(w, cache, s)
+		query, err := parseHistoryQuery(r.URL)
+		if err != nil {
+			http.Error(w, err.Error()+". Usage: {country?}{?begin=year&end=year&sortByValue=bool?}", http.StatusBadRequest)
 			return
 		}
 
-		segments := utils.GetSegments(r.URL, RenewablesHistoryPath)
-		begin, _ := utils.GetQueryInt(r.URL, "begin")
-		end, _ := utils.GetQueryInt(r.URL, "end")
-		sort, _ := utils.GetQueryStr(r.URL, "sortByValue")
-
-		switch len(segments) {
-		case 0:
-			// Return the historical average data for all countries
-			httpCacheAndRespondJSON(w, r.URL, s.db.GetHistoricAvg(begin, end, sort == "true"), s)
-		case 1:
-			// Return the historical data for a specific country
-			returnData := s.db.GetHistoric(segments[0], begin, end, sort == "true")
-			if len(returnData) > 0 {
-				httpCacheAndRespondJSON(w, r.URL, returnData, s)
-			} else {
-				http.Error(w, "Could not find specified country code", http.StatusBadRequest)
-			}
-		default:
-			http.Error(w, "Usage: {country?}{?neighbours=bool?}", http.StatusBadRequest)
+		// Returns the historical average data for all countries, or the historical data for a
+		// specific country. The response is taken from the cache if available, and identical
+		// concurrent requests are computed once.
+		returnData := s.getRenewables(query)
+		if len(query.country) > 0 && len(returnData) == 0 {
+			http.Error(w, "Could not find specified country code", http.StatusBadRequest)
+			return
 		}
+		httpRespondRenewables(w, r, query, returnData, s)
 	default:
 		http.Error(w, "Only GET Method is supported", http.StatusBadRequest)
 	}
@@ -108,14 +86,28 @@ func (s *State) NotificationHandler(w http.ResponseWriter, r *http.Request) {
 		case 1:
 			// List a specific webhook by its ID
 			ListWebhooksByID(w, segments[0], s)
+		case 2:
+			if segments[1] != "deliveries" {
+				http.Error(w, "Usage: "+NotificationsPath+"{?webhook_id}/{?deliveries}", http.StatusBadRequest)
+				return
+			}
+			// List the most recent delivery attempts of a webhook by its ID
+			listDeliveries(w, segments[0], s)
 		default:
-			http.Error(w, "Usage: "+NotificationsPath+"{?webhook_id}", http.StatusBadRequest)
+			http.Error(w, "Usage: "+NotificationsPath+"{?webhook_id}/{?deliveries}", http.StatusBadRequest)
 		}
 	case http.MethodPost:
 		switch len(segments) {
 		case 0:
 			// Register a new webhook
 			registerWebhook(w, r, s)
+		case 2:
+			if segments[1] != "secret" {
+				http.Error(w, "Usage: "+NotificationsPath+"{webhook_id}/secret", http.StatusBadRequest)
+				return
+			}
+			// Rotate the secret of a webhook by its ID
+			rotateSecret(w, r, segments[0], s)
 		default:
 			http.Error(w, "Expected POST in JSON on "+NotificationsPath, http.StatusBadRequest)
 		}
@@ -142,14 +134,27 @@ func (s *State) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		switch len(segments) {
 		case 0:
+			// Check the store with a lightweight read
+			notificationDB, storeHealth := s.getNotificationDBStatus()
+
 			// Create a struct to hold the API status information
-			httpRespondJSON(w, APIStatus{
-				Countriesapi:    s.countriesAPIMode.getRestCountriesStatus(), // HTTP status code for *REST Countries API*
-				Notification_db: s.firestoreMode.getNotificationDBStatus(),   // HTTP status code for *Notification DB* in Firebase
-				Webhooks:        s.getNumberOfRegistrations(),                // Number of registered webhooks
-				Version:         Version,                                     // API version
-				Uptime:          utils.GetUptime(),                           // Uptime in seconds since the last service restart
-			}, s)
+			status := APIStatus{
+				Countriesapi:      s.countriesAPIMode.getRestCountriesStatus(), // HTTP status code for *REST Countries API*
+				Notification_db:   notificationDB,                              // HTTP status code for *Notification DB* in the store
+				Store:             storeHealth,                                 // Outcome and latency of the store health check
+				WriteQueue:        s.getQueueStats(),                           // Updates waiting to be written to the store
+				Webhooks:          s.getNumberOfRegistrations(),                // Number of registered webhooks
+				Version:           Version,                                     // API version
+				Uptime:            utils.GetUptime(),                           // Uptime in seconds since the last service restart
+				CacheSweeper:      s.getSweepStats(),                           // Result of the latest purge of expired cache entries
+				Snapshots:         s.getSnapshotStats(),                        // Result of the latest scheduled snapshot of the store
+				WebhookDispatcher: s.getDispatcherStats(),                      // Counters of the webhook dispatcher
+			}
+			// Let the countries mode add details such as cache counters
+			if reporter, ok := s.countriesAPIMode.(statusReporter); ok {
+				reporter.reportStatus(&status)
+			}
+			httpRespondJSON(w, status, s)
 		default:
 			// Handle any other cases with URL segments
 			http.Error(w, "Usage: energy/v1/status/", http.StatusBadRequest)
@@ -159,3 +164,20 @@ func (s *State) StatusHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Only GET Method is supported", http.StatusBadRequest)
 	}
 }
+
+// ReadinessHandler serves the readiness endpoint, which responds with 503 Service Unavailable while
+// the cache is being warmed up, and 200 OK once the service is ready to receive traffic. The body
+// reports the warm-up progress.
+func (s *State) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := s.getWarmupStatus()
+		if !status.Ready {
+			httpRespondJSONStatus(w, http.StatusServiceUnavailable, status)
+			return
+		}
+		httpRespondJSON(w, status, nil)
+	default:
+		http.Error(w, "Only GET Method is supported", http.StatusBadRequest)
+	}
+}
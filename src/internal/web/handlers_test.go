@@ -1,29 +1,24 @@
 package web
 
 import (
+	"assignment2/internal/signing"
+	"assignment2/internal/store"
 	"assignment2/internal/types"
+	"assignment2/res"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path"
-	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 const (
 	testWithFirestore = false
 )
 
-func TestSetup(t *testing.T) {
-	wd, _ := os.Getwd()
-	if err := os.Chdir(filepath.Join(wd, "..", "..")); err != nil {
-		t.Fatal("Could not change working directory. CSV file will probably not be found")
-	}
-}
-
 func TestEnergyDefaultHandler(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(DefaultHandler))
 	defer server.Close()
@@ -70,8 +65,7 @@ func TestEnergyCurrentHandler(t *testing.T) {
 		// Test 3: Verify that the API returns more than one neighboring country for Norway when the 'neighbours' query parameter is set to 'true'.
 		HttpGetAndDecode(t, server.URL+RenewablesCurrentPath+"nor?neighbours=true", &dataList)
 		if len(dataList) <= 1 {
-			t.Fatal("Expected more than 1 country, got :", len(dataList),
-				"Have you remembered to start the stub service at /cmd/stub/stub_countries_api.go ?")
+			t.Fatal("Expected more than 1 country, got :", len(dataList))
 		}
 
 		// Status codes tests:
@@ -95,9 +89,9 @@ func TestEnergyCurrentHandler(t *testing.T) {
 		}
 	}
 
-	runTests(t, NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithoutFirestore{}))
+	runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}))
 	if testWithFirestore {
-		runTests(t, NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithFirestore{}))
+		runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithFirestore{}))
 	}
 
 }
@@ -105,7 +99,7 @@ func TestEnergyCurrentHandler(t *testing.T) {
 // Tests the invalid Method for EnergyCurrentHandler
 
 func TestEnergyCurrentHandler_InvalidMethod(t *testing.T) {
-	s := NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithoutFirestore{})
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{})
 	server := httptest.NewServer(http.HandlerFunc(s.EnergyHistoryHandler))
 	defer server.Close()
 	// Test: Send a POST request to the EnergyHistoryHandler
@@ -183,16 +177,16 @@ func TestEnergyHistoryHandler(t *testing.T) {
 		}
 	}
 
-	runTests(t, NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithoutFirestore{}))
+	runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}))
 	if testWithFirestore {
-		runTests(t, NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithFirestore{}))
+		runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithFirestore{}))
 	}
 }
 
 // Tests the invalid Method for EnergyHistoryHandler
 
 func TestEnergyHistoryHandler_InvalidMethod(t *testing.T) {
-	s := NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithoutFirestore{})
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{})
 	//server := httptest.NewServer(http.HandlerFunc(s.EnergyHistoryHandler))
 	server := httptest.NewServer(SetupRoutes("8081", s))
 	defer server.Close()
@@ -213,6 +207,58 @@ func TestEnergyHistoryHandler_InvalidMethod(t *testing.T) {
 	}
 }
 
+// TestConditionalRequests tests that renewables responses can be revalidated with ETag and Last-Modified
+func TestConditionalRequests(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, HTTPCacheConfig(time.Minute))
+	server := httptest.NewServer(http.HandlerFunc(s.EnergyCurrentHandler))
+	defer server.Close()
+
+	get := func(url string, header string, value string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if len(header) > 0 {
+			req.Header.Set(header, value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Get request to URL failed:", err.Error())
+		}
+		res.Body.Close()
+		return res
+	}
+
+	// Test 1: responses carry caching headers
+	res1 := get(server.URL+RenewablesCurrentPath+"nor?neighbours=true&x=1", "", "")
+	tag := res1.Header.Get("ETag")
+	if res1.StatusCode != http.StatusOK || len(tag) == 0 || len(res1.Header.Get("Last-Modified")) == 0 {
+		t.Fatal("expected ETag and Last-Modified headers on a 200 response")
+	}
+	if res1.Header.Get("Cache-Control") != "public, max-age=60" {
+		t.Fatal("unexpected Cache-Control header: ", res1.Header.Get("Cache-Control"))
+	}
+
+	// Test 2: a matching ETag returns 304, also when the query parameters are reordered
+	res2 := get(server.URL+RenewablesCurrentPath+"NOR?x=1&neighbours=true", "If-None-Match", tag)
+	if res2.StatusCode != http.StatusNotModified || res2.Header.Get("ETag") != tag {
+		t.Fatal("expected 304 for a matching ETag, got: ", res2.StatusCode)
+	}
+
+	// Test 3: a different query has a different ETag
+	res3 := get(server.URL+RenewablesCurrentPath+"swe", "If-None-Match", tag)
+	if res3.StatusCode != http.StatusOK || res3.Header.Get("ETag") == tag {
+		t.Fatal("expected 200 with a new ETag for a different query, got: ", res3.StatusCode)
+	}
+
+	// Test 4: If-Modified-Since returns 304 unless the dataset was loaded later
+	res4 := get(server.URL+RenewablesCurrentPath+"nor", "If-Modified-Since", res1.Header.Get("Last-Modified"))
+	if res4.StatusCode != http.StatusNotModified {
+		t.Fatal("expected 304 for If-Modified-Since, got: ", res4.StatusCode)
+	}
+	before := s.getDataset().LoadedAt.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if res5 := get(server.URL+RenewablesCurrentPath+"nor", "If-Modified-Since", before); res5.StatusCode != http.StatusOK {
+		t.Fatal("expected 200 for an older If-Modified-Since, got: ", res5.StatusCode)
+	}
+}
+
 func calculateAverage(dataList types.YearRecordList) float64 {
 	sum := 0.0
 	for _, data := range dataList {
@@ -304,13 +350,13 @@ func TestNotificationHandler(t *testing.T) {
 		}
 
 		// test 5: Show all webhook registrations
-		switch s.firestoreMode.(type) {
+		switch s.storageMode.(type) {
 		case WithFirestore:
 			HttpGetAndDecode(t, server.URL+NotificationsPath, &multiResponse)
 			if len(multiResponse) < 2 {
 				t.Fatal("expected at least two registrations")
 			}
-		case WithoutFirestore:
+		case WithoutFirestore, WithStore:
 			HttpGetAndDecode(t, server.URL+NotificationsPath, &multiResponse)
 			if len(multiResponse) != 2 {
 				t.Fatal("expected exactly two registrations")
@@ -373,9 +419,129 @@ func TestNotificationHandler(t *testing.T) {
 
 	}
 
-	runTests(t, NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithoutFirestore{}))
+	runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}))
+	runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}))
 	if testWithFirestore {
-		runTests(t, NewService(path.Join("res", types.CSVFilePath), StubRestCountries{}, WithFirestore{}))
+		runTests(t, NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithFirestore{}))
+	}
+}
+
+// TestSignedWebhooks verifies that webhooks are signed with the secret of their registration, which is
+// only returned when it is created or rotated, and that the old secret verifies during the grace period
+func TestSignedWebhooks(t *testing.T) {
+	type received struct {
+		timestamp string
+		signature string
+		body      []byte
+	}
+	deliveries := make(chan received, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{r.Header.Get(signing.TimestampHeader), r.Header.Get(signing.SignatureHeader), body}
+	}))
+	defer receiver.Close()
+
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, WarmupConfig(0, 0))
+	server := httptest.NewServer(http.HandlerFunc(s.NotificationHandler))
+	defer server.Close()
+	deliver := func(webhookID string) received {
+		s.dispatcher.enqueue(webhookEvent{URL: receiver.URL, Payload: WebhookResponse{WebhookID: webhookID, Country: "Germany", Calls: 5}})
+		select {
+		case delivery := <-deliveries:
+			return delivery
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the webhook to be delivered")
+			return received{}
+		}
+	}
+	verify := func(secret string, delivery received) error {
+		return signing.Verify(secret, delivery.timestamp, delivery.signature, delivery.body, time.Now(), signing.Tolerance)
+	}
+
+	// test 1: a secret is generated on registration and returned once, but not shown afterwards
+	var created map[string]string
+	body := "{ \"url\": \"" + receiver.URL + "\", \"country\": \"DEU\", \"calls\": 5 }"
+	if HttpPostAndDecode(t, server.URL+NotificationsPath, body, &created) != http.StatusCreated {
+		t.Fatal("Expected 201 created")
+	}
+	webhookID, secret := created["webhook_id"], created["secret"]
+	if !strings.HasPrefix(secret, signing.SecretPrefix) {
+		t.Fatal("expected a generated secret, got: ", created)
+	}
+	var shown map[string]interface{}
+	HttpGetAndDecode(t, server.URL+NotificationsPath+webhookID, &shown)
+	if _, ok := shown["secret"]; ok || shown["webhook_id"] != webhookID {
+		t.Fatal("expected the registration without its secret, got: ", shown)
+	}
+
+	// test 2: secrets supplied by the client must not be too short
+	body = "{ \"url\": \"" + receiver.URL + "\", \"country\": \"DEU\", \"calls\": 5, \"secret\": \"short\" }"
+	if HttpPostStatusCode(t, server.URL+NotificationsPath, body) != http.StatusBadRequest {
+		t.Fatal("Expected 400 Bad Request")
+	}
+
+	// test 3: deliveries are signed with the secret
+	if err := verify(secret, deliver(webhookID)); err != nil {
+		t.Fatal("expected the webhook to be signed with the secret, got: ", err)
+	}
+
+	rotate := func(token string, body string, rotated *SecretResponse) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+NotificationsPath+webhookID+"/secret", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Request to URL failed:", err.Error())
+		}
+		defer res.Body.Close()
+		*rotated = SecretResponse{}
+		_ = json.NewDecoder(res.Body).Decode(rotated)
+		return res.StatusCode
+	}
+
+	// test 4: rotating the secret requires the current secret, as webhook IDs are listed publicly
+	var rotated SecretResponse
+	for _, token := range []string{"", "whsec_not-the-secret-of-the-webhook"} {
+		if status := rotate(token, "", &rotated); status != http.StatusUnauthorized || rotated.Secret != "" {
+			t.Fatal("expected 401 Unauthorized without the current secret, got: ", status)
+		}
+	}
+	if stored, _ := s.getRegistration(webhookID); stored.Secret != secret {
+		t.Fatal("expected the secret to be kept, got: ", stored.Secret)
+	}
+
+	// test 5: after a rotation, both the new and the old secret verify during the grace period
+	if rotate(secret, "", &rotated) != http.StatusOK {
+		t.Fatal("Expected 200 OK")
+	}
+	if rotated.Secret == secret || rotated.PreviousSecretExpires == nil || time.Until(*rotated.PreviousSecretExpires) <= time.Hour {
+		t.Fatalf("expected a new secret, and the old one to verify for the grace period, got: %+v", rotated)
+	}
+	delivery := deliver(webhookID)
+	if verify(rotated.Secret, delivery) != nil || verify(secret, delivery) != nil {
+		t.Fatal("expected the webhook to be signed with both secrets, got: ", delivery.signature)
+	}
+
+	// test 6: without a grace period, only the supplied secret verifies, and the old secret cannot rotate it again
+	body = "{ \"secret\": \"a-secret-of-the-client\", \"grace_period\": 0 }"
+	if rotate(rotated.Secret, body, &rotated) != http.StatusOK {
+		t.Fatal("Expected 200 OK")
+	}
+	if rotate(secret, "", &SecretResponse{}) != http.StatusUnauthorized {
+		t.Fatal("expected 401 Unauthorized with the old secret")
+	}
+	delivery = deliver(webhookID)
+	if rotated.PreviousSecretExpires != nil || verify("a-secret-of-the-client", delivery) != nil || verify(secret, delivery) == nil {
+		t.Fatal("expected the webhook to be signed with the supplied secret only, got: ", delivery.signature)
+	}
+	if stored, _ := s.getRegistration(webhookID); stored.Secret != "a-secret-of-the-client" {
+		t.Fatal("expected the rotated secret to be kept, got: ", stored.Secret)
+	}
+
+	// test 7: rotating the secret of an unknown webhook is rejected
+	if HttpPostStatusCode(t, server.URL+NotificationsPath+"missing/secret", "") != http.StatusBadRequest {
+		t.Fatal("Expected 400 Bad Request")
 	}
 }
 
@@ -392,8 +558,7 @@ func TestStatusHandler(t *testing.T) {
 		// Test 1: Check if the APIStatus struct fields have expected values
 		HttpGetAndDecode(t, server.URL+StatusPath, &apiStatus)
 		if apiStatus.Countriesapi != http.StatusOK {
-			t.Errorf("Have you started the stub service for REST countries?"+
-				"Unexpected countries API status: got %v want %v", apiStatus.Countriesapi, http.StatusOK)
+			t.Errorf("Unexpected countries API status: got %v want %v", apiStatus.Countriesapi, http.StatusOK)
 		}
 		// Test 2: Testing whether a Bad Request error is returned when an unsupported HTTP method is used
 		statusCode1 := HttpPostStatusCode(t, server.URL+StatusPath, "")
@@ -422,12 +587,60 @@ func TestStatusHandler(t *testing.T) {
 
 	}
 
-	filePath := path.Join("res", types.CSVFilePath)
-	state1 := NewService(filePath, StubRestCountries{}, WithoutFirestore{})
+	state1 := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{})
 	runTests(t, state1)
 
 	if testWithFirestore {
-		state2 := NewService(filePath, StubRestCountries{}, WithFirestore{})
+		state2 := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithFirestore{})
 		runTests(t, state2)
 	}
 }
+
+// TestStorageMode verifies that storage modes can be selected by name, and that the notification DB
+// status reflects whether a store is used
+func TestStorageMode(t *testing.T) {
+	for _, backend := range []string{"none", store.Memory, store.SQLite, store.JSONFile, store.Firestore} {
+		if _, err := ParseStorageMode(backend, "renewables.db"); err != nil {
+			t.Fatal("expected backend to be recognised: ", backend)
+		}
+	}
+	if _, err := ParseStorageMode("floppy", ""); err == nil {
+		t.Fatal("expected unknown backend to return an error")
+	}
+
+	without := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, WarmupConfig(0, 0))
+	if code, health := without.getNotificationDBStatus(); code != http.StatusServiceUnavailable || health != nil {
+		t.Fatal("expected 503 Service Unavailable without a store")
+	}
+	with := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, WarmupConfig(0, 0))
+	if code, health := with.getNotificationDBStatus(); code != http.StatusOK || !health.Healthy || health.Backend != store.Memory {
+		t.Fatal("expected 200 OK and a healthy memory store")
+	}
+}
+
+// TestJournalReplay verifies that updates which were not written to the store before the service
+// stopped are replayed from the journal when it starts again
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, JournalConfig(dir), WarmupConfig(0, 0))
+	s.newRegistration(types.InvocationRegistration{WebhookID: "replayed", URL: "http://example.com", Country: "NOR", Calls: 1})
+	for i := 0; i < 42; i++ {
+		s.incrementInvocationCount("NOR")
+	}
+	s.queueCountDeltas()
+	// the registration, and the batch with the invocation count and buckets
+	if stats := s.getQueueStats(); stats == nil || stats.Pending != 2 {
+		t.Fatalf("expected 2 pending updates, got: %+v", stats)
+	}
+
+	restarted := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, JournalConfig(dir), WarmupConfig(0, 0))
+	if _, ok := restarted.getRegistration("replayed"); !ok {
+		t.Fatal("expected registration to be replayed")
+	}
+	if restarted.getInvocationCount("NOR") != 42 {
+		t.Fatal("expected invocation count to be replayed, got: ", restarted.getInvocationCount("NOR"))
+	}
+	if without := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, WarmupConfig(0, 0)); without.getQueueStats() != nil {
+		t.Fatal("expected no write queue without a store")
+	}
+}
@@ -2,12 +2,16 @@ package web
 
 import (
 	"assignment2/internal/types"
+	"assignment2/internal/web_client"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // httpRespondJSON takes any type of data and attempts to encode it as JSON to the response writer
@@ -18,7 +22,19 @@ func httpRespondJSON(w http.ResponseWriter, data any, s *State) {
 	if err != nil {
 		http.Error(w, "Could not encode JSON", http.StatusInternalServerError)
 	}
-	go invocate(data, s)
+	if s != nil {
+		invocate(data, s)
+	}
+}
+
+// httpRespondJSONStatus responds with `data` as JSON and the given status code. The headers are set
+// before the status code is written, as they cannot be changed afterwards.
+func httpRespondJSONStatus(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Println("Could not encode JSON: " + err.Error())
+	}
 }
 
 // HttpGetAndDecode is a helper function that retrieves and returns the JSON data
@@ -64,25 +80,51 @@ func invocate(data any, s *State) {
 	}
 }
 
-// updateFirestore sends the given data to the specified channel.
-func updateFirestore[T any](channel chan T, data T) {
-	// checking if channel is open
-	if channel != nil {
-		select {
-		case channel <- data:
-			// successful
-		default:
-			println("channel is full! dropping data")
+// httpRespondRenewables sends renewables data with caching headers, or 304 Not Modified if the
+// request is conditional and the client already has the current response. Both count as invocations.
+func httpRespondRenewables(w http.ResponseWriter, r *http.Request, query renewablesQuery, data types.YearRecordList, s *State) {
+	tag := etag(query, s)
+	setCacheHeaders(w, tag, s)
+	if notModified(r, tag, s) {
+		w.WriteHeader(http.StatusNotModified)
+		invocate(data, s)
+		return
+	}
+	httpRespondJSON(w, data, s)
+}
+
+// setCacheHeaders sets the ETag, Last-Modified and Cache-Control headers, which lets clients and CDNs
+// cache the response and revalidate it with a conditional request
+func setCacheHeaders(w http.ResponseWriter, tag string, s *State) {
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Last-Modified", s.getDataset().LoadedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.httpCacheMaxAge.Seconds())))
+}
+
+// notModified returns true if the request is conditional (If-None-Match or If-Modified-Since)
+// and the client already has the response with the entity tag `current`
+func notModified(r *http.Request, current string, s *State) bool {
+	if match := r.Header.Get("If-None-Match"); len(match) > 0 {
+		// If-None-Match takes precedence over If-Modified-Since (RFC 7232 section 6)
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == current || tag == "*" {
+				return true
+			}
 		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		// HTTP dates only have second precision
+		return !s.getDataset().LoadedAt.Truncate(time.Second).After(since)
 	}
+	return false
 }
 
-// httpCacheAndRespondJSON updates the cache, and sends a JSON response with the provided data and application state.
-func httpCacheAndRespondJSON(w http.ResponseWriter, url *url.URL, data types.YearRecordList, s *State) {
-	value := make(map[string]types.YearRecordList)
-	value[url.String()] = data
-	updateFirestore(s.chCache, value)
-	httpRespondJSON(w, data, s)
+// etag returns a strong entity tag derived from the dataset version and the canonical query
+func etag(query renewablesQuery, s *State) string {
+	hash := sha256.Sum256([]byte(s.getDataset().Version + "|" + query.key(s.getDataset())))
+	return "\"" + hex.EncodeToString(hash[:16]) + "\""
 }
 
 // HttpGetStatusCode returns the statuscode of a POST request
@@ -125,11 +167,16 @@ func HttpDeleteStatusCode(t *testing.T, url string, payload string) int {
 
 // getStatusCode returns the statuscode of a GET request
 func getStatusCode(url string) int {
-	client := http.Client{}
-	defer client.CloseIdleConnections()
-	res, err := client.Get(url)
+	client := web_client.NewClient()
+	if err := client.SetURL(url); err != nil {
+		return http.StatusServiceUnavailable
+	}
+	client.SetTimeout(StatusProbeTimeout)
+	client.SetRetries(0, 0)
+	res, err := client.Get()
 	if err != nil {
 		return http.StatusServiceUnavailable
 	}
+	_ = res.Body.Close()
 	return res.StatusCode
 }
@@ -0,0 +1,194 @@
+package web
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Options holds the settings of the service that can be configured when it is created
+type Options struct {
+	CacheSize          int                      // maximum number of responses in the in-memory cache
+	CacheTTL           time.Duration            // time a response is kept in the in-memory cache
+	FirestoreCacheTTL  time.Duration            // time a response is kept in the Firestore cache
+	HTTPCacheMaxAge    time.Duration            // time clients and CDNs may cache a response before revalidating
+	CacheTTLPolicy     map[string]time.Duration // time a response is cached, by endpoint path
+	CacheSweepInterval time.Duration            // time between purges of expired cache entries, or zero to disable
+	WarmupSize         int                      // number of most requested countries cached at startup, or zero to disable
+	WarmupTimeout      time.Duration            // time the startup waits for the warm-up, or zero to warm up in the background
+	AdminToken         string                   // bearer token required by the admin endpoints, or empty to leave them open
+	JournalDir         string                   // directory of the journal of pending store updates, or empty to keep them in memory
+	InstanceID         string                   // identifies the instance in a store shared with other instances, or empty to generate one
+	SnapshotDir        string                   // directory of the scheduled snapshots of the store, or empty to disable them
+	SnapshotInterval   time.Duration            // time between snapshots of the store
+	SnapshotKeep       int                      // number of snapshots kept in the snapshot directory
+	MigrateOnStartup   bool                     // whether documents of earlier schema versions are upgraded when the service starts
+	WebhookWorkers     int                      // number of workers posting webhooks
+	WebhookQueueSize   int                      // number of webhooks waiting to be posted before new ones are dropped
+	WebhookHostLimit   int                      // number of webhooks posted to the same host at once
+	WebhookMaxAttempts int                      // number of times a webhook is posted before it is dead-lettered
+	WebhookBackoff     time.Duration            // backoff before the first retry of a webhook, doubled on every further retry
+	DeliveryLogSize    int                      // number of delivery attempts kept for every registration
+}
+
+// Option changes one or more settings of the service
+type Option func(options *Options)
+
+// defaultOptions returns the settings used unless an Option overrides them
+func defaultOptions() Options {
+	return Options{
+		CacheSize:          CacheSize,
+		CacheTTL:           CacheTTL,
+		FirestoreCacheTTL:  FirestoreCacheTTL,
+		HTTPCacheMaxAge:    HTTPCacheMaxAge,
+		CacheTTLPolicy:     map[string]time.Duration{},
+		CacheSweepInterval: CacheSweepInterval,
+		WarmupSize:         WarmupSize,
+		WarmupTimeout:      WarmupTimeout,
+		SnapshotInterval:   SnapshotInterval,
+		SnapshotKeep:       SnapshotKeep,
+		WebhookWorkers:     WebhookWorkers,
+		WebhookQueueSize:   WebhookQueueSize,
+		WebhookHostLimit:   WebhookHostLimit,
+		WebhookMaxAttempts: WebhookMaxAttempts,
+		WebhookBackoff:     WebhookRetryBackoff,
+		DeliveryLogSize:    DeliveryLogSize,
+	}
+}
+
+// CacheConfig sets the size and TTL of the in-memory response cache, and the TTL of the Firestore cache
+func CacheConfig(size int, ttl time.Duration, firestoreTTL time.Duration) Option {
+	return func(options *Options) {
+		options.CacheSize = size
+		options.CacheTTL = ttl
+		options.FirestoreCacheTTL = firestoreTTL
+	}
+}
+
+// HTTPCacheConfig sets the max-age of the Cache-Control header on renewables responses
+func HTTPCacheConfig(maxAge time.Duration) Option {
+	return func(options *Options) {
+		options.HTTPCacheMaxAge = maxAge
+	}
+}
+
+// CacheTTLPolicy sets the time responses from each endpoint are cached, by endpoint path. Endpoints
+// without a policy use the TTL of each cache layer.
+func CacheTTLPolicy(policy map[string]time.Duration) Option {
+	return func(options *Options) {
+		for path, ttl := range policy {
+			options.CacheTTLPolicy[path] = ttl
+		}
+	}
+}
+
+// CacheSweepConfig sets the time between purges of expired cache entries. Zero disables the sweeper.
+func CacheSweepConfig(interval time.Duration) Option {
+	return func(options *Options) {
+		options.CacheSweepInterval = interval
+	}
+}
+
+// WarmupConfig sets the number of most requested countries that are cached at startup, and the time the
+// startup waits for the warm-up to complete. A size of zero disables the warm-up.
+func WarmupConfig(size int, timeout time.Duration) Option {
+	return func(options *Options) {
+		options.WarmupSize = size
+		options.WarmupTimeout = timeout
+	}
+}
+
+// JournalConfig sets the directory where updates are journaled until they have been written to the store.
+// With an empty directory, pending updates are only kept in memory and are lost if the service crashes.
+func JournalConfig(dir string) Option {
+	return func(options *Options) {
+		options.JournalDir = dir
+	}
+}
+
+// InstanceID sets the identifier of the instance in a store shared with other instances. The identifier
+// must be unique among the instances, and is generated from the host name by default.
+func InstanceID(id string) Option {
+	return func(options *Options) {
+		options.InstanceID = id
+	}
+}
+
+// SnapshotConfig sets the directory where the store is snapshotted every `interval`, and the number of
+// snapshots kept in it. An empty directory disables the snapshots.
+func SnapshotConfig(dir string, interval time.Duration, keep int) Option {
+	return func(options *Options) {
+		options.SnapshotDir = dir
+		options.SnapshotInterval = interval
+		options.SnapshotKeep = keep
+	}
+}
+
+// MigrateConfig sets whether the documents of earlier schema versions are upgraded when the service starts,
+// which reads every document in the store. When disabled, which is the default, the store must be migrated
+// with the migrate command before a version of the service with a new schema is started.
+func MigrateConfig(onStartup bool) Option {
+	return func(options *Options) {
+		options.MigrateOnStartup = onStartup
+	}
+}
+
+// WebhookConfig sets the number of workers posting webhooks, the number of webhooks waiting to be posted
+// before new ones are dropped, and the number of webhooks posted to the same host at once
+func WebhookConfig(workers int, queueSize int, hostLimit int) Option {
+	return func(options *Options) {
+		options.WebhookWorkers = workers
+		options.WebhookQueueSize = queueSize
+		options.WebhookHostLimit = hostLimit
+	}
+}
+
+// WebhookRetryConfig sets the number of times a webhook is posted before it is dead-lettered, and the
+// backoff before the first retry, which is doubled on every further retry
+func WebhookRetryConfig(maxAttempts int, backoff time.Duration) Option {
+	return func(options *Options) {
+		options.WebhookMaxAttempts = maxAttempts
+		options.WebhookBackoff = backoff
+	}
+}
+
+// DeliveryLogConfig sets the number of delivery attempts kept for every registration, the oldest being
+// replaced by new ones
+func DeliveryLogConfig(size int) Option {
+	return func(options *Options) {
+		options.DeliveryLogSize = size
+	}
+}
+
+// AdminToken sets the bearer token required by the admin endpoints
+func AdminToken(token string) Option {
+	return func(options *Options) {
+		options.AdminToken = token
+	}
+}
+
+// ParseCacheTTLPolicy parses a comma separated list of endpoint=ttl pairs, such as
+// "current=24h,history=168h", into a policy for CacheTTLPolicy
+func ParseCacheTTLPolicy(list string) (map[string]time.Duration, error) {
+	endpoints := map[string]string{
+		"current": RenewablesCurrentPath,
+		"history": RenewablesHistoryPath,
+	}
+	policy := map[string]time.Duration{}
+	for _, pair := range strings.Split(list, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+		name, value, found := strings.Cut(pair, "=")
+		path, ok := endpoints[strings.TrimSpace(name)]
+		if !found || !ok {
+			return nil, errors.New("invalid cache TTL policy \"" + pair + "\", expected current=ttl or history=ttl")
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.New("invalid TTL in cache TTL policy \"" + pair + "\": " + err.Error())
+		}
+		policy[path] = ttl
+	}
+	return policy, nil
+}
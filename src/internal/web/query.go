@@ -0,0 +1,155 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"assignment2/internal/utils"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// renewablesQuery is a validated request to one of the renewables endpoints, where defaults are
+// made explicit. Requests that differ only in parameter order, letter case of the country code or
+// omitted defaults result in the same query, and therefore share the same cache key.
+type renewablesQuery struct {
+	endpoint    string // RenewablesCurrentPath or RenewablesHistoryPath
+	country     string // upper-cased ISO code, or empty for all countries
+	neighbours  bool   // include neighbouring countries, only for a single country on the current endpoint
+	begin       int    // first year on the history endpoint, or 0 for no limit
+	end         int    // last year on the history endpoint, or 0 for no limit
+	sortByValue bool   // sort by percentage on the history endpoint
+}
+
+// parseCurrentQuery validates a request to the current endpoint
+func parseCurrentQuery(u *url.URL) (renewablesQuery, error) {
+	q := renewablesQuery{endpoint: RenewablesCurrentPath}
+	var err error
+	if q.country, err = parseCountry(u, RenewablesCurrentPath); err != nil {
+		return q, err
+	}
+	if q.neighbours, err = parseBool(u, "neighbours"); err != nil {
+		return q, err
+	}
+	// neighbours only apply to a single country
+	q.neighbours = q.neighbours && len(q.country) > 0
+	return q, nil
+}
+
+// parseHistoryQuery validates a request to the history endpoint
+func parseHistoryQuery(u *url.URL) (renewablesQuery, error) {
+	q := renewablesQuery{endpoint: RenewablesHistoryPath}
+	var err error
+	if q.country, err = parseCountry(u, RenewablesHistoryPath); err != nil {
+		return q, err
+	}
+	if q.begin, err = parseYear(u, "begin"); err != nil {
+		return q, err
+	}
+	if q.end, err = parseYear(u, "end"); err != nil {
+		return q, err
+	}
+	if q.begin > 0 && q.end > 0 && q.begin > q.end {
+		return q, errors.New("begin must not be after end")
+	}
+	if q.sortByValue, err = parseBool(u, "sortByValue"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// datasetKeyLength is the number of characters of the dataset version included in the cache keys
+const datasetKeyLength = 12
+
+// key returns the canonical cache key of the query on the dataset, with the parameters of the endpoint
+// in sorted order. The key includes the dataset version, so that responses computed from a previous
+// dataset are never returned after it is reloaded, even when they are written to the cache afterwards.
+func (q renewablesQuery) key(dataset *types.Dataset) string {
+	version := dataset.Version
+	if len(version) > datasetKeyLength {
+		version = version[:datasetKeyLength]
+	}
+	params := url.Values{}
+	params.Set("dataset", version)
+	switch q.endpoint {
+	case RenewablesCurrentPath:
+		params.Set("neighbours", strconv.FormatBool(q.neighbours))
+	case RenewablesHistoryPath:
+		params.Set("begin", strconv.Itoa(q.begin))
+		params.Set("end", strconv.Itoa(q.end))
+		params.Set("sortByValue", strconv.FormatBool(q.sortByValue))
+	}
+	return q.endpoint + q.country + "?" + params.Encode()
+}
+
+// compute returns the response for the query from the dataset
+func (q renewablesQuery) compute(s *State, db types.RenewableDB) types.YearRecordList {
+	switch q.endpoint {
+	case RenewablesCurrentPath:
+		return s.getCurrentRenewable(db, q.country, q.neighbours)
+	case RenewablesHistoryPath:
+		if len(q.country) == 0 {
+			return db.GetHistoricAvg(q.begin, q.end, q.sortByValue)
+		}
+		return db.GetHistoric(q.country, q.begin, q.end, q.sortByValue)
+	}
+	return nil
+}
+
+// getRenewables returns the response for the query from the cache, or computes and caches it.
+// Concurrent requests for the same query are coalesced, so that a cache miss is only computed
+// and written once. The response is computed from the same dataset as the one in its key.
+func (s *State) getRenewables(q renewablesQuery) types.YearRecordList {
+	dataset := s.getDataset()
+	key := q.key(dataset)
+	data, _, _ := s.requests.Do(key, func() (any, error) {
+		if entry, ok := s.cache.Get(key); ok {
+			return entry.Records, nil
+		}
+		data := q.compute(s, dataset.DB)
+		if len(data) > 0 {
+			s.cache.Set(key, data, s.cacheTTL(q.endpoint))
+		}
+		return data, nil
+	})
+	return data.(types.YearRecordList)
+}
+
+// parseCountry returns the upper-cased country code in the path, or an empty string if there is none
+func parseCountry(u *url.URL, prefix string) (string, error) {
+	segments := utils.GetSegments(u, prefix)
+	switch len(segments) {
+	case 0:
+		return "", nil
+	case 1:
+		return strings.ToUpper(segments[0]), nil
+	default:
+		return "", errors.New("expected at most one country code")
+	}
+}
+
+// parseBool returns the value of a boolean query parameter, which is false if omitted
+func parseBool(u *url.URL, name string) (bool, error) {
+	value := u.Query().Get(name)
+	if len(value) == 0 {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New(name + " must be true or false")
+	}
+	return b, nil
+}
+
+// parseYear returns the value of a year query parameter, which is 0 if omitted
+func parseYear(u *url.URL, name string) (int, error) {
+	value := u.Query().Get(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 0 {
+		return 0, errors.New(name + " must be a year")
+	}
+	return year, nil
+}
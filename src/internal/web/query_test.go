@@ -0,0 +1,110 @@
+package web
+
+import (
+	"assignment2/api"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingCountries is a countingCountries that blocks every lookup until `release` is closed
+type blockingCountries struct {
+	countingCountries
+	release chan struct{}
+}
+
+func (c *blockingCountries) getCountry(cca string) (api.Country, error) {
+	<-c.release
+	return c.countingCountries.getCountry(cca)
+}
+
+func TestRenewablesQuery(t *testing.T) {
+	dataset := &types.Dataset{Version: "0123456789abcdef"}
+	parse := func(parser func(*url.URL) (renewablesQuery, error), path string) (string, error) {
+		u, _ := url.Parse(path)
+		q, err := parser(u)
+		return q.key(dataset), err
+	}
+
+	// Test 1: parameter order, letter case and omitted defaults share the same key
+	key1, _ := parse(parseHistoryQuery, RenewablesHistoryPath+"nor?begin=2000&end=2010")
+	key2, _ := parse(parseHistoryQuery, RenewablesHistoryPath+"NOR?end=2010&begin=2000&sortByValue=false&utm=x")
+	if key1 != key2 || key1 != RenewablesHistoryPath+"NOR?begin=2000&dataset=0123456789ab&end=2010&sortByValue=false" {
+		t.Fatalf("expected canonical keys to match, got %s and %s", key1, key2)
+	}
+	key3, _ := parse(parseCurrentQuery, RenewablesCurrentPath+"?neighbours=true")
+	if key3 != RenewablesCurrentPath+"?dataset=0123456789ab&neighbours=false" {
+		t.Fatal("expected neighbours to be ignored without a country, got: ", key3)
+	}
+
+	// Test 2: the same query on another dataset has another key
+	u, _ := url.Parse(RenewablesHistoryPath + "nor?begin=2000&end=2010")
+	query, _ := parseHistoryQuery(u)
+	if query.key(&types.Dataset{Version: "fedcba9876543210"}) == key1 {
+		t.Fatal("expected the key to change with the dataset version")
+	}
+
+	// Test 3: invalid parameters are rejected
+	for _, path := range []string{"nor?begin=abc", "nor?begin=2010&end=2000", "nor/swe", "nor?sortByValue=maybe"} {
+		if _, err := parse(parseHistoryQuery, RenewablesHistoryPath+path); err == nil {
+			t.Fatal("expected error for: ", path)
+		}
+	}
+	if _, err := parse(parseCurrentQuery, RenewablesCurrentPath+"nor?neighbours=yes"); err == nil {
+		t.Fatal("expected error for invalid neighbours")
+	}
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	countries := &blockingCountries{release: make(chan struct{})}
+	s := NewService(res.Embedded, NewCachedRestCountries(countries, time.Hour), WithoutFirestore{})
+	u, _ := url.Parse(RenewablesCurrentPath + "nor?neighbours=true")
+	query, _ := parseCurrentQuery(u)
+
+	// Test 1: concurrent identical misses are computed once, including the neighbour lookup
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = len(s.getRenewables(query))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(countries.release)
+	wg.Wait()
+	if countries.calls.Load() != 1 {
+		t.Fatal("expected a single neighbour lookup, got: ", countries.calls.Load())
+	}
+	for _, n := range results {
+		if n != 2 {
+			t.Fatal("expected every request to get NOR and its neighbour, got: ", n)
+		}
+	}
+
+	// Test 2: the coalesced response is cached under the canonical key
+	if _, ok := s.cache.Get(query.key(s.getDataset())); !ok {
+		t.Fatal("expected response to be cached")
+	}
+
+	// Test 3: concurrent lookups of the same neighbour are coalesced in the countries cache
+	blocking := &blockingCountries{release: make(chan struct{})}
+	countriesCache := NewCachedRestCountries(blocking, time.Hour)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(cca string) {
+			defer wg.Done()
+			_, _ = countriesCache.getCountry(cca)
+		}([]string{"swe", "SWE"}[i%2])
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(blocking.release)
+	wg.Wait()
+	if blocking.calls.Load() != 1 {
+		t.Fatal("expected a single lookup for concurrent misses, got: ", blocking.calls.Load())
+	}
+}
@@ -14,7 +14,13 @@ func SetupRoutes(port string, s *State) *http.ServeMux {
 	mux.HandleFunc(RenewablesCurrentPath, s.EnergyCurrentHandler)
 	mux.HandleFunc(RenewablesHistoryPath, s.EnergyHistoryHandler)
 	mux.HandleFunc(NotificationsPath, s.NotificationHandler)
+	mux.HandleFunc(DeadLettersPath, s.DeadLetterHandler)
 	mux.HandleFunc(StatusPath, s.StatusHandler)
+	mux.HandleFunc(AdminCachePath, s.AdminCacheHandler)
+	mux.HandleFunc(AdminBackupPath, s.AdminBackupHandler)
+	mux.HandleFunc(AdminDatasetPath, s.AdminDatasetHandler)
+	mux.HandleFunc(ReadinessPath, s.ReadinessHandler)
+	mux.HandleFunc(StatsPath, s.StatsHandler)
 
 	// Constructing the base domain name with the provided port
 	domainNamePort := "http://localhost:" + port
@@ -24,7 +30,14 @@ func SetupRoutes(port string, s *State) *http.ServeMux {
 	log.Println(domainNamePort + RenewablesCurrentPath)
 	log.Println(domainNamePort + RenewablesHistoryPath)
 	log.Println(domainNamePort + NotificationsPath)
+	log.Println(domainNamePort + DeadLettersPath)
 	log.Println(domainNamePort + StatusPath)
+	log.Println(domainNamePort + AdminCachePath)
+	log.Println(domainNamePort + AdminBackupPath)
+	log.Println(domainNamePort + AdminDatasetPath)
+	log.Println(domainNamePort + ReadinessPath)
+	log.Println(domainNamePort + StatsPath + "invocations")
+	log.Println(domainNamePort + StatsPath + "top")
 
 	return &mux
 }
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Close shuts the service down after the HTTP server has stopped accepting requests. It stops the
+// background workers, waits for the queued webhook deliveries to finish, writes the pending updates
+// to the store and closes it. Close returns once `ctx` expires: webhook deliveries that have not finished
+// are aborted, and workers or a final write stuck in the store are left behind, together with the journal
+// and the store they still use. An error is returned if anything was aborted or could not be written, in
+// which case the journal replays the updates on the next start. Close must only be called once.
+func (s *State) Close(ctx context.Context) error {
+	var errs []string
+	abandoned := false // whether goroutines that may still write to the journal and the store were left behind
+
+	// Stop the background workers, which may be dispatching webhooks, so that nothing writes to the store
+	// while it is flushed
+	close(s.stop)
+	if err := s.drain(ctx, &s.workers); err != nil {
+		errs = append(errs, "webhook dispatch: "+err.Error())
+		abandoned = true
+	}
+
+	// Drain the webhooks queued for the dispatcher. The deadline is only reported once.
+	s.dispatcher.close()
+	if err := s.drain(ctx, &s.dispatcher.workers); err != nil {
+		if len(errs) == 0 {
+			errs = append(errs, "webhook deliveries: "+err.Error())
+		}
+		abandoned = true
+	}
+	s.cancel()
+
+	if s.queue != nil {
+		flushed := make(chan error, 1)
+		go func() {
+			_, err := s.flushUpdates()
+			flushed <- err
+		}()
+		select {
+		case err := <-flushed:
+			if err != nil {
+				errs = append(errs, "flush: "+err.Error())
+			}
+		case <-ctx.Done():
+			errs = append(errs, "flush: "+ctx.Err().Error())
+			abandoned = true
+		}
+	}
+	if abandoned {
+		log.Println("Leaving the journal and the store open for the work that did not finish before the shutdown deadline")
+		return errors.New("shutdown incomplete: " + strings.Join(errs, "; "))
+	}
+
+	if s.queue != nil {
+		if err := s.queue.Close(); err != nil {
+			errs = append(errs, "journal: "+err.Error())
+		}
+	}
+	if s.store != nil {
+		if err := s.store.Close(); err != nil {
+			errs = append(errs, "store: "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("shutdown incomplete: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// drain waits for the goroutines in `wg` to finish. If `ctx` expires first, the webhook deliveries are
+// aborted, and the error of `ctx` is returned without waiting for the goroutines any longer.
+func (s *State) drain(ctx context.Context, wg *sync.WaitGroup) error {
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		if s.ctx.Err() == nil {
+			log.Println("Aborting webhook deliveries that did not finish before the shutdown deadline")
+			s.cancel()
+		}
+		return ctx.Err()
+	}
+}
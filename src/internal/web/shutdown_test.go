@@ -0,0 +1,93 @@
+package web
+
+import (
+	"assignment2/internal/journal"
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	dir := t.TempDir()
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, JournalConfig(dir), WarmupConfig(0, 0))
+	s.newRegistration(types.InvocationRegistration{WebhookID: "flushed", URL: "http://example.com", Country: "NOR", Calls: 1})
+
+	// Test 1: queued webhook deliveries are drained, and pending updates are written to the store
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer slow.Close()
+	s.dispatcher.enqueue(webhookEvent{URL: slow.URL, Payload: WebhookResponse{WebhookID: "flushed"}})
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if stats := s.getDispatcherStats(); stats.Delivered != 1 {
+		t.Fatal("expected queued webhook to be delivered, got: ", stats.Delivered)
+	}
+	if registrations, _ := s.store.GetAllRegistrations(); len(registrations) != 1 {
+		t.Fatal("expected pending registration to be written on shutdown")
+	}
+	if stats := s.getQueueStats(); stats.Pending != 0 {
+		t.Fatal("expected no pending updates after shutdown, got: ", stats.Pending)
+	}
+
+	// Test 2: deliveries that do not finish before the deadline are aborted, and reported
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request is only cancelled on disconnect once its body has been read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer stuck.Close()
+	s = NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, WarmupConfig(0, 0))
+	s.newRegistration(types.InvocationRegistration{WebhookID: "stuck", URL: stuck.URL, Country: "NOR", Calls: 1})
+	s.dispatcher.enqueue(webhookEvent{URL: stuck.URL, Payload: WebhookResponse{WebhookID: "stuck"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Close(ctx); err == nil {
+		t.Fatal("expected error when in-flight deliveries are aborted")
+	}
+
+	// Test 3: a final write stuck in the store is left behind once the deadline expires, and the pending
+	// updates are kept in the journal
+	hanging := hangingStore{Store: store.NewMemory(), release: make(chan struct{})}
+	defer close(hanging.release)
+	dir = t.TempDir()
+	s = NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), sharedStore{store: hanging}, JournalConfig(dir), WarmupConfig(0, 0))
+	s.newRegistration(types.InvocationRegistration{WebhookID: "pending", URL: "http://example.com", Country: "NOR", Calls: 1})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Close(ctx); err == nil {
+		t.Fatal("expected error when the final write does not finish")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("expected Close to return once the deadline expired, took: ", elapsed)
+	}
+	replayed, err := journal.Open(dir)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	defer replayed.Close()
+	if _, ok := replayed.PendingRegistrations()["pending"]; !ok {
+		t.Fatal("expected the pending updates to be kept in the journal")
+	}
+}
+
+// hangingStore is a store whose bulk writes do not return until it is released, like a store that
+// cannot be reached
+type hangingStore struct {
+	store.Store
+	release chan struct{}
+}
+
+// BulkWrite waits for the store to be released
+func (h hangingStore) BulkWrite(updates *types.BundledUpdate) error {
+	<-h.release
+	return h.Store.BulkWrite(updates)
+}
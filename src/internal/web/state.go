@@ -2,70 +2,244 @@ package web
 
 import (
 	"assignment2/api"
-	"assignment2/internal/firebase_client"
+	"assignment2/internal/cache"
+	"assignment2/internal/journal"
+	"assignment2/internal/store"
 	"assignment2/internal/types"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"golang.org/x/sync/singleflight"
 	"log"
 	"net/http"
-	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // State represents the application state and holds the necessary data and channels.
 type State struct {
-	db               types.RenewableDB
-	invocationCounts map[string]int64
+	dataset          atomic.Pointer[types.Dataset] // replaced when the dataset is reloaded
+	datasetPath      string                        // CSV file the dataset is loaded from, or res.Embedded
+	reload           sync.Mutex                    // serialises reloads of the dataset
+	httpCacheMaxAge  time.Duration
+	cacheTTLPolicy   map[string]time.Duration
+	adminToken       string
+	sweepStats       CacheSweepStats
+	snapshotStats    SnapshotStats
+	warmup           WarmupStatus
+	invocationCounts map[string]int64    // last known counts of all instances, plus the increments of this instance not yet in the store
+	countDeltas      map[string]int64    // increments of invocation counts since they were last queued for the store
+	buckets          map[bucketKey]int64 // increments of hourly and daily invocation buckets since they were last queued for the store
+	bucketsPruned    int64               // start of the hour the buckets were last pruned in, without a store
+	instanceID       string              // identifies the invocation batches and leases of this instance in the store
+	batchSeq         int64               // sequence number of the last invocation batch, starting from the time so that it increases across restarts
+	dispatched       map[string]int64    // invocation count of the country of every registration at its last dispatch
 	registrations    map[string]types.InvocationRegistration
-	firestoreMode    firestoreMode
+	byCountry        map[string]map[string]types.InvocationRegistration // registrations by country and webhook ID, kept in step with registrations
+	dispatcher       *dispatcher                                        // posts the webhooks that have been triggered
+	deadLetters      map[string]types.DeadLetter                        // webhooks that could not be delivered, by their ID
+	deliveries       map[string]*deliveryRing                           // most recent delivery attempts of every registration, by webhook ID
+	deliveryLogSize  int                                                // number of delivery attempts kept for every registration
+	storageMode      storageMode
+	store            store.Store // nil when running without a store
 	countriesAPIMode restCountriesMode
+	cache            cache.Cache
+	requests         singleflight.Group // coalesces concurrent computations of the same response
 	lock             sync.RWMutex
-	chInvocation     chan string
-	chRegistration   chan types.RegistrationAction
-	chCache          chan map[string]types.YearRecordList
+	queue            *journal.Journal // write-behind queue of updates to the store, nil without a store
+	flush            sync.Mutex       // serialises flushes of the queue with imports of archives into the store
+	ctx              context.Context  // cancelled when in-flight webhook deliveries are aborted on shutdown
+	cancel           context.CancelFunc
+	stop             chan struct{}  // closed to stop the background workers
+	workers          sync.WaitGroup // background workers that are stopped on shutdown
 }
 
-// NewService initializes a new State with the provided CSV filepath and mode.
-func NewService(filepath string, countriesMode restCountriesMode, firebaseMode firestoreMode) *State {
+// NewService initializes a new State with the provided CSV filepath and mode. If the filepath
+// is res.Embedded, then the dataset compiled into the binary is used.
+func NewService(filepath string, countriesMode restCountriesMode, storage storageMode, options ...Option) *State {
+	config := defaultOptions()
+	for _, option := range options {
+		option(&config)
+	}
+
+	dataset := types.LoadDataset(filepath)
+	st, err := storage.open()
+	if err != nil {
+		log.Fatal("Could not open store: ", err)
+	}
+	if st != nil && config.MigrateOnStartup {
+		report, err := st.Migrate(false)
+		if err != nil {
+			log.Fatal("Could not migrate store: ", err)
+		}
+		log.Println("Store schema: " + report.String())
+	}
+	if config.InstanceID == "" {
+		config.InstanceID = newInstanceID()
+	}
 	s := State{
-		db:               types.ParseCSV(filepath),
-		invocationCounts: firebaseMode.GetAllInvocationCounts(),
-		registrations:    firebaseMode.GetAllInvocationRegistrations(),
-		firestoreMode:    firebaseMode,
+		datasetPath:      filepath,
+		httpCacheMaxAge:  config.HTTPCacheMaxAge,
+		cacheTTLPolicy:   config.CacheTTLPolicy,
+		adminToken:       config.AdminToken,
+		invocationCounts: map[string]int64{},
+		countDeltas:      map[string]int64{},
+		buckets:          map[bucketKey]int64{},
+		instanceID:       config.InstanceID,
+		batchSeq:         time.Now().UnixNano(),
+		dispatched:       map[string]int64{},
+		registrations:    map[string]types.InvocationRegistration{},
+		byCountry:        map[string]map[string]types.InvocationRegistration{},
+		deadLetters:      map[string]types.DeadLetter{},
+		deliveries:       map[string]*deliveryRing{},
+		deliveryLogSize:  config.DeliveryLogSize,
+		storageMode:      storage,
+		store:            st,
 		countriesAPIMode: countriesMode,
+		stop:             make(chan struct{}),
+	}
+	s.dataset.Store(&dataset)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.dispatcher = newDispatcher(s.ctx, config.WebhookWorkers, config.WebhookQueueSize, config.WebhookHostLimit,
+		config.WebhookMaxAttempts, config.WebhookBackoff, s.addDeadLetter)
+	s.dispatcher.post = s.deliverWebhook
+
+	// Load the persisted state, replay the updates that had not been written to the store when the
+	// service stopped, and start the worker for updating the store, unless running without a store
+	if st != nil {
+		if counts, err := st.GetAllInvocationCounts(); err == nil {
+			s.invocationCounts = counts
+		} else {
+			log.Println("Could not load invocation counts: " + err.Error())
+		}
+		if registrations, err := st.GetAllRegistrations(); err == nil {
+			s.replaceRegistrationsLocked(registrations)
+		} else {
+			log.Println("Could not load registrations: " + err.Error())
+		}
+		if deadLetters, err := st.GetAllDeadLetters(); err == nil {
+			s.deadLetters = deadLetters
+		} else {
+			log.Println("Could not load dead letters: " + err.Error())
+		}
+		if logs, err := st.GetAllDeliveries(); err == nil {
+			for _, deliveries := range logs {
+				s.setDeliveriesLocked(deliveries)
+			}
+		} else {
+			log.Println("Could not load delivery logs: " + err.Error())
+		}
+		if s.queue, err = journal.Open(config.JournalDir); err != nil {
+			log.Fatal("Could not open journal: ", err)
+		}
+		s.applyUpdates(s.queue.Pending())
+		// the threshold registrations are evaluated against the dataset loaded at startup, which may
+		// have changed while the service was stopped
+		s.evaluateThresholds(dataset.DB)
+		s.workers.Add(1)
+		go storeUpdateWorker(&s)
 	}
 
-	// Initialize channels and start the worker for updating Firebase in WithFirestore firebaseMode
-	switch firebaseMode.(type) {
-	case WithFirestore:
-		s.chInvocation = make(chan string, 1000)
-		s.chRegistration = make(chan types.RegistrationAction, 10)
-		s.chCache = make(chan map[string]types.YearRecordList, 100)
-		go firebaseUpdateWorker(&s)
+	// Responses are cached in memory, and with a store also shared through the store
+	s.cache = cache.NewLRU(config.CacheSize, config.CacheTTL)
+	if st != nil {
+		s.cache = cache.NewLayered(s.cache, cache.NewPersistent(config.FirestoreCacheTTL, st, s.queue))
+	}
+
+	// The cache is warmed up with the most requested countries before the service is returned, and thereby
+	// before it accepts requests. If the warm-up takes longer than the timeout, it continues in the background
+	// and the service reports that it is not ready to receive traffic until the warm-up has completed.
+	s.warmup.Ready = true
+	if config.WarmupSize > 0 {
+		s.warmup.Ready = false
+		done := make(chan struct{})
+		go func() {
+			s.warmUp(s.topCountries(config.WarmupSize))
+			close(done)
+		}()
+		if config.WarmupTimeout > 0 {
+			select {
+			case <-done:
+			case <-time.After(config.WarmupTimeout):
+				log.Printf("Warm-up did not complete within %s, continuing in the background", config.WarmupTimeout)
+			}
+		}
+	}
+
+	// Expired cache entries are purged in the background, as they are otherwise only removed when requested again
+	if config.CacheSweepInterval > 0 {
+		s.sweepStats.Interval = int(config.CacheSweepInterval.Seconds())
+		s.workers.Add(1)
+		go cacheSweepWorker(&s, config.CacheSweepInterval)
+	}
+
+	// The store is snapshotted to a local directory in the background, keeping the latest snapshots
+	if st != nil && config.SnapshotDir != "" && config.SnapshotInterval > 0 {
+		s.snapshotStats = SnapshotStats{Dir: config.SnapshotDir, Interval: int(config.SnapshotInterval.Seconds()), Keep: config.SnapshotKeep}
+		s.workers.Add(1)
+		go snapshotWorker(&s, config.SnapshotInterval)
 	}
 
 	return &s
 }
 
-// WithoutFirestore represents a mode where the Firestore interaction is disabled.
+// newInstanceID returns an identifier for the instance from the host name, which is the container ID in
+// Docker, and a random suffix, so that a restarted instance does not reuse the identifier of the old one
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "instance"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// cacheTTL returns the time a response for the path is cached according to the TTL policy, where the
+// longest matching endpoint path wins. Zero is returned if no policy matches, which uses the default
+// TTL of each cache layer.
+func (s *State) cacheTTL(path string) time.Duration {
+	var ttl time.Duration
+	longest := -1
+	for endpoint, endpointTTL := range s.cacheTTLPolicy {
+		if strings.HasPrefix(path, endpoint) && len(endpoint) > longest {
+			ttl, longest = endpointTTL, len(endpoint)
+		}
+	}
+	return ttl
+}
+
+// WithoutFirestore represents a mode where nothing is persisted, and registrations and invocation
+// counts are lost on restart.
 type WithoutFirestore struct{}
 
-// WithFirestore represents a mode where the Firestore interaction is enabled.
+// WithFirestore represents a mode where the state is persisted in Firestore.
 type WithFirestore struct{}
 
+// WithStore represents a mode where the state is persisted in one of the other store backends, such
+// as SQLite or a JSON file at Path. See store.Open for the available backends.
+type WithStore struct {
+	Backend string
+	Path    string
+}
+
 // StubRestCountries represents a mode where the Countries API is stubbed.
 type StubRestCountries struct{}
 
-// UseRestCountries represents a mode where the 3rd party API is used.
-type UseRestCountries struct{}
+// UseRestCountries represents a mode where the 3rd party API is used. BaseURL can be set
+// to use a mirror of the API, otherwise api.API_BASE is used.
+type UseRestCountries struct {
+	BaseURL string
+}
 
-// firestoreMode defines an interface for managing cache and invocation counts. Updates are handled
-// by channels and go routine instead.
-type firestoreMode interface {
-	GetCacheFromFirebase(url *url.URL) (types.YearRecordList, error)
-	GetAllInvocationCounts() map[string]int64
-	GetAllInvocationRegistrations() map[string]types.InvocationRegistration
-	getNotificationDBStatus() int
+// storageMode defines an interface for opening the store where registrations, invocation counts and
+// cached responses are persisted. Updates are queued and written in bulk by storeUpdateWorker instead.
+type storageMode interface {
+	open() (store.Store, error)
 }
 
 // deleteRegistration removes a registration from the state's registrations map by its webhookID.
@@ -75,8 +249,10 @@ func (s *State) deleteRegistration(webhookID string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	if registration, ok := s.registrations[webhookID]; ok {
-		delete(s.registrations, webhookID)
-		updateFirestore(s.chRegistration, types.RegistrationAction{Add: false, Registration: registration})
+		s.removeRegistrationLocked(webhookID)
+		delete(s.dispatched, webhookID)
+		delete(s.deliveries, webhookID)
+		s.queueRegistration(types.RegistrationAction{Add: false, Registration: registration})
 		return nil
 	} else {
 		return errors.New("Could not find the webhookID: " + webhookID)
@@ -113,21 +289,234 @@ func (s *State) getAllRegistrations() []types.InvocationRegistration {
 }
 
 // newRegistration adds a new registration to the state's registrations map and updates Firestore
-// with the new entry.
+// with the new entry. Only invocations after the registration trigger its webhook.
 func (s *State) newRegistration(registration types.InvocationRegistration) {
-	newEntry := types.RegistrationAction{Add: true, Registration: registration}
-	updateFirestore(s.chRegistration, newEntry)
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.setRegistrationLocked(registration)
+	// queued while holding the lock, so that refreshRegistrations does not drop the registration meanwhile
+	s.queueRegistration(types.RegistrationAction{Add: true, Registration: registration, Checkpoint: s.invocationCounts[registration.Country]})
+}
+
+// refreshRegistrations replaces the registrations with those in the store, which include the registrations
+// created, deleted and changed by other instances, with the registrations of this instance that have not
+// been written to the store yet applied over them. Registrations deleted by another instance are dropped
+// together with their last dispatch and delivery log, so that their webhooks are no longer posted. Callers
+// must hold the flush lock, so that no registrations are written to the store meanwhile.
+func (s *State) refreshRegistrations() error {
+	registrations, err := s.store.GetAllRegistrations()
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for webhookID, action := range s.queue.PendingRegistrations() {
+		if action.Add {
+			registrations[webhookID] = action.Registration
+		} else {
+			delete(registrations, webhookID)
+		}
+	}
+	for webhookID := range s.registrations {
+		if _, ok := registrations[webhookID]; !ok {
+			delete(s.dispatched, webhookID)
+			delete(s.deliveries, webhookID)
+		}
+	}
+	s.replaceRegistrationsLocked(registrations)
+	return nil
+}
+
+// rotateRegistrationSecret replaces the secret of a registration, and keeps the old secret for signing
+// the webhooks until `grace` from now, unless `grace` is zero or the registration had no secret. The
+// secret is only replaced if `authorized` accepts the registration as it is when the secret is replaced,
+// so that concurrent rotations cannot both succeed with the same secret. The updated registration is
+// returned, or an error if it is not found or errSecretUnauthorized.
+func (s *State) rotateRegistrationSecret(webhookID string, secret string, grace time.Duration,
+	authorized func(current types.InvocationRegistration) bool) (types.InvocationRegistration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	registration, ok := s.registrations[webhookID]
+	if !ok {
+		return registration, errors.New("Could not find the webhookID: " + webhookID)
+	}
+	if !authorized(registration) {
+		return types.InvocationRegistration{}, errSecretUnauthorized
+	}
+	registration.PreviousSecret, registration.PreviousSecretExpires = "", nil
+	if grace > 0 && registration.Secret != "" {
+		expires := time.Now().Add(grace).UTC()
+		registration.PreviousSecret, registration.PreviousSecretExpires = registration.Secret, &expires
+	}
+	registration.Secret = secret
+	s.setRegistrationLocked(registration)
+	// the lease of the registration exists already, and is only created with the checkpoint if it was lost
+	s.queueRegistration(types.RegistrationAction{Add: true, Registration: registration, Checkpoint: s.invocationCounts[registration.Country]})
+	return registration, nil
+}
+
+// applyUpdates applies updates replayed from the journal to the invocation counts, registrations, dead
+// letters and delivery logs loaded from the store, as they are newer
+func (s *State) applyUpdates(updates *types.BundledUpdate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, batch := range updates.Invocations {
+		for countryCode, count := range batch.Counts {
+			s.invocationCounts[countryCode] += count
+		}
+	}
+	for webhookID, action := range updates.Registrations {
+		if action.Add {
+			s.setRegistrationLocked(action.Registration)
+		} else {
+			s.removeRegistrationLocked(webhookID)
+			delete(s.deliveries, webhookID)
+		}
+	}
+	for webhookID, deliveries := range updates.Deliveries {
+		if _, ok := s.registrations[webhookID]; ok {
+			s.setDeliveriesLocked(deliveries)
+		}
+	}
+	for id, action := range updates.DeadLetters {
+		if action.Add {
+			s.deadLetters[id] = action.DeadLetter
+		} else {
+			delete(s.deadLetters, id)
+		}
+	}
+}
+
+// setRegistrationLocked adds or replaces a registration, and indexes it by its country. The caller must hold the lock.
+func (s *State) setRegistrationLocked(registration types.InvocationRegistration) {
+	s.removeRegistrationLocked(registration.WebhookID)
 	s.registrations[registration.WebhookID] = registration
+	country, ok := s.byCountry[registration.Country]
+	if !ok {
+		country = map[string]types.InvocationRegistration{}
+		s.byCountry[registration.Country] = country
+	}
+	country[registration.WebhookID] = registration
+}
+
+// removeRegistrationLocked removes a registration, if it exists, and its entry in the index by country.
+// The caller must hold the lock.
+func (s *State) removeRegistrationLocked(webhookID string) {
+	registration, ok := s.registrations[webhookID]
+	if !ok {
+		return
+	}
+	delete(s.registrations, webhookID)
+	delete(s.byCountry[registration.Country], webhookID)
+	if len(s.byCountry[registration.Country]) == 0 {
+		delete(s.byCountry, registration.Country)
+	}
+}
+
+// replaceRegistrationsLocked replaces all registrations, and rebuilds the index by country. The caller
+// must hold the lock.
+func (s *State) replaceRegistrationsLocked(registrations map[string]types.InvocationRegistration) {
+	s.registrations = map[string]types.InvocationRegistration{}
+	s.byCountry = map[string]map[string]types.InvocationRegistration{}
+	for _, registration := range registrations {
+		s.setRegistrationLocked(registration)
+	}
+}
+
+// getRegistrationsByCountry returns the registrations for a country, without scanning the other registrations
+func (s *State) getRegistrationsByCountry(countryCode string) []types.InvocationRegistration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]types.InvocationRegistration, 0, len(s.byCountry[countryCode]))
+	for _, registration := range s.byCountry[countryCode] {
+		list = append(list, registration)
+	}
+	return list
+}
+
+// getRegisteredCountries returns the countries that have at least one registration
+func (s *State) getRegisteredCountries() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	countries := make([]string, 0, len(s.byCountry))
+	for countryCode := range s.byCountry {
+		countries = append(countries, countryCode)
+	}
+	return countries
+}
+
+// getDispatcherStats returns the counters of the webhook dispatcher
+func (s *State) getDispatcherStats() *WebhookDispatcherStats {
+	stats := s.dispatcher.getStats()
+	stats.DeadLetters = s.getNumberOfDeadLetters()
+	return &stats
+}
+
+// queueRegistration journals a new or deleted registration, to be written to the store by
+// storeUpdateWorker. Nothing is queued when running without a store.
+func (s *State) queueRegistration(action types.RegistrationAction) {
+	if s.queue != nil {
+		s.queue.AddRegistration(action)
+	}
+}
+
+// queueCountDeltas swaps the counter deltas for empty maps, and journals them as the next invocation
+// batch of this instance, to be added to the store by storeUpdateWorker. The batch is returned. Nothing
+// is queued when running without a store, or when nothing was invocated since the last call. Calls must
+// not overlap, so that batches are queued in the order of their sequence numbers.
+func (s *State) queueCountDeltas() types.InvocationBatch {
+	if s.queue == nil {
+		return types.InvocationBatch{}
+	}
+	s.lock.Lock()
+	batch := types.InvocationBatch{Instance: s.instanceID, Counts: s.countDeltas, Buckets: s.swapBucketDeltasLocked()}
+	s.countDeltas = make(map[string]int64, len(batch.Counts))
+	s.batchSeq++
+	batch.Seq = s.batchSeq
+	s.lock.Unlock()
+
+	if len(batch.Counts) > 0 {
+		s.queue.AddInvocations(batch)
+	}
+	return batch
+}
+
+// refreshInvocationCounts reads the invocation counts of the countries from the store, which include the
+// invocations of all instances, and adds the increments of this instance that are not in the store yet.
+// The counts in the store are returned.
+func (s *State) refreshInvocationCounts(countries []string) (map[string]int64, error) {
+	totals, err := s.store.GetInvocationCounts(countries)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for countryCode, total := range totals {
+		s.invocationCounts[countryCode] = total + s.countDeltas[countryCode]
+	}
+	return totals, nil
+}
+
+// getQueueStats returns the number of updates waiting to be written to the store, or nil without a store
+func (s *State) getQueueStats() *journal.Stats {
+	if s.queue == nil {
+		return nil
+	}
+	stats := s.queue.Stats()
+	return &stats
 }
 
 // incrementInvocationCount increments the invocation count for a given countryCode and returns
-// the updated count. The invocation count is stored in the state's invocationCounts map.
+// the updated count. The invocation count is stored in the state's invocationCounts map, and the
+// increment is recorded in the countDeltas map until it is queued for the store. The hourly and daily
+// invocation buckets of the country are incremented as well. With a store shared by several instances,
+// the count only includes the invocations of other instances up to the last store update.
 func (s *State) incrementInvocationCount(countryCode string) int64 {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.invocationCounts[countryCode]++
+	s.countDeltas[countryCode]++
+	s.incrementBucketsLocked(countryCode, time.Now())
 	return s.invocationCounts[countryCode]
 }
 
@@ -139,13 +528,19 @@ func (s *State) getInvocationCount(countryCode string) int64 {
 	return s.invocationCounts[countryCode]
 }
 
-func (s *State) getCurrentRenewable(countryCode string, includeNeighbours bool) types.YearRecordList {
-	data := s.db.RetrieveLatest(countryCode)
-	if len(countryCode) > 0 && includeNeighbours {
-		neighbours, err := s.countriesAPIMode.getNeighboursCca(countryCode)
+// getDataset returns the dataset the responses are computed from. The dataset is replaced as a whole
+// when it is reloaded, so callers should keep the returned dataset while computing a response.
+func (s *State) getDataset() *types.Dataset {
+	return s.dataset.Load()
+}
+
+func (s *State) getCurrentRenewable(db types.RenewableDB, countryCode string, includeNeighbours bool) types.YearRecordList {
+	data := db.RetrieveLatest(countryCode)
+	if len(countryCode) > 0 && len(data) > 0 && includeNeighbours {
+		country, err := s.countriesAPIMode.getCountry(countryCode)
 		if err == nil {
-			for _, neighbour := range neighbours {
-				data = append(data, s.db.RetrieveLatest(neighbour)...)
+			for _, neighbour := range country.Borders {
+				data = append(data, db.RetrieveLatest(neighbour)...)
 			}
 		}
 	}
@@ -155,131 +550,96 @@ func (s *State) getCurrentRenewable(countryCode string, includeNeighbours bool)
 // restCountriesMode defines an interface for either using the stubbed RESTCountries service or the
 // real 3rd party service
 type restCountriesMode interface {
-	getNeighboursCca(cca string) ([]string, error)
+	getCountry(cca string) (api.Country, error)
+	getAllCountries() ([]api.Country, error)
 	getRestCountriesStatus() int
 }
 
-// getNeighboursCca returns neighbouring list from stubbed country api
-func (t StubRestCountries) getNeighboursCca(cca string) ([]string, error) {
-	val, err := api.GetNeighboursCca(cca, api.STUB_BASE)
-	return val, err
+// statusReporter is implemented by modes that have additional details to add to the status endpoint
+type statusReporter interface {
+	reportStatus(status *APIStatus)
 }
 
-// getNeighboursCca returns neighbouring list from 3rd party country api
-func (p UseRestCountries) getNeighboursCca(cca string) ([]string, error) {
-	val, err := api.GetNeighboursCca(cca, api.API_BASE)
-	return val, err
+// getCountry returns the country metadata from stubbed country api
+func (t StubRestCountries) getCountry(cca string) (api.Country, error) {
+	return api.GetCountryCca(cca, api.STUB_BASE)
 }
 
-func (t StubRestCountries) getRestCountriesStatus() int {
-	return getStatusCode(api.STUB_BASE + api.API_VERSION + "/alpha/nor")
+// getCountry returns the country metadata from 3rd party country api
+func (p UseRestCountries) getCountry(cca string) (api.Country, error) {
+	return api.GetCountryCca(cca, p.baseURL())
 }
 
-func (p UseRestCountries) getRestCountriesStatus() int {
-	return getStatusCode(api.API_BASE + api.API_VERSION + "/alpha/nor")
+// getAllCountries returns the metadata for all countries from stubbed country api
+func (t StubRestCountries) getAllCountries() ([]api.Country, error) {
+	return api.GetAllCountries(api.STUB_BASE)
 }
 
-// GetCacheFromFirebase returns an error as caching is disabled in WithoutFirestore mode.
-func (t WithoutFirestore) GetCacheFromFirebase(_ *url.URL) (types.YearRecordList, error) {
-	return types.YearRecordList{}, errors.New("firebase disabled")
+// getAllCountries returns the metadata for all countries from 3rd party country api
+func (p UseRestCountries) getAllCountries() ([]api.Country, error) {
+	return api.GetAllCountries(p.baseURL())
 }
 
-// GetCacheFromFirebase retrieves the cached data from Firestore in WithFirestore mode.
-func (p WithFirestore) GetCacheFromFirebase(url *url.URL) (types.YearRecordList, error) {
-	client, err := firebase_client.NewFirebaseClient()
-	if err != nil {
-		log.Println("Could not start firebase client")
-		return types.YearRecordList{}, errors.New("could not start firebase client")
-	}
-	defer client.Close()
-	data, timestamp, err := client.GetRenewablesCache(url.String())
-	twoMinutesAgo := time.Now().Add(-24 * 7 * time.Hour)
-	if timestamp.Before(twoMinutesAgo) {
-		client.DeleteRenewablesCache(url.String())
-		return data, errors.New("old cache, deleted object")
+func (t StubRestCountries) getRestCountriesStatus() int {
+	return getStatusCode(api.STUB_BASE + api.API_VERSION + "/alpha/nor")
+}
 
-	}
-	if err != nil {
-		return data, errors.New("cache not found")
-	}
-	return data, nil
+func (p UseRestCountries) getRestCountriesStatus() int {
+	return getStatusCode(p.baseURL() + api.API_VERSION + "/alpha/nor")
 }
 
-// GetAllInvocationCounts retrieves all invocation counts from Firestore in WithFirestore mode.
-func (p WithFirestore) GetAllInvocationCounts() map[string]int64 {
-	data := map[string]int64{}
-	if client, err := firebase_client.NewFirebaseClient(); err == nil {
-		if err != nil {
-			log.Println("Could not start firebase client")
-			return data
-		}
-		defer client.Close()
-		docs, err := client.GetAllDocuments(firebase_client.CollectionInvocationCounts)
-		if err != nil {
-			log.Printf("Could not fetch invocation counts from firestore")
-			return data
-		}
-		for _, docField := range docs {
-			if count, err := docField.DataAt("count"); err == nil {
-				data[docField.Ref.ID] = count.(int64)
-			}
-		}
+// baseURL returns the configured base URL of the 3rd party api, with a trailing slash
+func (p UseRestCountries) baseURL() string {
+	if len(p.BaseURL) == 0 {
+		return api.API_BASE
 	}
-	return data
+	return strings.TrimSuffix(p.BaseURL, "/") + "/"
 }
 
-// GetAllInvocationCounts returns an empty map as the feature is disabled in WithoutFirestore mode.
-func (t WithoutFirestore) GetAllInvocationCounts() map[string]int64 {
-	return map[string]int64{}
+// open returns no store, as nothing is persisted in WithoutFirestore mode
+func (t WithoutFirestore) open() (store.Store, error) {
+	return nil, nil
 }
 
-// GetAllInvocationRegistrations retrieves all invocation registrations from Firestore in WithFirestore mode.
-func (p WithFirestore) GetAllInvocationRegistrations() map[string]types.InvocationRegistration {
-	result := map[string]types.InvocationRegistration{}
-	if client, err := firebase_client.NewFirebaseClient(); err == nil {
-		if err != nil {
-			log.Println("Could not start firebase client")
-			return result
-		}
-		defer client.Close()
-		docs, err := client.GetAllDocuments(firebase_client.CollectionInvocationRegistrations)
-		if err != nil {
-			log.Printf("Could not fetch data from firestore")
-			return result
-		}
-		for _, doc := range docs {
-			var registration types.InvocationRegistration
-			err = doc.DataTo(&registration)
-			if err != nil {
-				return result
-			}
-			result[doc.Ref.ID] = registration
-		}
-	}
-	return result
+// open returns the Firestore store in WithFirestore mode
+func (p WithFirestore) open() (store.Store, error) {
+	return store.NewFirestore()
 }
 
-// GetAllInvocationRegistrations returns an empty map as invocation registrations are not supported in WithoutFirestore mode.
-func (t WithoutFirestore) GetAllInvocationRegistrations() map[string]types.InvocationRegistration {
-	return map[string]types.InvocationRegistration{}
-
+// open returns the configured store backend in WithStore mode
+func (p WithStore) open() (store.Store, error) {
+	return store.Open(p.Backend, p.Path)
 }
 
-// GetNotificationDBStatus for WithFirestore type returns Notification DB status code.
-// It handles client creation errors and returns appropriate status codes.
-func (p WithFirestore) getNotificationDBStatus() int {
-	client, err := firebase_client.NewFirebaseClient()
-	defer client.Close()
-	if err != nil {
-		// Handle any error from the Firebase client
-		return http.StatusInternalServerError
+// ParseStorageMode returns the storage mode for a backend name: "none" for WithoutFirestore,
+// "firestore" for WithFirestore, or any other backend supported by store.Open, using `path`
+// as the database or JSON file.
+func ParseStorageMode(backend string, path string) (storageMode, error) {
+	switch backend {
+	case "none":
+		return WithoutFirestore{}, nil
+	case store.Firestore:
+		return WithFirestore{}, nil
+	case store.SQLite, store.JSONFile, store.Memory:
+		return WithStore{Backend: backend, Path: path}, nil
+	default:
+		return nil, errors.New("unknown store backend \"" + backend + "\", expected none, firestore, sqlite, json or memory")
 	}
-	// Set the Notification DB status to OK if no error
-	return http.StatusOK
 }
 
-// GetNotificationDBStatus for WithoutFirestore type returns a
-// service unavailable status code, as Notification DB isn't used in this mode.
-func (t WithoutFirestore) getNotificationDBStatus() int {
-	return http.StatusServiceUnavailable
+// getNotificationDBStatus returns the status of the store holding the webhook registrations: 200 OK if a
+// lightweight read succeeds, 500 Internal Server Error if not, and 503 Service Unavailable when running
+// without a store. The outcome and latency of the read are returned as the store health.
+func (s *State) getNotificationDBStatus() (int, *StoreHealth) {
+	if s.store == nil {
+		return http.StatusServiceUnavailable, nil
+	}
+	start := time.Now()
+	err := s.store.Ping()
+	health := &StoreHealth{Backend: s.store.Name(), Healthy: err == nil, Latency: time.Since(start).Milliseconds()}
+	if err != nil {
+		health.Error = err.Error()
+		return http.StatusInternalServerError, health
+	}
+	return http.StatusOK, health
 }
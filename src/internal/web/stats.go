@@ -0,0 +1,298 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"assignment2/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// granularities are the invocation bucket granularities that are counted for every invocation
+var granularities = []string{types.Hourly, types.Daily}
+
+// statsRetention is the time the buckets of every granularity are kept in memory without a store, which
+// covers the default period of the statistics and longer periods queried with `from`
+var statsRetention = map[string]time.Duration{types.Hourly: HourlyStatsRetention, types.Daily: DailyStatsRetention}
+
+// bucketKey identifies an invocation bucket in memory, without building its document ID on every invocation
+type bucketKey struct {
+	country     string
+	granularity string
+	start       int64 // unix time of the start of the bucket
+}
+
+// bucket returns the invocation bucket with the count
+func (k bucketKey) bucket(count int64) types.InvocationBucket {
+	return types.InvocationBucket{Country: k.country, Granularity: k.granularity, Start: time.Unix(k.start, 0).UTC(), Count: count}
+}
+
+// InvocationStats is the response of the invocation statistics endpoint
+type InvocationStats struct {
+	Country     string                   `json:"country,omitempty"`
+	Granularity string                   `json:"granularity"`
+	From        time.Time                `json:"from"`
+	To          time.Time                `json:"to"`
+	Total       int64                    `json:"total"`
+	Buckets     []types.InvocationBucket `json:"buckets"` // buckets without invocations are left out
+}
+
+// CountryRank is an entry in the leaderboard of the most requested countries
+type CountryRank struct {
+	Rank    int    `json:"rank"`
+	Country string `json:"country"`
+	Name    string `json:"name"`
+	Count   int64  `json:"count"`
+}
+
+// statsQuery is a validated request to the statistics endpoints
+type statsQuery struct {
+	country     string // upper-cased ISO code, or empty for all countries
+	granularity string
+	from        time.Time
+	to          time.Time
+	limit       int // entries in the leaderboard
+	period      bool
+}
+
+// StatsHandler serves the invocation statistics per hour or day, and the leaderboard of the most
+// requested countries
+func (s *State) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	segments := utils.GetSegments(r.URL, StatsPath)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET Method is supported", http.StatusBadRequest)
+		return
+	}
+	if len(segments) != 1 || (segments[0] != "invocations" && segments[0] != "top") {
+		http.Error(w, "Usage: "+StatsPath+"invocations{?country=code&from=date&to=date&granularity=hour|day} or "+
+			StatsPath+"top{?limit=n&from=date&to=date}", http.StatusBadRequest)
+		return
+	}
+	query, err := parseStatsQuery(r.URL, s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if segments[0] == "top" {
+		leaderboard, err := s.getLeaderboard(query)
+		if err != nil {
+			http.Error(w, "Could not read invocation statistics", http.StatusInternalServerError)
+			return
+		}
+		httpRespondJSON(w, leaderboard, nil)
+		return
+	}
+
+	buckets, err := s.getInvocationBuckets(query)
+	if err != nil {
+		http.Error(w, "Could not read invocation statistics", http.StatusInternalServerError)
+		return
+	}
+	stats := InvocationStats{Country: query.country, Granularity: query.granularity, From: query.from, To: query.to, Buckets: buckets}
+	for _, bucket := range buckets {
+		stats.Total += bucket.Count
+	}
+	httpRespondJSON(w, stats, nil)
+}
+
+// parseStatsQuery validates the query parameters of the statistics endpoints. Dates are either a day
+// such as 2023-04-20, where `to` includes the whole day, or a time in RFC 3339 format. By default, the
+// statistics cover the last 7 days, or the last 24 hours with hourly granularity.
+func parseStatsQuery(u *url.URL, s *State) (statsQuery, error) {
+	params := u.Query()
+	q := statsQuery{granularity: types.Daily, limit: 10}
+
+	if country := params.Get("country"); country != "" {
+		q.country = strings.ToUpper(country)
+		if _, ok := s.getDataset().DB[q.country]; !ok {
+			return q, errors.New("unknown country: " + country)
+		}
+	}
+	if granularity := params.Get("granularity"); granularity != "" {
+		if granularity != types.Hourly && granularity != types.Daily {
+			return q, errors.New("granularity must be hour or day")
+		}
+		q.granularity = granularity
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, errors.New("limit must be a positive number")
+		}
+		q.limit = n
+	}
+
+	var err error
+	q.to = time.Now().UTC()
+	if to := params.Get("to"); to != "" {
+		if q.to, err = parseStatsDate(to, true); err != nil {
+			return q, err
+		}
+	}
+	q.from = q.to.Add(-7 * 24 * time.Hour)
+	if q.granularity == types.Hourly {
+		q.from = q.to.Add(-24 * time.Hour)
+	}
+	if from := params.Get("from"); from != "" {
+		if q.from, err = parseStatsDate(from, false); err != nil {
+			return q, err
+		}
+	}
+	if !q.from.Before(q.to) {
+		return q, errors.New("from must be before to")
+	}
+	q.period = params.Has("from") || params.Has("to")
+	return q, nil
+}
+
+// parseStatsDate parses a day or a time in RFC 3339 format. If `end` is true, a day is parsed as the end of the day.
+func parseStatsDate(value string, end bool) (time.Time, error) {
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		if end {
+			day = day.Add(24 * time.Hour)
+		}
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, errors.New("invalid date " + value + ", expected YYYY-MM-DD or RFC 3339")
+	}
+	return t.UTC(), nil
+}
+
+// getInvocationBuckets returns the invocation buckets matching the query, ordered by start and country.
+// Buckets are read from the store, and the increments that are queued or only kept in memory are added.
+func (s *State) getInvocationBuckets(q statsQuery) ([]types.InvocationBucket, error) {
+	merged := map[string]types.InvocationBucket{}
+	add := func(bucket types.InvocationBucket) {
+		if bucket.Granularity != q.granularity || (q.country != "" && bucket.Country != q.country) ||
+			bucket.Start.Before(q.from) || !bucket.Start.Before(q.to) {
+			return
+		}
+		id := bucket.ID()
+		bucket.Count += merged[id].Count
+		merged[id] = bucket
+	}
+
+	if s.store != nil {
+		stored, err := s.store.GetInvocationBuckets(q.from, q.to)
+		if err != nil {
+			log.Println("Could not read invocation buckets: " + err.Error())
+			return nil, err
+		}
+		for _, bucket := range stored {
+			add(bucket)
+		}
+		for _, batch := range s.queue.Pending().Invocations {
+			for _, bucket := range batch.Buckets {
+				add(bucket)
+			}
+		}
+	}
+	s.lock.RLock()
+	for key, count := range s.buckets {
+		add(key.bucket(count))
+	}
+	s.lock.RUnlock()
+
+	buckets := make([]types.InvocationBucket, 0, len(merged))
+	for _, bucket := range merged {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if !buckets[i].Start.Equal(buckets[j].Start) {
+			return buckets[i].Start.Before(buckets[j].Start)
+		}
+		return buckets[i].Country < buckets[j].Country
+	})
+	return buckets, nil
+}
+
+// getLeaderboard returns the most requested countries, by their lifetime invocation counts, or by the
+// invocation buckets if a period is given
+func (s *State) getLeaderboard(q statsQuery) ([]CountryRank, error) {
+	counts := map[string]int64{}
+	if q.period {
+		buckets, err := s.getInvocationBuckets(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range buckets {
+			counts[bucket.Country] += bucket.Count
+		}
+	} else {
+		s.lock.RLock()
+		for country, count := range s.invocationCounts {
+			counts[country] = count
+		}
+		s.lock.RUnlock()
+	}
+
+	db := s.getDataset().DB
+	leaderboard := make([]CountryRank, 0, len(counts))
+	for country, count := range counts {
+		if _, ok := db[country]; ok && count > 0 {
+			leaderboard = append(leaderboard, CountryRank{Country: country, Name: db.GetName(country), Count: count})
+		}
+	}
+	sort.Slice(leaderboard, func(i, j int) bool {
+		if leaderboard[i].Count != leaderboard[j].Count {
+			return leaderboard[i].Count > leaderboard[j].Count
+		}
+		return leaderboard[i].Country < leaderboard[j].Country
+	})
+	if len(leaderboard) > q.limit {
+		leaderboard = leaderboard[:q.limit]
+	}
+	for i := range leaderboard {
+		leaderboard[i].Rank = i + 1
+	}
+	return leaderboard, nil
+}
+
+// incrementBucketsLocked increments the hourly and daily invocation buckets of the country at `now`
+// until they are queued for the store. Without a store, the buckets are kept in memory until they are
+// pruned. The caller must hold the lock.
+func (s *State) incrementBucketsLocked(countryCode string, now time.Time) {
+	// unix time has no leap seconds, so hours and days in UTC start at multiples of their length
+	unix := now.Unix()
+	for _, granularity := range granularities {
+		length := int64(types.BucketDuration(granularity) / time.Second)
+		s.buckets[bucketKey{country: countryCode, granularity: granularity, start: unix - unix%length}]++
+	}
+	if s.queue == nil {
+		s.pruneBucketsLocked(now)
+	}
+}
+
+// pruneBucketsLocked deletes the buckets that started longer ago than the retention of their granularity,
+// at most once an hour, as the buckets are never queued without a store. The caller must hold the lock.
+func (s *State) pruneBucketsLocked(now time.Time) {
+	hour := now.Unix() - now.Unix()%int64(time.Hour/time.Second)
+	if hour == s.bucketsPruned {
+		return
+	}
+	s.bucketsPruned = hour
+	for key := range s.buckets {
+		if now.Sub(time.Unix(key.start, 0)) > statsRetention[key.granularity] {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// swapBucketDeltasLocked returns the increments of the buckets since the last call, and starts counting
+// from zero again. The caller must hold the lock.
+func (s *State) swapBucketDeltasLocked() []types.InvocationBucket {
+	buckets := make([]types.InvocationBucket, 0, len(s.buckets))
+	for key, count := range s.buckets {
+		buckets = append(buckets, key.bucket(count))
+	}
+	s.buckets = make(map[bucketKey]int64, len(s.buckets))
+	return buckets
+}
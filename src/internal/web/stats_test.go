@@ -0,0 +1,120 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatsHandler(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, WarmupConfig(0, 0))
+	server := httptest.NewServer(http.HandlerFunc(s.StatsHandler))
+	defer server.Close()
+
+	// a bucket from an earlier day that has been written to the store
+	yesterday := types.BucketStart(time.Now(), types.Daily).Add(-24 * time.Hour)
+	stored := types.InvocationBucket{Country: "SWE", Granularity: types.Daily, Start: yesterday, Count: 5}
+	_ = s.store.AddInvocations(types.InvocationBatch{Instance: "earlier", Seq: 1, Counts: map[string]int64{"SWE": 5}, Buckets: []types.InvocationBucket{stored}})
+
+	ProcessWebhookByCountry([]string{"NOR", "NOR", "SWE"}, s)
+
+	// Test 1: invocations are counted per hour and per day
+	var stats InvocationStats
+	HttpGetAndDecode(t, server.URL+StatsPath+"invocations?country=nor&granularity=hour", &stats)
+	if stats.Country != "NOR" || stats.Total != 2 || len(stats.Buckets) != 1 || stats.Buckets[0].Granularity != types.Hourly {
+		t.Fatalf("unexpected hourly statistics: %+v", stats)
+	}
+	HttpGetAndDecode(t, server.URL+StatsPath+"invocations?country=SWE", &stats)
+	if stats.Total != 6 || len(stats.Buckets) != 2 || stats.Buckets[0].Count != 5 {
+		t.Fatalf("expected stored and new daily buckets, got: %+v", stats)
+	}
+
+	// Test 2: the period can be limited by day, and the buckets are the same after they are written to the store
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	day := yesterday.Format("2006-01-02")
+	HttpGetAndDecode(t, server.URL+StatsPath+"invocations?from="+day+"&to="+day, &stats)
+	if stats.Total != 5 || len(stats.Buckets) != 1 {
+		t.Fatalf("expected only yesterday's bucket, got: %+v", stats)
+	}
+	HttpGetAndDecode(t, server.URL+StatsPath+"invocations", &stats)
+	if stats.Total != 8 || len(stats.Buckets) != 3 {
+		t.Fatalf("expected all daily buckets of the last week, got: %+v", stats)
+	}
+
+	// Test 3: the leaderboard ranks countries by lifetime counts, which include the stored invocations once
+	// they are read after a flush, or by the buckets in a period
+	var leaderboard []CountryRank
+	HttpGetAndDecode(t, server.URL+StatsPath+"top", &leaderboard)
+	if len(leaderboard) != 2 || leaderboard[0].Country != "SWE" || leaderboard[0].Rank != 1 || leaderboard[0].Name != "Sweden" || leaderboard[0].Count != 6 {
+		t.Fatalf("unexpected leaderboard: %+v", leaderboard)
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	HttpGetAndDecode(t, server.URL+StatsPath+"top?limit=1&from="+today, &leaderboard)
+	if len(leaderboard) != 1 || leaderboard[0].Country != "NOR" || leaderboard[0].Count != 2 {
+		t.Fatalf("unexpected leaderboard for the period: %+v", leaderboard)
+	}
+
+	// Test 4: invalid queries are rejected
+	for _, query := range []string{
+		"invocations?granularity=week",
+		"invocations?country=XYZ",
+		"invocations?from=2023-04-20&to=2023-04-19",
+		"invocations?from=yesterday",
+		"top?limit=0",
+		"unknown",
+	} {
+		if status := HttpGetStatusCode(t, server.URL+StatsPath+query); status != http.StatusBadRequest {
+			t.Fatalf("expected 400 Bad Request for %s, got: %d", query, status)
+		}
+	}
+}
+
+// TestBucketRetention verifies that the buckets kept in memory without a store are pruned once they are
+// older than the retention of their granularity
+func TestBucketRetention(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, WarmupConfig(0, 0))
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.incrementBucketsLocked("NOR", now.Add(-DailyStatsRetention-48*time.Hour))
+	s.incrementBucketsLocked("NOR", now.Add(-HourlyStatsRetention-2*time.Hour))
+	s.incrementBucketsLocked("NOR", now.Add(-time.Hour))
+	s.incrementBucketsLocked("NOR", now)
+
+	// the hourly and daily buckets beyond their retention are deleted, the recent ones are kept
+	kept := map[string]int{}
+	for key := range s.buckets {
+		kept[key.granularity]++
+		if now.Sub(time.Unix(key.start, 0)) > statsRetention[key.granularity] {
+			t.Fatalf("expected bucket beyond its retention to be pruned, got: %+v", key)
+		}
+	}
+	if kept[types.Hourly] != 2 || kept[types.Daily] < 2 {
+		t.Fatal("expected the recent buckets to be kept, got: ", kept)
+	}
+}
+
+func TestBucketsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/store.json"
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.JSONFile, Path: path}, WarmupConfig(0, 0))
+	ProcessWebhookByCountry([]string{"NOR"}, s)
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+
+	// counting continues in today's buckets after a restart
+	s = NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.JSONFile, Path: path}, WarmupConfig(0, 0))
+	ProcessWebhookByCountry([]string{"NOR"}, s)
+	buckets, _ := s.getInvocationBuckets(statsQuery{country: "NOR", granularity: types.Daily, from: time.Now().Add(-24 * time.Hour), to: time.Now().Add(time.Hour)})
+	if len(buckets) != 1 || buckets[0].Count != 2 {
+		t.Fatalf("expected daily bucket to continue after restart, got: %+v", buckets)
+	}
+}
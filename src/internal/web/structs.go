@@ -1,16 +1,142 @@
 package web
 
+import (
+	"assignment2/internal/journal"
+	"assignment2/internal/types"
+	"time"
+)
+
 // APIStatus holds the status information for various API components, webhook count, version, and uptime.
 type APIStatus struct {
-	Countriesapi    int    `json:"countries_api"`
-	Notification_db int    `json:"notification_db"`
-	Webhooks        int    `json:"webhooks"`
-	Version         string `json:"version"`
-	Uptime          int    `json:"uptime"`
+	Countriesapi      int                      `json:"countries_api"`
+	Notification_db   int                      `json:"notification_db"`
+	Webhooks          int                      `json:"webhooks"`
+	Version           string                   `json:"version"`
+	Uptime            int                      `json:"uptime"`
+	CountriesCache    *CountriesCacheStats     `json:"countries_cache,omitempty"`
+	CountriesProvider *CountriesProviderStatus `json:"countries_provider,omitempty"`
+	CacheSweeper      *CacheSweepStats         `json:"cache_sweeper,omitempty"`
+	Store             *StoreHealth             `json:"store,omitempty"`
+	WriteQueue        *journal.Stats           `json:"write_queue,omitempty"`
+	Snapshots         *SnapshotStats           `json:"snapshots,omitempty"`
+	WebhookDispatcher *WebhookDispatcherStats  `json:"webhook_dispatcher,omitempty"`
 }
 type WebhookResponse struct {
 	WebhookID string `json:"webhook_id"`
+	Type      string `json:"type,omitempty"` // types.RegistrationThreshold for threshold registrations, and empty otherwise
 	URL       string `json:"url,omitempty"`
 	Country   string `json:"country"`
-	Calls     int64  `json:"calls"`
+	Calls     int64  `json:"calls,omitempty"`
+	*types.ThresholdCrossing
+}
+
+// CacheEntryInfo describes an entry in the response cache on the admin endpoint
+type CacheEntryInfo struct {
+	Key       string    `json:"key"`
+	Source    string    `json:"source"`
+	Records   int       `json:"records"`
+	Age       int       `json:"age"`        // seconds since the entry was stored
+	ExpiresIn int       `json:"expires_in"` // seconds until the entry expires
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CacheDeleteResponse reports the number of entries removed from the response cache
+type CacheDeleteResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// CacheSweepStats holds the result of the latest purge of expired response cache entries
+type CacheSweepStats struct {
+	Runs        int       `json:"runs"`
+	LastRun     time.Time `json:"last_run"`
+	Purged      int       `json:"purged"`       // entries purged in the latest run
+	TotalPurged int       `json:"total_purged"` // entries purged since the service started
+	Duration    int64     `json:"duration_ms"`  // duration of the latest run in milliseconds
+	Interval    int       `json:"interval"`     // seconds between runs
+	Error       string    `json:"error,omitempty"`
+}
+
+// SnapshotStats holds the result of the latest scheduled snapshot of the store
+type SnapshotStats struct {
+	Dir      string    `json:"dir"`
+	Runs     int       `json:"runs"`
+	LastRun  time.Time `json:"last_run"`
+	LastPath string    `json:"last_path,omitempty"` // file of the latest successful snapshot
+	Interval int       `json:"interval"`            // seconds between runs
+	Keep     int       `json:"keep"`                // snapshots kept in the directory
+	Error    string    `json:"error,omitempty"`
+}
+
+// WebhookDispatcherStats holds the counters of the webhook dispatcher. Events that are dropped because the
+// queue is full indicate that the receivers of the webhooks are slower than the invocations.
+type WebhookDispatcherStats struct {
+	Workers     int   `json:"workers"`
+	Capacity    int   `json:"capacity"`     // events that can wait for delivery before new events are dropped
+	HostLimit   int   `json:"host_limit"`   // deliveries in flight to the same host
+	MaxAttempts int   `json:"max_attempts"` // attempts to post an event before it is dead-lettered
+	Pending     int   `json:"pending"`      // events waiting for a worker, for their host or to be retried
+	InFlight    int   `json:"in_flight"`
+	Retrying    int   `json:"retrying"` // events waiting for their backoff to expire
+	Hosts       int   `json:"hosts"`    // hosts with deliveries in flight or waiting
+	DeadLetters int   `json:"dead_letters"`
+	Enqueued    int64 `json:"enqueued"`
+	Delivered   int64 `json:"delivered"`
+	Retried     int64 `json:"retried"`
+	Failed      int64 `json:"failed"` // events dead-lettered after every attempt failed
+	Dropped     int64 `json:"dropped"`
+	Discarded   int64 `json:"discarded"` // events not posted, as their registration was deleted meanwhile
+}
+
+// ReplayResponse reports the dead letters that were queued again on the dead letters endpoint
+type ReplayResponse struct {
+	Replayed  int `json:"replayed"`
+	Remaining int `json:"remaining"` // dead letters left, as the webhook queue was full
+}
+
+// SecretRequest is the optional body of a request to rotate the secret of a registration. Without a
+// secret, a new one is generated, and without a grace period, the old secret verifies for SecretGracePeriod.
+type SecretRequest struct {
+	Secret      string `json:"secret"`
+	GracePeriod *int   `json:"grace_period"` // seconds the old secret keeps verifying, or 0 to stop right away
+}
+
+// SecretResponse returns the new secret of a registration once, and when the old secret stops verifying
+type SecretResponse struct {
+	WebhookID             string     `json:"webhook_id"`
+	Secret                string     `json:"secret"`
+	PreviousSecretExpires *time.Time `json:"previous_secret_expires,omitempty"`
+}
+
+// DatasetInfo reports the version of the renewables dataset on the dataset endpoint
+type DatasetInfo struct {
+	Version   string    `json:"version"` // hex encoded SHA-256 of the CSV file
+	LoadedAt  time.Time `json:"loaded_at"`
+	Countries int       `json:"countries"`
+}
+
+// DatasetReloadResponse reports a reload of the renewables dataset on the dataset endpoint
+type DatasetReloadResponse struct {
+	DatasetInfo
+	Changed          bool `json:"changed"`           // whether the contents of the CSV file changed since it was last loaded
+	CacheInvalidated int  `json:"cache_invalidated"` // cached responses removed, as they were computed from the old dataset
+	Triggered        int  `json:"triggered"`         // webhooks queued for threshold registrations whose threshold was crossed
+}
+
+// ImportResponse reports what was restored from an archive on the backup endpoint
+type ImportResponse struct {
+	Mode          string `json:"mode"`
+	Registrations int    `json:"registrations"`
+	Countries     int    `json:"countries"`
+	Buckets       int    `json:"buckets"`
+	DeadLetters   int    `json:"dead_letters"`
+	Deliveries    int    `json:"deliveries"`
+}
+
+// StoreHealth is the outcome of the health check of the store on the status endpoint
+type StoreHealth struct {
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Latency int64  `json:"latency_ms"` // duration of the lightweight read in milliseconds
+	Error   string `json:"error,omitempty"`
 }
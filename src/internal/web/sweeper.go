@@ -0,0 +1,56 @@
+package web
+
+import (
+	"log"
+	"time"
+)
+
+// cacheSweepWorker runs in the background alongside storeUpdateWorker, and purges expired entries
+// from the response cache every `interval`, so that entries which are never requested again do not
+// accumulate in the store.
+func cacheSweepWorker(s *State, interval time.Duration) {
+	defer s.workers.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sweepCache()
+		}
+	}
+}
+
+// sweepCache purges expired entries from every cache layer, and records the result for the status endpoint
+func (s *State) sweepCache() {
+	start := time.Now()
+	purged, err := s.cache.Purge()
+	duration := time.Since(start)
+	if err != nil {
+		log.Println("Could not purge expired cache entries: " + err.Error())
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweepStats.Runs++
+	s.sweepStats.LastRun = start
+	s.sweepStats.Purged = purged
+	s.sweepStats.TotalPurged += purged
+	s.sweepStats.Duration = duration.Milliseconds()
+	s.sweepStats.Error = ""
+	if err != nil {
+		s.sweepStats.Error = err.Error()
+	}
+}
+
+// getSweepStats returns the result of the latest cache sweep, or nil if the sweeper is disabled
+func (s *State) getSweepStats() *CacheSweepStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.sweepStats.Interval <= 0 {
+		return nil
+	}
+	stats := s.sweepStats
+	return &stats
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"assignment2/res"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheSweeper(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, CacheSweepConfig(time.Hour))
+	data := types.YearRecordList{{ISO: "NOR"}}
+	s.cache.Set("expiring", data, time.Millisecond)
+	s.cache.Set("valid", data, time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	// Test 1: expired entries are purged, and valid entries are kept
+	s.sweepCache()
+	stats := s.getSweepStats()
+	if stats == nil || stats.Runs != 1 || stats.Purged != 1 || stats.TotalPurged != 1 {
+		t.Fatalf("unexpected sweep stats: %+v", stats)
+	}
+	if _, ok := s.cache.Get("valid"); !ok {
+		t.Fatal("expected valid entry to be kept")
+	}
+
+	// Test 2: the result of the latest sweep is reported on the status endpoint
+	server := httptest.NewServer(http.HandlerFunc(s.StatusHandler))
+	defer server.Close()
+	status := APIStatus{}
+	HttpGetAndDecode(t, server.URL+StatusPath, &status)
+	if status.CacheSweeper == nil || status.CacheSweeper.Interval != 3600 || status.CacheSweeper.Purged != 1 {
+		t.Fatalf("unexpected cache sweeper status: %+v", status.CacheSweeper)
+	}
+
+	// Test 3: the sweeper is not reported when disabled
+	disabled := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, CacheSweepConfig(0))
+	if disabled.getSweepStats() != nil {
+		t.Fatal("expected no sweep stats when the sweeper is disabled")
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"log"
+)
+
+// evaluateThresholds compares the latest renewable share of the country of every threshold registration
+// in `db` with the share it was last evaluated with, and queues a webhook for the dispatcher for every
+// registration whose threshold was crossed in its direction. The share is then kept as the baseline of
+// the registration. With a store shared by several instances, only the instance holding the webhook lease
+// of a registration evaluates it, so that a crossing is notified once. The number of webhooks queued is
+// returned.
+func (s *State) evaluateThresholds(db types.RenewableDB) int {
+	triggered := 0
+	for _, reg := range s.getAllRegistrations() {
+		if !reg.IsThreshold() {
+			continue
+		}
+		latest := db.RetrieveLatest(reg.Country)
+		if len(latest) == 0 {
+			continue
+		}
+		value, year := latest[0].Percentage, latest[0].Year
+		if reg.LastValue != nil && *reg.LastValue == value && reg.LastYear == year {
+			continue
+		}
+
+		if s.store != nil {
+			_, owned, err := s.store.AcquireLease(types.WebhookLease(reg.WebhookID), s.instanceID, WebhookLeaseTTL, s.getInvocationCount(reg.Country))
+			if err != nil {
+				log.Println("Could not acquire webhook lease: " + err.Error())
+				continue
+			}
+			if !owned {
+				continue
+			}
+		}
+		if crossing := reg.Crossing(value, year); crossing != nil {
+			s.dispatcher.enqueue(webhookEvent{URL: reg.URL, Payload: WebhookResponse{
+				WebhookID:         reg.WebhookID,
+				Type:              types.RegistrationThreshold,
+				Country:           latest[0].Name,
+				ThresholdCrossing: crossing,
+			}})
+			triggered++
+		}
+		s.setThresholdBaseline(reg.WebhookID, value, year)
+	}
+	return triggered
+}
+
+// setThresholdBaseline stores the share a threshold registration was last evaluated with, and journals the
+// registration to be written to the store by storeUpdateWorker, unless the registration was deleted meanwhile
+func (s *State) setThresholdBaseline(webhookID string, value float64, year string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	registration, ok := s.registrations[webhookID]
+	if !ok {
+		return
+	}
+	registration.LastValue, registration.LastYear = &value, year
+	s.setRegistrationLocked(registration)
+	// the lease of the registration exists already, and is only created with the checkpoint if it was lost
+	s.queueRegistration(types.RegistrationAction{Add: true, Registration: registration, Checkpoint: s.invocationCounts[registration.Country]})
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"assignment2/internal/store"
+	"assignment2/internal/types"
+	"assignment2/res"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// TestThresholdRegistrations verifies that threshold registrations are notified when the latest renewable
+// share of their country crosses their threshold in their direction as the dataset is reloaded, and not
+// on invocations
+func TestThresholdRegistrations(t *testing.T) {
+	deliveries := make(chan WebhookResponse, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookResponse
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		deliveries <- payload
+	}))
+	defer receiver.Close()
+
+	csvPath := filepath.Join(t.TempDir(), "renewables.csv")
+	writeCSV := func(rows string) {
+		if err := os.WriteFile(csvPath, []byte("Entity,Code,Year,Renewables\n"+rows), 0o644); err != nil {
+			t.Fatal("unexpected error: ", err)
+		}
+	}
+	writeCSV("Norway,NOR,2020,40\nNorway,NOR,2021,45\nSweden,SWE,2021,60\n")
+	s := NewService(csvPath, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, AdminToken("secret"), WarmupConfig(0, 0))
+	server := httptest.NewServer(SetupRoutes("8080", s))
+	defer server.Close()
+	admin := func(method string, response any) int {
+		req, _ := http.NewRequest(method, server.URL+AdminDatasetPath, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Request to URL failed:", err.Error())
+		}
+		defer res.Body.Close()
+		_ = json.NewDecoder(res.Body).Decode(response)
+		return res.StatusCode
+	}
+
+	register := func(body string) string {
+		var created map[string]string
+		if status := HttpPostAndDecode(t, server.URL+NotificationsPath, body, &created); status != http.StatusCreated {
+			t.Fatal("expected 201 created, got: ", status)
+		}
+		return created["webhook_id"]
+	}
+	reload := func() DatasetReloadResponse {
+		var response DatasetReloadResponse
+		if status := admin(http.MethodPost, &response); status != http.StatusOK {
+			t.Fatal("expected 200 ok, got: ", status)
+		}
+		waitForDeliveries(t, s)
+		return response
+	}
+	received := func() map[string]WebhookResponse {
+		payloads := map[string]WebhookResponse{}
+		for {
+			select {
+			case payload := <-deliveries:
+				payloads[payload.WebhookID] = payload
+			default:
+				return payloads
+			}
+		}
+	}
+
+	// Test 1: threshold registrations need a threshold between 0 and 100, and no number of calls
+	for _, body := range []string{
+		"{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\" }",
+		"{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"threshold\": 100 }",
+		"{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"threshold\": 50, \"calls\": 5 }",
+		"{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"threshold\": 50, \"direction\": \"sideways\" }",
+		"{ \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"calls\": 5, \"threshold\": 50 }",
+		"{ \"type\": \"unknown\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"calls\": 5 }",
+	} {
+		if status := HttpPostStatusCode(t, server.URL+NotificationsPath, body); status != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got: %d", body, status)
+		}
+	}
+
+	// Test 2: the share of the country when registering is the baseline of the registration
+	up := register("{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"threshold\": 50, \"direction\": \"up\" }")
+	down := register("{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"threshold\": 50, \"direction\": \"down\" }")
+	either := register("{ \"type\": \"threshold\", \"url\": \"" + receiver.URL + "\", \"country\": \"NOR\", \"threshold\": 50 }")
+	var registration types.InvocationRegistration
+	HttpGetAndDecode(t, server.URL+NotificationsPath+up, &registration)
+	if !registration.IsThreshold() || registration.LastValue == nil || *registration.LastValue != 45 || registration.LastYear != "2021" {
+		t.Fatalf("expected the latest share as the baseline, got: %+v", registration)
+	}
+
+	// Test 3: reloading an unchanged dataset does not notify anything
+	if response := reload(); response.Changed || response.Triggered != 0 || response.Countries != 2 {
+		t.Fatalf("expected an unchanged dataset, got: %+v", response)
+	}
+
+	// Test 4: a share crossing the threshold upward notifies the registrations for the upward direction
+	writeCSV("Norway,NOR,2020,40\nNorway,NOR,2021,45\nNorway,NOR,2022,55\nSweden,SWE,2021,60\n")
+	if response := reload(); !response.Changed || response.Triggered != 2 {
+		t.Fatalf("expected 2 webhooks for the upward crossing, got: %+v", response)
+	}
+	payloads := received()
+	expected := types.ThresholdCrossing{Threshold: 50, Direction: types.DirectionUp, OldValue: 45, NewValue: 55, Year: "2022"}
+	if len(payloads) != 2 || payloads[up].ThresholdCrossing == nil || *payloads[up].ThresholdCrossing != expected ||
+		payloads[up].Type != types.RegistrationThreshold || payloads[up].Country != "Norway" || payloads[either].ThresholdCrossing == nil {
+		t.Fatalf("expected the upward crossing to be notified, got: %+v", payloads)
+	}
+
+	// Test 5: a share crossing the threshold downward notifies the registrations for the downward direction
+	writeCSV("Norway,NOR,2022,55\nNorway,NOR,2023,48\nSweden,SWE,2021,60\n")
+	if response := reload(); response.Triggered != 2 {
+		t.Fatalf("expected 2 webhooks for the downward crossing, got: %+v", response)
+	}
+	payloads = received()
+	ids := make([]string, 0, len(payloads))
+	for webhookID, payload := range payloads {
+		ids = append(ids, webhookID)
+		if payload.ThresholdCrossing == nil || payload.Direction != types.DirectionDown || payload.OldValue != 55 || payload.NewValue != 48 {
+			t.Fatalf("expected the downward crossing to be notified, got: %+v", payload)
+		}
+	}
+	wanted := []string{down, either}
+	sort.Strings(ids)
+	sort.Strings(wanted)
+	if len(ids) != 2 || ids[0] != wanted[0] || ids[1] != wanted[1] {
+		t.Fatal("expected the downward and either direction registrations to be notified, got: ", ids)
+	}
+
+	// Test 6: invocations of the country do not notify threshold registrations
+	ProcessWebhookByCountry([]string{"NOR", "NOR"}, s)
+	totals, err := s.flushUpdates()
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	s.dispatchWebhooks(totals)
+	waitForDeliveries(t, s)
+	if payloads := received(); len(payloads) != 0 {
+		t.Fatal("expected no webhooks for invocations, got: ", payloads)
+	}
+
+	// Test 7: a dataset that cannot be read is rejected, and the loaded dataset is kept
+	version := s.getDataset().Version
+	writeCSV("Norway,NOR,2024,not a share\n")
+	if status := admin(http.MethodPost, &DatasetReloadResponse{}); status != http.StatusInternalServerError {
+		t.Fatal("expected 500 for an invalid dataset, got: ", status)
+	}
+	var info DatasetInfo
+	admin(http.MethodGet, &info)
+	if info.Version != version || info.Countries != 2 {
+		t.Fatalf("expected the loaded dataset to be kept, got: %+v", info)
+	}
+}
+
+// TestDatasetReloadInvalidatesQueuedResponses verifies that responses computed from the previous dataset,
+// and still waiting to be written to the store, are not written after the dataset has been reloaded
+func TestDatasetReloadInvalidatesQueuedResponses(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "renewables.csv")
+	if err := os.WriteFile(csvPath, []byte("Entity,Code,Year,Renewables\nNorway,NOR,2021,45\n"), 0o644); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	s := NewService(csvPath, NewEmbeddedRestCountries(res.Embedded), WithStore{Backend: store.Memory}, AdminToken("secret"), WarmupConfig(0, 0))
+	server := httptest.NewServer(SetupRoutes("8080", s))
+	defer server.Close()
+
+	s.getRenewables(renewablesQuery{endpoint: RenewablesCurrentPath, country: "NOR"})
+	if len(s.queue.Pending().Cache) != 1 {
+		t.Fatal("expected the response to be queued for the store")
+	}
+	if err := os.WriteFile(csvPath, []byte("Entity,Code,Year,Renewables\nNorway,NOR,2021,45\nNorway,NOR,2022,55\n"), 0o644); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+AdminDatasetPath, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Request to URL failed:", err.Error())
+	}
+	var response DatasetReloadResponse
+	_ = json.NewDecoder(res.Body).Decode(&response)
+	res.Body.Close()
+	if !response.Changed || response.CacheInvalidated == 0 {
+		t.Fatalf("expected the queued response to be invalidated, got: %+v", response)
+	}
+
+	if _, err := s.flushUpdates(); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if entries, _ := s.store.GetAllCacheEntries(); len(entries) != 0 {
+		t.Fatal("expected no response of the previous dataset to be written, got: ", entries)
+	}
+}
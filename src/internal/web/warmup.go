@@ -0,0 +1,113 @@
+package web
+
+import (
+	"assignment2/internal/types"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// warmupRanges are the common periods of the history endpoint that are cached at startup, as the number
+// of years up to the latest year in the dataset, i.e. requested with `begin` only
+var warmupRanges = []int{5, 10, 20}
+
+// WarmupStatus holds the progress of the cache warm-up, reported on the readiness endpoint
+type WarmupStatus struct {
+	Ready     bool      `json:"ready"`
+	Completed int       `json:"completed"` // responses computed and cached so far
+	Total     int       `json:"total"`     // responses in the warm-up set
+	Countries []string  `json:"countries"` // the most requested countries included in the warm-up
+	Started   time.Time `json:"started,omitempty"`
+	Duration  int64     `json:"duration_ms"`
+}
+
+// warmUp pre-computes and caches the responses for the given countries, usually the most requested
+// ones according to the invocation counts loaded at startup, together with the responses for all
+// countries. For every country, the latest data with and without neighbours, the full history and the
+// history of the warmupRanges are cached. Requests served meanwhile, i.e. when the warm-up outlasts its timeout
+// at startup, are computed when they miss the cache, so the service reports that it is not ready until then.
+func (s *State) warmUp(countries []string) {
+	queries := []renewablesQuery{
+		{endpoint: RenewablesCurrentPath},
+		{endpoint: RenewablesHistoryPath},
+	}
+	for _, country := range countries {
+		queries = append(queries,
+			renewablesQuery{endpoint: RenewablesCurrentPath, country: country},
+			renewablesQuery{endpoint: RenewablesCurrentPath, country: country, neighbours: true},
+			renewablesQuery{endpoint: RenewablesHistoryPath, country: country},
+		)
+	}
+	if latest := latestYear(s.getDataset().DB); latest > 0 {
+		for _, years := range warmupRanges {
+			queries = append(queries, renewablesQuery{endpoint: RenewablesHistoryPath, begin: latest - years + 1})
+			for _, country := range countries {
+				queries = append(queries, renewablesQuery{endpoint: RenewablesHistoryPath, country: country, begin: latest - years + 1})
+			}
+		}
+	}
+
+	s.lock.Lock()
+	s.warmup = WarmupStatus{Total: len(queries), Countries: countries, Started: time.Now()}
+	s.lock.Unlock()
+
+	for _, query := range queries {
+		s.getRenewables(query)
+		s.lock.Lock()
+		s.warmup.Completed++
+		s.lock.Unlock()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.warmup.Ready = true
+	s.warmup.Duration = time.Since(s.warmup.Started).Milliseconds()
+	log.Printf("Warmed up the cache with %d responses in %d ms", s.warmup.Completed, s.warmup.Duration)
+}
+
+// latestYear returns the most recent year in the dataset, or 0 if it has no records
+func latestYear(db types.RenewableDB) int {
+	latest := 0
+	for _, record := range db.RetrieveLatest("") {
+		if year, err := strconv.Atoi(record.Year); err == nil && year > latest {
+			latest = year
+		}
+	}
+	return latest
+}
+
+// topCountries returns up to `size` country codes with the highest invocation counts, most requested
+// first. Countries that are not in the dataset are skipped.
+func (s *State) topCountries(size int) []string {
+	db := s.getDataset().DB
+	s.lock.RLock()
+	countries := make([]string, 0, len(s.invocationCounts))
+	for country := range s.invocationCounts {
+		if _, ok := db[country]; ok {
+			countries = append(countries, country)
+		}
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		ci, cj := s.invocationCounts[countries[i]], s.invocationCounts[countries[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return countries[i] < countries[j]
+	})
+	s.lock.RUnlock()
+
+	if len(countries) > size {
+		countries = countries[:size]
+	}
+	return countries
+}
+
+// getWarmupStatus returns a snapshot of the warm-up progress
+func (s *State) getWarmupStatus() WarmupStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	status := s.warmup
+	status.Countries = append([]string{}, s.warmup.Countries...)
+	return status
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"assignment2/res"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheWarmup(t *testing.T) {
+	s := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, WarmupConfig(0, 0))
+	s.invocationCounts = map[string]int64{"NOR": 5, "SWE": 9, "FIN": 1, "XYZ": 100}
+	server := httptest.NewServer(http.HandlerFunc(s.ReadinessHandler))
+	defer server.Close()
+
+	// Test 1: the most requested countries in the dataset are selected
+	top := s.topCountries(2)
+	if len(top) != 2 || top[0] != "SWE" || top[1] != "NOR" {
+		t.Fatal("expected SWE and NOR to be the most requested countries, got: ", top)
+	}
+
+	// Test 2: the service is not ready while warming up
+	s.warmup.Ready = false
+	response, err := http.Get(server.URL + ReadinessPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable || response.Header.Get("content-type") != "application/json" {
+		t.Fatal("expected a JSON 503 while warming up, got: ", response.StatusCode, response.Header.Get("content-type"))
+	}
+
+	// Test 3: responses for the countries and for all countries, including the common ranges of the
+	// history, are cached, and the service is ready
+	s.warmUp(top)
+	status := WarmupStatus{}
+	HttpGetAndDecode(t, server.URL+ReadinessPath, &status)
+	if !status.Ready || status.Completed != 17 || status.Total != 17 {
+		t.Fatalf("unexpected warm-up status: %+v", status)
+	}
+	if HttpGetStatusCode(t, server.URL+ReadinessPath) != http.StatusOK {
+		t.Fatal("expected 200 after warm-up")
+	}
+	neighbours := renewablesQuery{endpoint: RenewablesCurrentPath, country: "NOR", neighbours: true}
+	if _, ok := s.cache.Get(neighbours.key(s.getDataset())); !ok {
+		t.Fatal("expected NOR with neighbours to be cached")
+	}
+	lastTenYears := renewablesQuery{endpoint: RenewablesHistoryPath, country: "SWE", begin: latestYear(s.getDataset().DB) - 9}
+	if _, ok := s.cache.Get(lastTenYears.key(s.getDataset())); !ok {
+		t.Fatal("expected the last 10 years of SWE to be cached")
+	}
+	if len(s.cache.Entries()) != 17 {
+		t.Fatal("expected 17 cached responses, got: ", len(s.cache.Entries()))
+	}
+
+	// Test 4: the service is warmed up before it is returned, and thereby before it accepts requests
+	started := NewService(res.Embedded, NewEmbeddedRestCountries(res.Embedded), WithoutFirestore{}, WarmupConfig(2, time.Minute))
+	if status := started.getWarmupStatus(); !status.Ready || status.Completed != status.Total || len(started.cache.Entries()) == 0 {
+		t.Fatalf("expected the warm-up to complete at startup, got: %+v", status)
+	}
+}
@@ -1,27 +1,34 @@
 package web
 
 import (
-	"assignment2/internal/firebase_client"
+	"assignment2/internal/signing"
 	"assignment2/internal/types"
 	"assignment2/internal/web_client"
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // ProcessWebhookByCountry is a function that processes a list of country codes, increments their invocation count,
-// and triggers webhooks accordingly.
+// and triggers webhooks accordingly. Without a store, this instance counts every invocation, and queues the
+// webhooks for the dispatcher right away. With a store, the counts may be shared with other instances, and the webhooks are
+// dispatched by storeUpdateWorker once the invocations have been added to the store.
 func ProcessWebhookByCountry(ccna3 []string, s *State) {
 	for _, code := range ccna3 {
 		newCount := s.incrementInvocationCount(code)
-		updateFirestore(s.chInvocation, code)
-		triggerWebhooksForCountry(code, newCount, s.db.GetName(code), s)
+		if s.store == nil {
+			triggerWebhooksForCountry(code, newCount, s.getDataset().DB.GetName(code), s)
+		}
 	}
 }
 
@@ -51,53 +58,119 @@ func generateWebhookID(s *State) string {
 	return string(b)
 }
 
-// firebaseUpdateWorker is a function that runs in the background and periodically
-// sends updates to Firebase. It listens for invocation count and registration
-// updates and sends them to Firebase in bulk every FirebaseUpdateFreq seconds.
-func firebaseUpdateWorker(s *State) {
-	// Create a new ticker to trigger Firebase updates at a fixed interval
-	ticker := time.NewTicker(FirebaseUpdateFreq * time.Second)
-
-	// Create a new Firebase client
-	client, err := firebase_client.NewFirebaseClient()
-	if err != nil {
-		log.Fatal("Could not start firebase client")
-	}
-	defer client.Close()
+// storeUpdateWorker is a function that runs in the background and periodically
+// sends updates to the store. Registrations and cached responses are queued in the
+// journal as they happen, while invocations are coalesced in memory and queued as
+// one batch on every tick. The updates are sent to the store in bulk every
+// FirebaseUpdateFreq seconds until the service is closed, and the webhooks are then
+// dispatched. Updates that could not be sent stay in the journal and are retried.
+func storeUpdateWorker(s *State) {
+	defer s.workers.Done()
 
-	// Initialize an empty bundled update to store updates before sending them to Firebase
-	updates := firebase_client.NewBundledUpdate()
+	// Create a new ticker to trigger store updates at a fixed interval
+	ticker := time.NewTicker(FirebaseUpdateFreq * time.Second)
+	defer ticker.Stop()
 
-	// Start an infinite loop to listen for updates and send them to Firebase
 	for {
 		select {
-		// If there's a new invocation count update, add it to the updates
-		// and set the Ready flag to indicate that updates are available
-		case countryCode := <-s.chInvocation:
-			updates.InvocationCount[countryCode] = s.getInvocationCount(countryCode)
-			updates.Ready = true
-
-		// If there's a new registration update, add it to the updates
-		// and set the Ready flag to indicate that updates are available
-		case action := <-s.chRegistration:
-			updates.Registrations[action.Registration.WebhookID] = action
-			updates.Ready = true
-
-		case cache := <-s.chCache:
-			for key, value := range cache {
-				updates.Cache[key] = value
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			totals, err := s.flushUpdates()
+			if err != nil {
+				log.Println("Could not write updates to store: " + err.Error())
+				continue
 			}
-			updates.Ready = true
+			s.dispatchWebhooks(totals)
+		}
+	}
+}
 
-		// When the ticker triggers, check if there are updates to send and
-		// send them to Firebase in bulk if there are. Reset the updates
-		// and Ready flag afterward.
-		case <-ticker.C:
-			if updates.Ready {
-				client.BulkWrite(updates)
-				updates = firebase_client.NewBundledUpdate()
+// flushUpdates queues the invocations since the last flush, and sends the queued updates to the store in
+// bulk. The registrations are then read from the store, to include those created, deleted or changed by
+// other instances, and so are the invocation counts of the countries that were invocated or have
+// registrations, to include the invocations of other instances. The counts are returned.
+func (s *State) flushUpdates() (map[string]int64, error) {
+	s.flush.Lock()
+	defer s.flush.Unlock()
+	return s.flushUpdatesLocked()
+}
+
+// flushUpdatesLocked is flushUpdates for callers holding the flush lock
+func (s *State) flushUpdatesLocked() (map[string]int64, error) {
+	batch := s.queueCountDeltas()
+	if err := s.queue.Flush(s.store.BulkWrite); err != nil {
+		return nil, err
+	}
+	if err := s.refreshRegistrations(); err != nil {
+		return nil, err
+	}
+
+	countries := make([]string, 0, len(batch.Counts))
+	for countryCode := range batch.Counts {
+		countries = append(countries, countryCode)
+	}
+	for _, countryCode := range s.getRegisteredCountries() {
+		if _, ok := batch.Counts[countryCode]; !ok {
+			countries = append(countries, countryCode)
+		}
+	}
+	if len(countries) == 0 {
+		return map[string]int64{}, nil
+	}
+	return s.refreshInvocationCounts(countries)
+}
+
+// dispatchWebhooks queues the webhooks of the registrations whose lease this instance holds for the
+// dispatcher, for every multiple of their number of calls that the invocation count of their country has
+// passed since the last dispatch by any instance. `totals` are the invocation counts in the store. The
+// checkpoint of the lease is stored before the webhooks are queued, so that they are posted at most once,
+// even when the lease moves to another instance.
+func (s *State) dispatchWebhooks(totals map[string]int64) {
+	for countryCode, total := range totals {
+		if total > 0 {
+			s.dispatchWebhooksForCountry(countryCode, total)
+		}
+	}
+}
+
+// dispatchWebhooksForCountry is dispatchWebhooks for the registrations of a single country
+func (s *State) dispatchWebhooksForCountry(countryCode string, total int64) {
+	name := s.getDataset().DB.GetName(countryCode)
+	for _, reg := range s.getRegistrationsByCountry(countryCode) {
+		if reg.IsThreshold() {
+			continue
+		}
+		s.lock.RLock()
+		dispatched, ok := s.dispatched[reg.WebhookID]
+		s.lock.RUnlock()
+		if ok && dispatched == total {
+			continue
+		}
+
+		lease, owned, err := s.store.AcquireLease(types.WebhookLease(reg.WebhookID), s.instanceID, WebhookLeaseTTL, total)
+		if err != nil {
+			log.Println("Could not acquire webhook lease: " + err.Error())
+			continue
+		}
+		if owned && lease.Checkpoint < total {
+			from := lease.Checkpoint
+			lease.Checkpoint = total
+			if err := s.store.UpdateLease(lease); err != nil {
+				log.Println("Could not update webhook lease: " + err.Error())
+				continue
 			}
+			for calls := (from/reg.Calls + 1) * reg.Calls; calls <= total; calls += reg.Calls {
+				s.dispatcher.enqueue(webhookEvent{URL: reg.URL, Payload: WebhookResponse{WebhookID: reg.WebhookID, Country: name, Calls: calls}})
+			}
+		}
+
+		// the count is only dispatched again when it has changed, unless the registration was deleted meanwhile
+		s.lock.Lock()
+		if _, ok := s.registrations[reg.WebhookID]; ok {
+			s.dispatched[reg.WebhookID] = total
 		}
+		s.lock.Unlock()
 	}
 }
 
@@ -116,25 +189,113 @@ func registerWebhook(w http.ResponseWriter, r *http.Request, s *State) {
 	}
 
 	// validating the JSON input
+	if data.Type == "" {
+		data.Type = types.RegistrationCalls
+	}
 	if err := validateRegistrationData(data, s); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// threshold registrations are notified of crossings from the latest share in the current dataset
+	data.LastValue, data.LastYear = nil, ""
+	if data.IsThreshold() {
+		if latest := s.getDataset().DB.RetrieveLatest(data.Country); len(latest) > 0 {
+			data.LastValue, data.LastYear = &latest[0].Percentage, latest[0].Year
+		}
+	}
+
+	// the webhooks are signed with the secret supplied by the client, or with a generated one, which
+	// is only returned in this response
+	if data.Secret == "" {
+		data.Secret = signing.NewSecret()
+	}
+	data.PreviousSecret, data.PreviousSecretExpires = "", nil
+
 	// adding registration to data structure and notifying firestore that the registration
 	// can be backup up
 	data.WebhookID = generateWebhookID(s)
 	s.newRegistration(data)
-	w.WriteHeader(http.StatusCreated)
-	httpRespondJSON(w, map[string]interface{}{"webhook_id": data.WebhookID}, nil)
+	httpRespondJSONStatus(w, http.StatusCreated, map[string]interface{}{"webhook_id": data.WebhookID, "secret": data.Secret})
+}
+
+// errSecretUnauthorized is returned by rotateRegistrationSecret when the caller holds neither the current
+// secret of the registration nor the admin token
+var errSecretUnauthorized = errors.New("rotating the secret requires the current secret of the webhook or the admin token as a bearer token")
+
+// rotateSecret is an HTTP handler function that replaces the secret of a registration with the secret
+// in the optional JSON request, or with a generated one. The caller must present the current secret of
+// the registration, or the admin token, as a bearer token, since webhook IDs are listed publicly. The old
+// secret keeps verifying the webhooks during the grace period. The new secret is returned once.
+func rotateSecret(w http.ResponseWriter, r *http.Request, webhookID string, s *State) {
+	var data SecretRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateSecret(data.Secret); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	grace := SecretGracePeriod
+	if data.GracePeriod != nil {
+		if *data.GracePeriod < 0 {
+			http.Error(w, "grace period must be 0 seconds or more", http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(*data.GracePeriod) * time.Second
+	}
+	if data.Secret == "" {
+		data.Secret = signing.NewSecret()
+	}
+
+	admin := s.authorizeAdmin(r)
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	authorized := func(current types.InvocationRegistration) bool {
+		return admin || current.Secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(current.Secret)) == 1
+	}
+	registration, err := s.rotateRegistrationSecret(webhookID, data.Secret, grace, authorized)
+	if errors.Is(err, errSecretUnauthorized) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	httpRespondJSON(w, SecretResponse{
+		WebhookID:             registration.WebhookID,
+		Secret:                registration.Secret,
+		PreviousSecretExpires: registration.PreviousSecretExpires,
+	}, nil)
 }
 
 // validateRegistrationData is a function that takes an InvocationRegistration
 // struct as input and returns an error if the registration data is invalid.
 func validateRegistrationData(registration types.InvocationRegistration, s *State) error {
-	// Check if the number of calls is less than 1. If so, return an error.
-	if registration.Calls < 1 {
-		return errors.New("number of calls must be 1 or higher")
+	switch registration.Type {
+	case types.RegistrationCalls:
+		// Check if the number of calls is less than 1. If so, return an error.
+		if registration.Calls < 1 {
+			return errors.New("number of calls must be 1 or higher")
+		}
+		if registration.Threshold != 0 || registration.Direction != "" {
+			return errors.New("threshold and direction are only supported by registrations of type " + types.RegistrationThreshold)
+		}
+	case types.RegistrationThreshold:
+		// Check that the threshold is a share strictly between 0 and 100 percent, which can be crossed
+		if registration.Calls != 0 {
+			return errors.New("calls is only supported by registrations of type " + types.RegistrationCalls)
+		}
+		if registration.Threshold <= 0 || registration.Threshold >= 100 {
+			return errors.New("threshold must be between 0 and 100")
+		}
+		if registration.Direction != "" && registration.Direction != types.DirectionUp && registration.Direction != types.DirectionDown {
+			return errors.New("direction must be " + types.DirectionUp + " or " + types.DirectionDown)
+		}
+	default:
+		return errors.New("type must be " + types.RegistrationCalls + " or " + types.RegistrationThreshold)
 	}
 
 	// Check if the URL is properly formatted with http:// or https:// prefix.
@@ -145,25 +306,43 @@ func validateRegistrationData(registration types.InvocationRegistration, s *Stat
 
 	// Check if the country is recognized (i.e., if it exists in the invocationCount map).
 	// If not, return an error.
-	if _, ok := s.db[registration.Country]; !ok {
+	if _, ok := s.getDataset().DB[registration.Country]; !ok {
 		return errors.New("country not recognized")
 	}
 
+	// Check that a secret supplied by the client is long enough to sign the webhooks with.
+	if err := validateSecret(registration.Secret); err != nil {
+		return err
+	}
+
 	// If all checks pass, return nil, indicating no error occurred.
 	return nil
 }
 
+// validateSecret returns an error if a secret supplied by the client is too short. An empty secret is
+// valid, as one is generated instead.
+func validateSecret(secret string) error {
+	if secret != "" && len(secret) < MinSecretLength {
+		return fmt.Errorf("secret must be at least %d characters", MinSecretLength)
+	}
+	return nil
+}
+
 // listAllWebhooks is a function that retrieves all registered webhooks
-// and sends them as a JSON response to the client.
+// and sends them as a JSON response to the client, without their secrets.
 func listAllWebhooks(w http.ResponseWriter, s *State) {
-	httpRespondJSON(w, s.getAllRegistrations(), nil)
+	registrations := s.getAllRegistrations()
+	for i := range registrations {
+		registrations[i] = registrations[i].Redacted()
+	}
+	httpRespondJSON(w, registrations, nil)
 }
 
 // ListWebhooksByID is a function that retrieves a registered webhook by its ID
-// and sends it as a JSON response to the client if found, otherwise it sends an error.
+// and sends it as a JSON response to the client, without its secrets, if found, otherwise it sends an error.
 func ListWebhooksByID(w http.ResponseWriter, webhookID string, s *State) {
 	if reg, ok := s.getRegistration(webhookID); ok {
-		httpRespondJSON(w, reg, nil)
+		httpRespondJSON(w, reg.Redacted(), nil)
 		return
 	}
 	http.Error(w, "Could not find the webhook ID: "+webhookID, http.StatusBadRequest)
@@ -181,39 +360,52 @@ func RemoveWebhookByID(w http.ResponseWriter, webhookID string, s *State) {
 	}
 }
 
-// triggerWebhooksForCountry is a function that iterates through all registered webhooks
-// and triggers them if the specified country code matches and the call count
-// reaches the specified threshold.
-//
-// TODO: Refactor to use a worker thread!
-// This is not the best way to handle webhooks, as it needs to check
-// every registration every time a country code is invoked.
+// triggerWebhooksForCountry is a function that looks up the registrations for the country code,
+// and queues their webhooks for the dispatcher if the call count reaches their threshold.
 func triggerWebhooksForCountry(countrycode string, count int64, name string, s *State) {
-	for _, reg := range s.getAllRegistrations() {
-		if reg.Country == countrycode {
-			if count > 0 && count%reg.Calls == 0 {
-				postToWebhook(reg.URL, WebhookResponse{
-					WebhookID: reg.WebhookID,
-					Country:   name,
-					Calls:     count,
-				})
-			}
+	if count <= 0 {
+		return
+	}
+	for _, reg := range s.getRegistrationsByCountry(countrycode) {
+		if !reg.IsThreshold() && count%reg.Calls == 0 {
+			s.dispatcher.enqueue(webhookEvent{URL: reg.URL, Payload: WebhookResponse{
+				WebhookID: reg.WebhookID,
+				Country:   name,
+				Calls:     count,
+			}})
 		}
 	}
 }
 
 // postToWebhook is a function that sends a POST request to the specified webhook URL
-// with the provided registration data as the request body.
-func postToWebhook(url string, registration WebhookResponse) {
+// with the provided registration data as the request body, signed with the secrets if there
+// are any. The request is aborted when `ctx` is cancelled. The status code of the response is
+// returned, or 0 if there was none. An error is returned if the webhook could not be posted, or
+// if the receiver did not respond with a 2xx status code.
+func postToWebhook(ctx context.Context, url string, registration WebhookResponse, secrets []string) (int, error) {
 	client := web_client.NewClient()
-	err := client.SetURL(url)
-	if err != nil {
-		return
+	if err := client.SetURL(url); err != nil {
+		return 0, err
 	}
+	client.SetContext(ctx)
+	client.SetTimeout(WebhookTimeout)
 	var buf bytes.Buffer
-	err = json.NewEncoder(&buf).Encode(registration)
+	if err := json.NewEncoder(&buf).Encode(registration); err != nil {
+		return 0, err
+	}
+	if len(secrets) > 0 {
+		timestamp := time.Now().Unix()
+		client.SetHeader(signing.TimestampHeader, strconv.FormatInt(timestamp, 10))
+		client.SetHeader(signing.SignatureHeader, signing.Header(secrets, timestamp, buf.Bytes()))
+	}
+	res, err := client.Post(&buf)
 	if err != nil {
-		log.Println("Could not encode to json, ", err.Error())
+		return 0, err
+	}
+	// the body must be closed for the connection to be reused
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, &web_client.StatusError{StatusCode: res.StatusCode, Status: res.Status}
 	}
-	_, _ = client.Post(&buf)
+	return res.StatusCode, nil
 }
@@ -0,0 +1,83 @@
+package web_client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without performing the request when a host has failed too many times in a row
+var ErrCircuitOpen = errors.New("client: circuit breaker is open for host")
+
+var (
+	breakerThreshold = 5                // consecutive failures before the circuit opens
+	breakerCooldown  = 30 * time.Second // time the circuit stays open before a trial request is let through
+	breakers         = map[string]*circuitBreaker{}
+	breakersLock     sync.Mutex
+)
+
+// SetBreakerPolicy sets the number of consecutive failures before a host's circuit opens,
+// and for how long it stays open. Applies to all hosts.
+func SetBreakerPolicy(threshold int, cooldown time.Duration) {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	breakerThreshold = threshold
+	breakerCooldown = cooldown
+}
+
+// circuitBreaker keeps track of consecutive failures for a single host. When the threshold is
+// reached the circuit opens, and requests fail immediately until the cooldown has passed.
+// A single trial request is then let through (half-open), which either closes or re-opens the circuit.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// breakerFor returns the circuit breaker for the given host, creating it if needed
+func breakerFor(host string) *circuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	breaker, ok := breakers[host]
+	if !ok {
+		breaker = &circuitBreaker{}
+		breakers[host] = breaker
+	}
+	return breaker
+}
+
+// allow returns ErrCircuitOpen if requests to the host should not be performed
+func (b *circuitBreaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return ErrCircuitOpen
+	}
+	// cooldown has passed, let a single trial request through
+	b.trial = true
+	return nil
+}
+
+// record registers the outcome of a request
+func (b *circuitBreaker) record(success bool) {
+	breakersLock.Lock()
+	threshold, cooldown := breakerThreshold, breakerCooldown
+	breakersLock.Unlock()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
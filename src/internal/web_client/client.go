@@ -1,21 +1,74 @@
 package web_client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"time"
 )
 
+const (
+	DefaultTimeout    = 10 * time.Second       // deadline for a single attempt of a request
+	DefaultRetries    = 2                      // number of retries for idempotent requests
+	DefaultBackoff    = 100 * time.Millisecond // base delay before the first retry
+	MaxBackoff        = 2 * time.Second        // upper limit for the delay between retries
+	MaxIdleConns      = 100                    // idle connections kept in the shared pool
+	MaxIdleConnsHost  = 10                     // idle connections kept per host in the shared pool
+	IdleConnTimeout   = 90 * time.Second       // time before an idle connection is closed
+	DialTimeout       = 5 * time.Second        // time allowed for establishing a connection
+	TLSHandshakeLimit = 5 * time.Second        // time allowed for the TLS handshake
+)
+
+// sharedClient is used by every Client, so that connections are pooled across requests
+var sharedClient = &http.Client{Transport: newTransport()}
+
+// newTransport returns a transport with connection pooling and timeouts for dialing and TLS
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.MaxIdleConns = MaxIdleConns
+	transport.MaxIdleConnsPerHost = MaxIdleConnsHost
+	transport.IdleConnTimeout = IdleConnTimeout
+	transport.TLSHandshakeTimeout = TLSHandshakeLimit
+	return transport
+}
+
+// StatusError is returned when a server responds with another status code than expected
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("restclient: expected status code 200 OK but got %s instead. output set to nil", e.Status)
+}
+
 type Client struct {
-	URL    *url.URL // url to perform requests on.
-	header http.Header
+	URL     *url.URL // url to perform requests on.
+	header  http.Header
+	ctx     context.Context
+	timeout time.Duration
+	retries int
+	backoff time.Duration
 }
 
 // Construct a new instance of Client.
 func NewClient() *Client {
-	return &Client{URL: &url.URL{}, header: http.Header{}}
+	return &Client{
+		URL:     &url.URL{},
+		header:  http.Header{},
+		ctx:     context.Background(),
+		timeout: DefaultTimeout,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
 }
 
 // Sends request and returns status code.
@@ -84,24 +137,110 @@ func (client *Client) ClearQuery() {
 	client.URL.RawQuery = ""
 }
 
-// Instantiates a request, http client, and performs request.
+// Set a header that is sent with every request.
+func (client *Client) SetHeader(key string, value string) {
+	client.header.Set(key, value)
+}
+
+// Set the context that requests are performed with.
+// Cancelling the context aborts the request, including any retries.
+func (client *Client) SetContext(ctx context.Context) {
+	client.ctx = ctx
+}
+
+// Set the deadline for each attempt of a request. Zero disables the deadline.
+func (client *Client) SetTimeout(timeout time.Duration) {
+	client.timeout = timeout
+}
+
+// Set the number of retries for idempotent requests, and the base delay before the first retry.
+// The delay doubles for every retry, with jitter, up to MaxBackoff.
+func (client *Client) SetRetries(retries int, backoff time.Duration) {
+	client.retries = retries
+	client.backoff = backoff
+}
+
+// Performs request, retrying idempotent requests that fail with a network error or
+// a 5xx/429 status code. Requests to a host whose circuit breaker is open fail immediately.
 func (client *Client) Do(method string, reader io.Reader) (*http.Response, error) {
+	// buffer body, so that it can be sent again on retries
+	var body []byte
+	if reader != nil {
+		var err error
+		if body, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	retries := 0
+	if isIdempotent(method) {
+		retries = client.retries
+	}
+
+	breaker := breakerFor(client.URL.Host)
+	for attempt := 0; ; attempt++ {
+		if err := breaker.allow(); err != nil {
+			return nil, err
+		}
+
+		res, err := client.attempt(method, body)
+		breaker.record(err == nil && res.StatusCode < http.StatusInternalServerError)
+
+		// return response if successful, or if there are no retries left
+		if attempt >= retries || !shouldRetry(res, err) {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		// wait before next attempt, unless the context is cancelled
+		select {
+		case <-client.ctx.Done():
+			return nil, client.ctx.Err()
+		case <-time.After(client.delay(attempt)):
+		}
+	}
+}
+
+// attempt performs a single request with the client's context and deadline
+func (client *Client) attempt(method string, body []byte) (*http.Response, error) {
+	ctx, cancel := client.ctx, context.CancelFunc(func() {})
+	if client.timeout > 0 {
+		ctx, cancel = context.WithTimeout(client.ctx, client.timeout)
+	}
+
 	// make request
-	r, e := http.NewRequest(method, client.URL.String(), reader)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	r, e := http.NewRequestWithContext(ctx, method, client.URL.String(), reader)
 	if e != nil {
+		cancel()
 		return nil, e
 	}
 	r.Header = client.header.Clone()
 
-	// instantiate client
-	c := &http.Client{}
-	defer c.CloseIdleConnections()
+	// issue request
+	res, err := sharedClient.Do(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
 
-	fmt.Printf("Performing %v request on URL: %v\n", method, r.URL.String())
+	// the deadline must last until the body has been read
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
 
-	// issue request
-	res, err := c.Do(r)
-	return res, err
+// delay returns the exponential backoff with full jitter for the given attempt
+func (client *Client) delay(attempt int) time.Duration {
+	backoff := client.backoff << attempt
+	if backoff <= 0 || backoff > MaxBackoff {
+		backoff = MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
 }
 
 // Wrapper method for Do.
@@ -136,7 +275,7 @@ func (client *Client) GetAndDecode(output any) error {
 	// fail if not ok
 	if res.StatusCode != http.StatusOK {
 		output = nil
-		return fmt.Errorf("restclient: expected status code 200 OK but got %s instead. output set to nil", res.Status)
+		return &StatusError{StatusCode: res.StatusCode, Status: res.Status}
 	}
 
 	// decode json data
@@ -145,3 +284,32 @@ func (client *Client) GetAndDecode(output any) error {
 
 	return err
 }
+
+// cancelOnClose releases the request context when the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// isIdempotent returns true for methods that are safe to send more than once
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry returns true if the request failed in a way that might succeed on another attempt
+func shouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, context.Canceled)
+	}
+	return res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests
+}
@@ -1,8 +1,15 @@
 package web_client
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSetURL(t *testing.T) {
@@ -75,3 +82,110 @@ func TestClearQuery(t *testing.T) {
 		t.Errorf("c.URL.String() = %v, want %v", result, want)
 	}
 }
+
+func TestRetries(t *testing.T) {
+	attempts := atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Test 1: idempotent requests are retried until they succeed
+	c := NewClient()
+	c.SetURL(server.URL)
+	c.SetRetries(2, time.Millisecond)
+	res, err := c.Get()
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("expected request to succeed after retries, got error: %v", err)
+	}
+	res.Body.Close()
+	if attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts.Load())
+	}
+
+	// Test 2: POST requests are not retried
+	attempts.Store(0)
+	res, err = c.Post(strings.NewReader("{}"))
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected POST to fail without retries, got error: %v", err)
+	}
+	res.Body.Close()
+	if attempts.Load() != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts.Load())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient()
+	c.SetURL(server.URL)
+	c.SetTimeout(20 * time.Millisecond)
+	c.SetRetries(0, 0)
+	if _, err := c.Get(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline to be exceeded, got: %v", err)
+	}
+
+	// a cancelled context aborts the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.SetContext(ctx)
+	if _, err := c.Get(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected request to be cancelled, got: %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	SetBreakerPolicy(2, 50*time.Millisecond)
+	defer SetBreakerPolicy(5, 30*time.Second)
+
+	healthy := atomic.Bool{}
+	attempts := atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient()
+	c.SetURL(server.URL)
+	c.SetRetries(0, 0)
+
+	// Test 1: the circuit opens after two consecutive failures
+	for i := 0; i < 2; i++ {
+		if res, err := c.Get(); err == nil {
+			res.Body.Close()
+		}
+	}
+	if _, err := c.Get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit to be open, got: %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("expected no request while circuit is open, got %d attempts", attempts.Load())
+	}
+
+	// Test 2: after the cooldown a trial request closes the circuit again
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	res, err := c.Get()
+	if err != nil {
+		t.Fatalf("expected trial request to be let through, got: %v", err)
+	}
+	res.Body.Close()
+	if res, err = c.Get(); err != nil {
+		t.Fatalf("expected circuit to be closed, got: %v", err)
+	}
+	res.Body.Close()
+}
@@ -0,0 +1,30 @@
+// Package res holds the datasets that are compiled into the binary.
+package res
+
+import (
+	"bytes"
+	_ "embed"
+	"io"
+	"os"
+)
+
+// Embedded can be given instead of a file path to use the dataset compiled into the binary
+const Embedded = ""
+
+// RenewablesCSV is the renewable share energy dataset
+//
+//go:embed renewable-share-energy.csv
+var RenewablesCSV []byte
+
+// RestCountriesJSON is the REST Countries dataset, as returned by the `all` endpoint
+//
+//go:embed rest_countries.json
+var RestCountriesJSON []byte
+
+// Open returns the file at `filepath`, or the `embedded` dataset if filepath is Embedded
+func Open(filepath string, embedded []byte) (io.ReadCloser, error) {
+	if filepath == Embedded {
+		return io.NopCloser(bytes.NewReader(embedded)), nil
+	}
+	return os.Open(filepath)
+}